@@ -0,0 +1,271 @@
+package accounts
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+)
+
+const (
+	// passwdFile - User database enumerated for ~* paths and group members
+	passwdFile = "/etc/passwd"
+	// groupFile - Group database listing supplementary members
+	groupFile = "/etc/group"
+	// loginDefsFile - Defines the UID range of regular users
+	loginDefsFile = "/etc/login.defs"
+
+	// defaultUIDMin, defaultUIDMax - Regular user UIDs if login.defs does
+	// not say
+	defaultUIDMin = 1000
+	defaultUIDMax = 60000
+)
+
+// Account - User with a home directory
+type Account struct {
+	Name  string // User name
+	UID   int    // User ID
+	GID   int    // Primary group ID
+	Group string // Primary group name (the GID if it has no name)
+	Home  string // Home directory
+}
+
+// entry - Line of the passwd file
+type entry struct {
+	Account
+	shell string
+}
+
+// readPasswd - Entries of the passwd file
+func readPasswd() ([]entry, error) {
+	f, err := os.Open(passwdFile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []entry
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) < 7 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		uid, err := strconv.Atoi(fields[2])
+		if err != nil {
+			continue
+		}
+		gid, err := strconv.Atoi(fields[3])
+		if err != nil {
+			continue
+		}
+
+		entries = append(entries, entry{Account{Name: fields[0], UID: uid, GID: gid, Home: fields[5]}, fields[6]})
+	}
+	return entries, scanner.Err()
+}
+
+// uidRange - UID_MIN and UID_MAX of login.defs
+func uidRange() (min int, max int) {
+	min, max = defaultUIDMin, defaultUIDMax
+
+	f, err := os.Open(loginDefsFile)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 {
+			continue
+		}
+
+		value, err := strconv.Atoi(fields[1])
+		if err != nil {
+			continue
+		}
+
+		switch fields[0] {
+		case "UID_MIN":
+			min = value
+		case "UID_MAX":
+			max = value
+		}
+	}
+	return
+}
+
+// canLogIn - Whether shell lets the user log in
+func canLogIn(shell string) bool {
+	return shell != "" && !strings.HasSuffix(shell, "/nologin") && !strings.HasSuffix(shell, "/false")
+}
+
+// hasHome - Whether home is an existing directory other than /
+func hasHome(home string) bool {
+	if home == "" || home == "/" {
+		return false
+	}
+	info, err := os.Stat(home)
+	return err == nil && info.IsDir()
+}
+
+// withGroup - Account with the name of its primary group filled in
+func withGroup(a Account) Account {
+	a.Group = strconv.Itoa(a.GID)
+	g, err := user.LookupGroupId(a.Group)
+	if err == nil {
+		a.Group = g.Name
+	}
+	return a
+}
+
+// Regular - Users that can log in, have a UID in the login.defs range
+// and an existing home directory
+func Regular() ([]Account, error) {
+	entries, err := readPasswd()
+	if err != nil {
+		return nil, err
+	}
+
+	min, max := uidRange()
+
+	var accounts []Account
+	for _, e := range entries {
+		if e.UID < min || e.UID > max || !canLogIn(e.shell) || !hasHome(e.Home) {
+			continue
+		}
+		accounts = append(accounts, withGroup(e.Account))
+	}
+	return accounts, nil
+}
+
+// Homes - Home directories of the users that can log in, without "/"
+func Homes() ([]string, error) {
+	entries, err := readPasswd()
+	if err != nil {
+		return nil, err
+	}
+
+	var homes []string
+	for _, e := range entries {
+		if e.Home == "" || e.Home == "/" || !canLogIn(e.shell) {
+			continue
+		}
+		homes = append(homes, e.Home)
+	}
+	return homes, nil
+}
+
+// lookupUser - Account of a single user
+func lookupUser(name string) (Account, error) {
+	u, err := user.Lookup(name)
+	if err != nil {
+		return Account{}, fmt.Errorf("Unknown user %s", name)
+	}
+
+	uid, err := strconv.Atoi(u.Uid)
+	if err != nil {
+		return Account{}, err
+	}
+	gid, err := strconv.Atoi(u.Gid)
+	if err != nil {
+		return Account{}, err
+	}
+
+	if u.HomeDir == "" {
+		return Account{}, fmt.Errorf("User %s has no home directory", name)
+	}
+
+	return withGroup(Account{Name: u.Username, UID: uid, GID: gid, Home: u.HomeDir}), nil
+}
+
+// groupMembers - Names of the users in a group, as supplementary members
+// or by primary group
+func groupMembers(name string) ([]string, error) {
+	g, err := user.LookupGroup(name)
+	if err != nil {
+		return nil, fmt.Errorf("Unknown group %s", name)
+	}
+
+	var members []string
+
+	f, err := os.Open(groupFile)
+	if err == nil {
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Split(scanner.Text(), ":")
+			if len(fields) < 4 || fields[0] != g.Name || fields[3] == "" {
+				continue
+			}
+			members = append(members, strings.Split(fields[3], ",")...)
+		}
+	}
+
+	entries, err := readPasswd()
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		if strconv.Itoa(e.GID) == g.Gid {
+			members = append(members, e.Name)
+		}
+	}
+	return members, nil
+}
+
+// Lookup - Accounts of the given users, @name stands for the members of
+// group name that have an existing home directory. Users are returned
+// once, in the order first named.
+func Lookup(names []string) ([]Account, error) {
+	var accounts []Account
+	seen := map[string]bool{}
+
+	add := func(name string, member bool) error {
+		name = strings.TrimSpace(name)
+		if name == "" || seen[name] {
+			return nil
+		}
+		seen[name] = true
+
+		a, err := lookupUser(name)
+		if member && (err != nil || !hasHome(a.Home)) {
+			// Stale member or system account
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		accounts = append(accounts, a)
+		return nil
+	}
+
+	for _, name := range names {
+		if !strings.HasPrefix(name, "@") {
+			err := add(name, false)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		members, err := groupMembers(strings.TrimPrefix(name, "@"))
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range members {
+			err = add(member, true)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return accounts, nil
+}
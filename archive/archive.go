@@ -0,0 +1,337 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// TarGz - gzip compressed tar archive
+	TarGz = "tar.gz"
+	// Zip - zip archive
+	Zip = "zip"
+
+	// maxLinks - Symbolic links followed when resolving a link target
+	maxLinks = 40
+)
+
+// entry - A file, directory or link read from an archive
+type entry struct {
+	name     string
+	mode     os.FileMode
+	linkname string
+	hardlink bool
+	content  io.Reader
+}
+
+// Extract - Unpack the archive src of the given format into the existing
+// directory dst, dropping the first strip path components of every entry.
+// Entries that would end up outside dst are an error.
+func Extract(src string, dst string, format string, strip int) error {
+	switch format {
+	case TarGz:
+		return extractTarGz(src, dst, strip)
+	case Zip:
+		return extractZip(src, dst, strip)
+	}
+	return fmt.Errorf("Unknown archive format %s", format)
+}
+
+func extractTarGz(src string, dst string, strip int) error {
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		e := entry{name: hdr.Name, mode: hdr.FileInfo().Mode(), content: tr}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeRegA, tar.TypeDir:
+		case tar.TypeSymlink:
+			e.linkname = hdr.Linkname
+		case tar.TypeLink:
+			e.linkname = hdr.Linkname
+			e.hardlink = true
+		default:
+			// Devices, fifos and PAX records are not installed
+			continue
+		}
+
+		err = writeEntry(dst, strip, e)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func extractZip(src string, dst string, strip int) error {
+	zr, err := zip.OpenReader(src)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+
+		e := entry{name: f.Name, mode: f.Mode(), content: rc}
+		if f.Mode()&os.ModeSymlink != 0 {
+			target, err := ioutil.ReadAll(io.LimitReader(rc, 4096))
+			if err != nil {
+				rc.Close()
+				return err
+			}
+			e.linkname = string(target)
+		}
+
+		err = writeEntry(dst, strip, e)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// entryPath - Relative slash separated path of an archive entry after
+// stripping, "" if nothing is left of it
+func entryPath(name string, strip int) (string, error) {
+	name = strings.ReplaceAll(name, "\\", "/")
+	if strings.HasPrefix(name, "/") || filepath.IsAbs(name) || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("Unsafe path in archive: %s", name)
+	}
+
+	var parts []string
+	for _, part := range strings.Split(name, "/") {
+		if part == ".." {
+			return "", fmt.Errorf("Unsafe path in archive: %s", name)
+		}
+		if part != "" && part != "." {
+			parts = append(parts, part)
+		}
+	}
+
+	if len(parts) <= strip {
+		return "", nil
+	}
+	return path.Join(parts[strip:]...), nil
+}
+
+// checkParents - Refuse to write through symbolic links, so nothing is
+// created outside dst
+func checkParents(dst string, rel string) error {
+	dir := dst
+	parts := strings.Split(rel, "/")
+	for _, part := range parts[:len(parts)-1] {
+		dir = filepath.Join(dir, part)
+		fileInfo, err := os.Lstat(dir)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if fileInfo.Mode()&os.ModeSymlink != 0 {
+			return fmt.Errorf("Unsafe path in archive: %s is below a symbolic link", rel)
+		}
+	}
+	return nil
+}
+
+// checkLink - Refuse a symbolic link at rel whose target leads outside dst.
+// The target is resolved through the links extracted so far. ".." is only
+// allowed after components that exist, since a later entry could turn a
+// missing one into a link.
+func checkLink(dst string, rel string, linkname string) error {
+	unsafe := fmt.Errorf("Unsafe link in archive: %s -> %s", rel, linkname)
+
+	var parts []string
+	if dir := path.Dir(rel); dir != "." {
+		parts = strings.Split(dir, "/")
+	}
+
+	pending := strings.Split(strings.ReplaceAll(linkname, "\\", "/"), "/")
+	missing := false
+	links := 0
+
+	for len(pending) > 0 {
+		part := pending[0]
+		pending = pending[1:]
+
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if len(parts) == 0 || missing {
+				return unsafe
+			}
+			parts = parts[:len(parts)-1]
+			continue
+		}
+
+		parts = append(parts, part)
+		if missing {
+			continue
+		}
+
+		p := filepath.Join(dst, filepath.FromSlash(path.Join(parts...)))
+		fileInfo, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			missing = true
+			continue
+		} else if err != nil {
+			return err
+		}
+		if fileInfo.Mode()&os.ModeSymlink == 0 {
+			continue
+		}
+
+		links++
+		if links > maxLinks {
+			return unsafe
+		}
+
+		linkTarget, err := os.Readlink(p)
+		if err != nil {
+			return err
+		}
+		if path.IsAbs(linkTarget) || filepath.IsAbs(linkTarget) {
+			return unsafe
+		}
+		parts = parts[:len(parts)-1]
+		pending = append(strings.Split(linkTarget, "/"), pending...)
+	}
+	return nil
+}
+
+func writeEntry(dst string, strip int, e entry) error {
+	rel, err := entryPath(e.name, strip)
+	if err != nil || rel == "" {
+		return err
+	}
+
+	err = checkParents(dst, rel)
+	if err != nil {
+		return err
+	}
+
+	target := filepath.Join(dst, filepath.FromSlash(rel))
+	err = os.MkdirAll(filepath.Dir(target), 0755)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case e.mode.IsDir():
+		err = os.Mkdir(target, 0755)
+		if os.IsExist(err) {
+			fileInfo, statErr := os.Lstat(target)
+			if statErr != nil || !fileInfo.IsDir() {
+				return fmt.Errorf("Unsafe path in archive: %s is not a directory", rel)
+			}
+			err = nil
+		}
+		if err != nil {
+			return err
+		}
+		return os.Chmod(target, e.mode.Perm()|0700)
+
+	case e.hardlink:
+		linkRel, err := entryPath(e.linkname, strip)
+		if err != nil {
+			return err
+		}
+		if linkRel == "" {
+			return fmt.Errorf("Unsafe link in archive: %s -> %s", e.name, e.linkname)
+		}
+		err = checkParents(dst, linkRel)
+		if err != nil {
+			return err
+		}
+		linkTarget := filepath.Join(dst, filepath.FromSlash(linkRel))
+		fileInfo, err := os.Lstat(linkTarget)
+		if err != nil {
+			return err
+		}
+		if !fileInfo.Mode().IsRegular() {
+			return fmt.Errorf("Unsafe link in archive: %s -> %s", e.name, e.linkname)
+		}
+		return os.Link(linkTarget, target)
+
+	case e.mode&os.ModeSymlink != 0:
+		if e.linkname == "" || path.IsAbs(e.linkname) || filepath.IsAbs(e.linkname) {
+			return fmt.Errorf("Unsafe link in archive: %s -> %s", e.name, e.linkname)
+		}
+		err = checkLink(dst, rel, e.linkname)
+		if err != nil {
+			return err
+		}
+		return os.Symlink(e.linkname, target)
+
+	case e.mode.IsRegular():
+		out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, e.mode.Perm()|0600)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(out, e.content)
+		if err != nil {
+			out.Close()
+			return err
+		}
+		return out.Close()
+	}
+	return nil
+}
+
+// hashPath - File next to dst recording the hash of the installed archive
+func hashPath(dst string) string {
+	return filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".ironsync-archive")
+}
+
+// ReadHash - Hash of the archive last extracted to dst, "" if unknown
+func ReadHash(dst string) string {
+	content, err := ioutil.ReadFile(hashPath(dst))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(content))
+}
+
+// WriteHash - Record the hash of the archive extracted to dst. An empty hash
+// removes the record.
+func WriteHash(dst string, hash string) error {
+	if hash == "" {
+		err := os.Remove(hashPath(dst))
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return ioutil.WriteFile(hashPath(dst), []byte(hash+"\n"), 0644)
+}
@@ -0,0 +1,178 @@
+package archive
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// tarEntry - Entry of a test archive
+type tarEntry struct {
+	name     string
+	typeflag byte
+	linkname string
+	content  string
+}
+
+// writeTarGz - Write entries as a gzip compressed tar archive in dir
+func writeTarGz(t *testing.T, dir string, entries []tarEntry) string {
+	t.Helper()
+
+	src := filepath.Join(dir, "test.tar.gz")
+	f, err := os.Create(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typeflag, Linkname: e.linkname, Mode: 0644, Size: int64(len(e.content))}
+		if e.typeflag == tar.TypeDir {
+			hdr.Mode = 0755
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return src
+}
+
+func TestExtractTarGz(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []tarEntry
+		strip   int
+		files   []string // Paths below dst that must exist afterwards
+		wantErr bool
+	}{
+		{
+			name: "regular files",
+			entries: []tarEntry{
+				{name: "app/", typeflag: tar.TypeDir},
+				{name: "app/conf.ini", typeflag: tar.TypeReg, content: "a=1\n"},
+			},
+			files: []string{"app/conf.ini"},
+		},
+		{
+			name: "strip components",
+			entries: []tarEntry{
+				{name: "release-1.0/bin/tool", typeflag: tar.TypeReg, content: "x"},
+			},
+			strip: 1,
+			files: []string{"bin/tool"},
+		},
+		{
+			name:    "parent directory in name",
+			entries: []tarEntry{{name: "../evil", typeflag: tar.TypeReg, content: "x"}},
+			wantErr: true,
+		},
+		{
+			name:    "parent directory inside name",
+			entries: []tarEntry{{name: "a/../../evil", typeflag: tar.TypeReg, content: "x"}},
+			wantErr: true,
+		},
+		{
+			name:    "absolute name",
+			entries: []tarEntry{{name: "/etc/evil", typeflag: tar.TypeReg, content: "x"}},
+			wantErr: true,
+		},
+		{
+			name: "relative link inside the tree",
+			entries: []tarEntry{
+				{name: "lib64/libfoo.so.1", typeflag: tar.TypeReg, content: "x"},
+				{name: "lib/", typeflag: tar.TypeDir},
+				{name: "lib/libfoo.so", typeflag: tar.TypeSymlink, linkname: "../lib64/libfoo.so.1"},
+			},
+			files: []string{"lib/libfoo.so"},
+		},
+		{
+			name:    "absolute link",
+			entries: []tarEntry{{name: "passwd", typeflag: tar.TypeSymlink, linkname: "/etc/passwd"}},
+			wantErr: true,
+		},
+		{
+			name:    "link to the parent of the tree",
+			entries: []tarEntry{{name: "up", typeflag: tar.TypeSymlink, linkname: ".."}},
+			wantErr: true,
+		},
+		{
+			name: "link chain leaving the tree",
+			entries: []tarEntry{
+				{name: "a/", typeflag: tar.TypeDir},
+				{name: "a/s", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "b", typeflag: tar.TypeSymlink, linkname: "a/s/.."},
+			},
+			wantErr: true,
+		},
+		{
+			name: "link through a missing component created later",
+			entries: []tarEntry{
+				{name: "a/", typeflag: tar.TypeDir},
+				{name: "b", typeflag: tar.TypeSymlink, linkname: "a/s/.."},
+				{name: "a/s", typeflag: tar.TypeSymlink, linkname: ".."},
+			},
+			wantErr: true,
+		},
+		{
+			name: "file written through a link",
+			entries: []tarEntry{
+				{name: "a/", typeflag: tar.TypeDir},
+				{name: "a/s", typeflag: tar.TypeSymlink, linkname: ".."},
+				{name: "a/s/evil", typeflag: tar.TypeReg, content: "x"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "hard link leaving the tree",
+			entries: []tarEntry{
+				{name: "shadow", typeflag: tar.TypeLink, linkname: "../shadow"},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			src := writeTarGz(t, dir, tt.entries)
+
+			dst := filepath.Join(dir, "dst")
+			if err := os.Mkdir(dst, 0755); err != nil {
+				t.Fatal(err)
+			}
+
+			err := Extract(src, dst, TarGz, tt.strip)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Extract() succeeded, want error")
+				}
+				if _, err := os.Lstat(filepath.Join(dir, "evil")); err == nil {
+					t.Errorf("file created outside the tree")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Extract() failed: %v", err)
+			}
+
+			for _, f := range tt.files {
+				if _, err := os.Stat(filepath.Join(dst, filepath.FromSlash(f))); err != nil {
+					t.Errorf("%s: %v", f, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package backup
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"ironsync/utils"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultDir - Default backup store location
+	DefaultDir = "/var/lib/ironsync/backup"
+)
+
+// Store - Versioned copies of files replaced by ironsync
+type Store struct {
+	Dir string // Root directory of the store
+}
+
+// Entry - A single backed up version of a file
+type Entry struct {
+	Generation int         `json:"-"`          // 1 is the most recent backup
+	ID         string      `json:"-"`          // Unique name within the store
+	Path       string      `json:"path"`       // Original file path
+	Time       time.Time   `json:"time"`       // Time the backup was taken
+	Mode       os.FileMode `json:"mode"`       // Original file mode
+	UID        int         `json:"uid"`        // Original owner (-1 if unknown)
+	GID        int         `json:"gid"`        // Original group (-1 if unknown)
+	Size       int64       `json:"size"`       // Uncompressed size
+	Compressed bool        `json:"compressed"` // Data is gzip compressed
+}
+
+// CreateStore - Create a backup store rooted at dir
+func CreateStore(dir string) Store {
+	return Store{dir}
+}
+
+// fileDir - Directory holding the backups of path
+func (s *Store) fileDir(path string) string {
+	return filepath.Join(s.Dir, url.PathEscape(path))
+}
+
+func (s *Store) dataPath(e *Entry) string {
+	name := e.ID + ".data"
+	if e.Compressed {
+		name += ".gz"
+	}
+	return filepath.Join(s.fileDir(e.Path), name)
+}
+
+func (s *Store) metaPath(e *Entry) string {
+	return filepath.Join(s.fileDir(e.Path), e.ID+".json")
+}
+
+// Save - Back up the current content and attributes of path. Nothing is
+// saved if path does not exist.
+func (s *Store) Save(path string, compress bool) (err error) {
+	src, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return
+	}
+	defer src.Close()
+
+	fileInfo, err := src.Stat()
+	if err != nil {
+		return
+	}
+
+	uid, gid := utils.FileOwner(fileInfo)
+	now := time.Now()
+
+	e := Entry{
+		ID:         strconv.FormatInt(now.UnixNano(), 10),
+		Path:       path,
+		Time:       now,
+		Mode:       fileInfo.Mode(),
+		UID:        uid,
+		GID:        gid,
+		Size:       fileInfo.Size(),
+		Compressed: compress,
+	}
+
+	err = utils.MkdirAll(s.fileDir(path), 0700)
+	if err != nil {
+		return
+	}
+
+	dst, err := os.OpenFile(s.dataPath(&e), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return
+	}
+	defer dst.Close()
+
+	var w io.Writer = dst
+	var gz *gzip.Writer
+	if compress {
+		gz = gzip.NewWriter(dst)
+		w = gz
+	}
+
+	_, err = io.Copy(w, src)
+	if err == nil && gz != nil {
+		err = gz.Close()
+	}
+	if err != nil {
+		os.Remove(s.dataPath(&e))
+		return fmt.Errorf("Backing up %s failed: %v", path, err)
+	}
+
+	meta, err := json.Marshal(&e)
+	if err != nil {
+		return
+	}
+
+	err = ioutil.WriteFile(s.metaPath(&e), meta, 0600)
+	if err != nil {
+		os.Remove(s.dataPath(&e))
+	}
+	return
+}
+
+// History - List backups of path, most recent first
+func (s *Store) History(path string) (entries []*Entry, err error) {
+	files, err := ioutil.ReadDir(s.fileDir(path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return
+	}
+
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".json") {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(s.fileDir(path), f.Name()))
+		if err != nil {
+			return entries, err
+		}
+
+		e := Entry{}
+		err = json.Unmarshal(data, &e)
+		if err != nil {
+			return entries, fmt.Errorf("Invalid backup %s: %v", f.Name(), err)
+		}
+		e.ID = strings.TrimSuffix(f.Name(), ".json")
+		e.Path = path
+
+		entries = append(entries, &e)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Time.After(entries[j].Time)
+	})
+
+	for i, e := range entries {
+		e.Generation = i + 1
+	}
+	return
+}
+
+// Remove - Delete a backup
+func (s *Store) Remove(e *Entry) error {
+	err := os.Remove(s.metaPath(e))
+	if err != nil {
+		return err
+	}
+	return os.Remove(s.dataPath(e))
+}
+
+// Prune - Keep at most keep backups of path (0 for no limit) and remove
+// backups older than maxAge (0 for no limit)
+func (s *Store) Prune(path string, keep int, maxAge time.Duration) error {
+	entries, err := s.History(path)
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if (keep > 0 && e.Generation > keep) ||
+			(maxAge > 0 && time.Since(e.Time) > maxAge) {
+			err = s.Remove(e)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Open - Open the content of a backup
+func (s *Store) Open(e *Entry) (io.ReadCloser, error) {
+	f, err := os.Open(s.dataPath(e))
+	if err != nil {
+		return nil, err
+	}
+
+	if !e.Compressed {
+		return f, nil
+	}
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return &gzipFile{gz, f}, nil
+}
+
+// Restore - Atomically replace the file with a backup, including its
+// permissions and ownership. The current file is backed up first so the
+// restore can itself be rolled back.
+func (s *Store) Restore(e *Entry) (err error) {
+	r, err := s.Open(e)
+	if err != nil {
+		return
+	}
+	defer r.Close()
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(e.Path), "."+filepath.Base(e.Path)+".ironsync")
+	if err != nil {
+		return
+	}
+	defer os.Remove(tmpFile.Name())
+	defer tmpFile.Close()
+
+	_, err = io.Copy(tmpFile, r)
+	if err != nil {
+		return
+	}
+
+	if e.UID != -1 || e.GID != -1 {
+		err = tmpFile.Chown(e.UID, e.GID)
+		if err != nil {
+			return
+		}
+	}
+
+	// After chown, which clears the setuid and setgid bits
+	err = tmpFile.Chmod(e.Mode & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky))
+	if err != nil {
+		return
+	}
+
+	err = s.Save(e.Path, e.Compressed)
+	if err != nil {
+		return
+	}
+
+	return utils.ReplaceFile(tmpFile.Name(), e.Path)
+}
+
+// gzipFile - Closes both the gzip reader and the underlying file
+type gzipFile struct {
+	*gzip.Reader
+	f *os.File
+}
+
+func (g *gzipFile) Close() error {
+	g.Reader.Close()
+	return g.f.Close()
+}
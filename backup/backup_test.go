@@ -0,0 +1,133 @@
+package backup
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestSaveRestore(t *testing.T) {
+	for _, compress := range []bool{false, true} {
+		dir := t.TempDir()
+		s := CreateStore(filepath.Join(dir, "backup"))
+		path := filepath.Join(dir, "file")
+
+		if err := s.Save(path, compress); err != nil {
+			t.Fatalf("Save() of a missing file failed: %v", err)
+		}
+
+		for _, content := range []string{"first", "second"} {
+			if err := ioutil.WriteFile(path, []byte(content), 0640); err != nil {
+				t.Fatal(err)
+			}
+			if err := s.Save(path, compress); err != nil {
+				t.Fatalf("Save() failed: %v", err)
+			}
+		}
+		if err := ioutil.WriteFile(path, []byte("third"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		entries, err := s.History(path)
+		if err != nil || len(entries) != 2 {
+			t.Fatalf("History() = %d entries, %v, want 2", len(entries), err)
+		}
+		if entries[0].Generation != 1 || entries[0].Size != int64(len("second")) || entries[0].Compressed != compress {
+			t.Errorf("Most recent entry %+v", entries[0])
+		}
+
+		if err := s.Restore(entries[1]); err != nil {
+			t.Fatalf("Restore() failed: %v", err)
+		}
+		got, err := ioutil.ReadFile(path)
+		if err != nil || string(got) != "first" {
+			t.Errorf("Restored content %q, %v, want first", got, err)
+		}
+		if runtime.GOOS != "windows" {
+			if info, _ := os.Stat(path); info.Mode().Perm() != 0640 {
+				t.Errorf("Restored mode %v, want 0640", info.Mode())
+			}
+		}
+
+		// The replaced file was backed up
+		entries, err = s.History(path)
+		if err != nil || len(entries) != 3 || entries[0].Size != int64(len("third")) {
+			t.Errorf("History() after Restore() = %d entries, %v, want 3", len(entries), err)
+		}
+	}
+}
+
+func TestRestoreSpecialBits(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("No setuid on Windows")
+	}
+
+	dir := t.TempDir()
+	s := CreateStore(filepath.Join(dir, "backup"))
+	path := filepath.Join(dir, "file")
+
+	if err := ioutil.WriteFile(path, []byte("x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mode := os.FileMode(0755) | os.ModeSetuid | os.ModeSetgid
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Save(path, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := s.History(path)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("History() = %d entries, %v, want 1", len(entries), err)
+	}
+	if err := s.Restore(entries[0]); err != nil {
+		t.Fatalf("Restore() failed: %v", err)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky); got != mode {
+		t.Errorf("Restored mode %v, want %v", got, mode)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	s := CreateStore(filepath.Join(dir, "backup"))
+	path := filepath.Join(dir, "file")
+
+	if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 4; i++ {
+		if err := s.Save(path, false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.Prune(path, 2, 0); err != nil {
+		t.Fatalf("Prune() failed: %v", err)
+	}
+	entries, _ := s.History(path)
+	if len(entries) != 2 {
+		t.Errorf("%d backups after Prune(2), want 2", len(entries))
+	}
+
+	time.Sleep(10 * time.Millisecond)
+	if err := s.Prune(path, 0, time.Millisecond); err != nil {
+		t.Fatalf("Prune() failed: %v", err)
+	}
+	entries, _ = s.History(path)
+	if len(entries) != 0 {
+		t.Errorf("%d backups after Prune() by age, want 0", len(entries))
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"ironsync/backup"
+	"ironsync/config"
+	"ironsync/drift"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+)
+
+// usage - Print usage including the available commands
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s [options] [command]\n\n", progName)
+	fmt.Fprintf(out, "Without a command the sync service is started.\n\n")
+	fmt.Fprintf(out, "Commands:\n")
+	fmt.Fprintf(out, "  history <path>              List backups of a file\n")
+	fmt.Fprintf(out, "  rollback <path> [--to N]    Restore backup N of a file (Default 1)\n")
+	fmt.Fprintf(out, "  config check [-json]        Check the configuration, exits non-zero on errors\n")
+	fmt.Fprintf(out, "  config convert <file>       Convert -connfile and -resfile to a YAML, TOML or JSON file\n\n")
+	fmt.Fprintf(out, "Options:\n")
+	flag.PrintDefaults()
+}
+
+// runCommand - Run a command, returns the exit code
+func runCommand(args []string) int {
+	var err error
+
+	switch args[0] {
+	case "history":
+		err = historyCommand(args[1:])
+	case "rollback":
+		err = rollbackCommand(args[1:])
+	case "config":
+		err = configCommand(args[1:])
+	default:
+		err = fmt.Errorf("Unknown command %s", args[0])
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", progName, err)
+		return 1
+	}
+	return 0
+}
+
+// parseCommandArgs - Parse flags that may appear before or after a single
+// path argument
+func parseCommandArgs(flags *flag.FlagSet, args []string) (path string, err error) {
+	err = flags.Parse(args)
+	if err != nil {
+		return
+	}
+
+	if flags.NArg() < 1 {
+		return "", fmt.Errorf("%s: Missing path", flags.Name())
+	}
+	path = flags.Arg(0)
+
+	err = flags.Parse(flags.Args()[1:])
+	if err != nil {
+		return
+	}
+
+	if flags.NArg() > 0 {
+		return "", fmt.Errorf("%s: Unexpected argument %s", flags.Name(), flags.Arg(0))
+	}
+
+	return filepath.Abs(path)
+}
+
+func historyCommand(args []string) error {
+	flags := flag.NewFlagSet("history", flag.ContinueOnError)
+
+	path, err := parseCommandArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	store := backup.CreateStore(*backupDir)
+
+	entries, err := store.History(path)
+	if err != nil {
+		return err
+	} else if len(entries) == 0 {
+		return fmt.Errorf("No backups of %s", path)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "GEN\tDATE\tSIZE\tMODE\tUID:GID\n")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%v\t%d:%d\n", e.Generation, e.Time.Format("2006-01-02 15:04:05"), e.Size, e.Mode, e.UID, e.GID)
+	}
+	return w.Flush()
+}
+
+func rollbackCommand(args []string) error {
+	flags := flag.NewFlagSet("rollback", flag.ContinueOnError)
+	to := flags.Int("to", 1, "Backup generation to restore")
+
+	path, err := parseCommandArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	store := backup.CreateStore(*backupDir)
+
+	entries, err := store.History(path)
+	if err != nil {
+		return err
+	}
+
+	if *to < 1 || *to > len(entries) {
+		return fmt.Errorf("No backup generation %d of %s (%d available)", *to, path, len(entries))
+	}
+
+	e := entries[*to-1]
+
+	err = store.Restore(e)
+	if err != nil {
+		return fmt.Errorf("Restoring %s failed: %v", path, err)
+	}
+
+	// The service would otherwise restore the file it watches for drift
+	err = drift.Record(*cacheDir, path)
+	if err != nil {
+		return fmt.Errorf("Restored %s, updating the last known good file failed: %v", path, err)
+	}
+
+	fmt.Printf("Restored %s from %s\n", path, e.Time.Format("2006-01-02 15:04:05"))
+	return nil
+}
+
+func configCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("config: Missing command")
+	}
+
+	switch args[0] {
+	case "check":
+		return configCheckCommand(args[1:])
+	case "convert":
+		return configConvertCommand(args[1:])
+	}
+	return fmt.Errorf("config: Unknown command %s", args[0])
+}
+
+func configCheckCommand(args []string) error {
+	flags := flag.NewFlagSet("config check", flag.ContinueOnError)
+	jsonOutput := flags.Bool("json", false, "Print diagnostics as JSON")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	diags := config.Check(configFiles())
+
+	if *jsonOutput {
+		if diags == nil {
+			diags = []config.Diagnostic{}
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		err = enc.Encode(diags)
+		if err != nil {
+			return err
+		}
+	} else {
+		for _, d := range diags {
+			fmt.Println(d)
+		}
+	}
+
+	if config.HasErrors(diags) {
+		return fmt.Errorf("config check: Configuration has errors")
+	}
+
+	if !*jsonOutput {
+		fmt.Printf("Configuration OK (%d warnings)\n", len(diags))
+	}
+	return nil
+}
+
+func configConvertCommand(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("config convert: Expected output file")
+	}
+
+	outFile := args[0]
+	if !config.IsStructured(outFile) {
+		return fmt.Errorf("config convert: %s must end in .yaml, .yml, .toml or .json", outFile)
+	}
+
+	// Drop-in files are read next to the converted file, not merged into it
+	if *confDir != "" {
+		return fmt.Errorf("config convert: Drop-in directories are not converted, convert the files of -confdir separately")
+	}
+
+	err := config.Convert(*connFile, *resFile, outFile)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Converted %s and %s to %s\n", *connFile, *resFile, outFile)
+	return nil
+}
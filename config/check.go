@@ -0,0 +1,431 @@
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"ironsync/utils"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// SeverityError - Problem that stops ironsync or a resource from working
+	SeverityError = "error"
+	// SeverityWarning - Setting that has no effect
+	SeverityWarning = "warning"
+)
+
+// Diagnostic - Problem found by Check
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Section  string `json:"section,omitempty"`
+	Key      string `json:"key,omitempty"`
+	Message  string `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	where := d.File
+	if d.Line > 0 {
+		where = fmt.Sprintf("%s:%d", d.File, d.Line)
+	}
+
+	what := d.Message
+	if d.Key != "" {
+		what = fmt.Sprintf("%s: %s", d.Key, what)
+	}
+	if d.Section != "" {
+		what = fmt.Sprintf("[%s] %s", d.Section, what)
+	}
+
+	if where == "" {
+		return fmt.Sprintf("%s: %s", d.Severity, what)
+	}
+	return fmt.Sprintf("%s: %s: %s", where, d.Severity, what)
+}
+
+// connectionKeys - Settings read for each connection type
+var connectionKeys = map[string][]string{
+	"gist":    {"type", "url", "timeout"},
+	"http":    {"type", "url", "timeout"},
+	"sftp":    {"type", "hostname", "auth_username", "timeout", "auth_password", "private_key", "port", "persistent"},
+	"ftp":     {"type", "hostname", "auth_username", "auth_password", "timeout", "port", "persistent"},
+	"dropbox": {"type", "dropbox_token", "persistent"},
+}
+
+// resourceKeys - Settings read for every resource
+var resourceKeys = []string{
+	"connection", "remote_path", "interval", "retry_interval",
+	"user", "group", "perms", "dir_perms",
+	"backup", "backup_max_age", "backup_compress",
+	"pre_update_cmd", "pre_update_argv", "pre_update_cmd_timeout",
+	"post_update_cmd", "post_update_argv", "post_update_cmd_timeout",
+	"validate_cmd", "validate_argv", "validate_cmd_timeout",
+	"health_cmd", "health_argv", "health_cmd_timeout",
+	"hook_user", "hook_group", "hook_dir",
+	"template", "template_data", "mode", "merge_format", "merge_strategy", "block_marker", "sources", "dedup", "header", "drift", "acl", "xattr.*", "selinux_context", "kind", "target", "purge", "recursive", "copy_of", "users", "sync_group", "depends_on", "decompress", "extract", "strip_components",
+}
+
+// resourceTypeKeys - Resource settings that are only read for some
+// connection types
+var resourceTypeKeys = map[string][]string{
+	"gist": {"gist_id", "github_username", "github_token"},
+}
+
+// structureKeys - Keys that shape YAML, TOML and JSON files rather than set
+// anything, and INI includes (which show up in every section)
+var structureKeys = []string{"name", "path", "resources", "include", "vars"}
+
+func contains(list []string, item string) bool {
+	for _, i := range list {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
+
+// containsPrefix - Whether item matches an entry ending in .* of list
+func containsPrefix(list []string, item string) bool {
+	for _, i := range list {
+		if strings.HasSuffix(i, ".*") && strings.HasPrefix(item, strings.TrimSuffix(i, "*")) && len(item) > len(i)-1 {
+			return true
+		}
+	}
+	return false
+}
+
+// distance - Levenshtein distance between two strings
+func distance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j] + 1
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+			if prev[j-1]+cost < cur[j] {
+				cur[j] = prev[j-1] + cost
+			}
+		}
+		prev = cur
+	}
+	return prev[len(b)]
+}
+
+// suggest - Known key closest to an unknown one, "" if none is close
+func suggest(key string, known []string) string {
+	best, bestDistance := "", len(key)/4+2
+	for _, k := range known {
+		if d := distance(key, k); d < bestDistance {
+			best, bestDistance = k, d
+		}
+	}
+	return best
+}
+
+// iniKeyLine - Line of key within section name of an INI file, starting at
+// its [name] header (0 if not found)
+func iniKeyLine(file string, name string, key string) int {
+	f, err := os.Open(file)
+	if err != nil {
+		return 0
+	}
+	defer f.Close()
+
+	inSection := false
+	sectionLine := 0
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		if !inSection {
+			if text == "["+name+"]" {
+				inSection = true
+				sectionLine = line
+				if key == "" {
+					return line
+				}
+			}
+			continue
+		}
+
+		if strings.HasPrefix(text, "[") {
+			break
+		}
+
+		if strings.HasPrefix(text, key) {
+			rest := strings.TrimLeft(text[len(key):], " \t")
+			if strings.HasPrefix(rest, "=") || strings.HasPrefix(rest, ":") {
+				return line
+			}
+		}
+	}
+	return sectionLine
+}
+
+// checker - Collects diagnostics
+type checker struct {
+	diags []Diagnostic
+}
+
+func (c *checker) add(severity string, fs fileSection, key string, format string, args ...interface{}) {
+	c.diags = append(c.diags, Diagnostic{
+		Severity: severity,
+		File:     fs.file,
+		Line:     fs.line(key),
+		Section:  fs.s.Name(),
+		Key:      key,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// checkKeys - Report keys that are unknown or do not apply
+func (c *checker) checkKeys(fs fileSection, known []string, other []string, kind string) {
+	for _, key := range fs.s.Keys() {
+		if contains(known, key) || contains(structureKeys, key) || containsPrefix(known, key) {
+			continue
+		}
+
+		if contains(other, key) {
+			c.add(SeverityWarning, fs, key, "Does not apply to %s", kind)
+			continue
+		}
+
+		candidates := append(append([]string{}, known...), other...)
+		if hint := suggest(key, candidates); hint != "" {
+			c.add(SeverityError, fs, key, "Unknown setting (did you mean %s?)", hint)
+		} else {
+			c.add(SeverityError, fs, key, "Unknown setting")
+		}
+	}
+}
+
+func (c *checker) checkMode(fs fileSection, key string) {
+	if !fs.s.Has(key) {
+		return
+	}
+
+	_, err := fs.s.Mode(key)
+	if err != nil {
+		value, _ := fs.s.String(key)
+		c.add(SeverityError, fs, key, "Invalid octal permissions %s", value)
+	}
+}
+
+func (c *checker) checkUser(fs fileSection, userKey string, groupKey string, lookup func(string, string) (int, int, error)) {
+	userString, _ := fs.s.String(userKey)
+	groupString, _ := fs.s.String(groupKey)
+
+	if userString != "" {
+		_, _, err := lookup(userString, "")
+		if err != nil {
+			c.add(SeverityError, fs, userKey, "%v", err)
+		}
+	}
+
+	if groupString != "" {
+		_, _, err := lookup("", groupString)
+		if err != nil {
+			c.add(SeverityError, fs, groupKey, "%v", err)
+		}
+	}
+}
+
+// allConnectionKeys - Keys of every connection type
+func allConnectionKeys() (keys []string) {
+	for _, typeKeys := range connectionKeys {
+		for _, key := range typeKeys {
+			if !contains(keys, key) {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return
+}
+
+func (c *checker) checkConnection(fs fileSection) {
+	connType, _ := fs.s.String("type")
+
+	known, ok := connectionKeys[connType]
+	if !ok {
+		// Load reports the invalid type
+		return
+	}
+
+	c.checkKeys(fs, known, allConnectionKeys(), connType+" connections")
+
+	if fs.s.Has("private_key") {
+		privateKey, _ := fs.s.String("private_key")
+		if utils.PublicKeyFile(privateKey) == nil {
+			c.add(SeverityError, fs, "private_key", "Cannot read private key %s", privateKey)
+		}
+	}
+}
+
+// allSinkKeys - Keys of every sink type
+func allSinkKeys() (keys []string) {
+	for _, typeKeys := range sinkKeys {
+		keys = append(keys, typeKeys...)
+	}
+	sort.Strings(keys)
+	return
+}
+
+func (c *checker) checkSink(fs fileSection) {
+	sinkType, _ := fs.s.String("type")
+
+	typeKeys, ok := sinkKeys[sinkType]
+	if !ok {
+		// Load reports the invalid type
+		return
+	}
+
+	known := append(append([]string{}, sinkCommonKeys...), typeKeys...)
+	c.checkKeys(fs, known, allSinkKeys(), sinkType+" sinks")
+}
+
+func (c *checker) checkResource(fs fileSection, connTypes map[string]string) {
+	connName, _ := fs.s.String("connection")
+	connType := connTypes[connName]
+
+	var other []string
+	for otherType, keys := range resourceTypeKeys {
+		if otherType != connType {
+			other = append(other, keys...)
+		}
+	}
+	sort.Strings(other)
+
+	known := append(append([]string{}, resourceKeys...), resourceTypeKeys[connType]...)
+	c.checkKeys(fs, known, other, connType+" connections")
+
+	c.checkMode(fs, "perms")
+	c.checkMode(fs, "dir_perms")
+	c.checkUser(fs, "user", "group", utils.LookupOwner)
+	c.checkUser(fs, "hook_user", "hook_group", utils.LookupIDs)
+}
+
+// checkDuplicateSections - INI sections that appear twice in one file are
+// silently merged by the INI reader
+func (c *checker) checkDuplicateSections(file string) {
+	if IsStructured(file) {
+		return
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return
+	}
+	defer f.Close()
+
+	seen := map[string]int{}
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(text, "[") || !strings.HasSuffix(text, "]") {
+			continue
+		}
+
+		name := text[1 : len(text)-1]
+		if first, ok := seen[name]; ok {
+			c.diags = append(c.diags, Diagnostic{
+				Severity: SeverityError,
+				File:     file,
+				Line:     line,
+				Section:  name,
+				Message:  fmt.Sprintf("Section defined twice (first defined at line %d), settings are merged", first),
+			})
+			continue
+		}
+		seen[name] = line
+	}
+}
+
+// checkDuplicatePaths - Different sections that name the same local file
+func (c *checker) checkDuplicatePaths(resources []fileSection) {
+	seen := map[string]fileSection{}
+
+	for _, fs := range resources {
+		path, err := resourcePath(fs.s)
+		if err != nil {
+			// Load reports the invalid path
+			continue
+		}
+
+		if first, ok := seen[path]; ok {
+			if first.s.Name() == fs.s.Name() && first.file == fs.file {
+				continue
+			}
+			c.add(SeverityError, fs, "", "Local path %s already used at %s", path, first.origin())
+			continue
+		}
+		seen[path] = fs
+	}
+}
+
+// Check - Load the configuration with the same rules as Load and report
+// everything that is wrong with it, not just the first error
+func Check(files Files) []Diagnostic {
+	c := checker{}
+	p := createParser()
+
+	_, err := p.load(files)
+	if err != nil {
+		d := Diagnostic{Severity: SeverityError, Message: err.Error()}
+		if e, ok := err.(*loadError); ok {
+			d.File, d.Line, d.Section = e.file, e.line, e.section
+			// The message starts with where it was found
+			d.Message = strings.TrimPrefix(d.Message, fmt.Sprintf("%s:%d: ", e.file, e.line))
+			d.Message = strings.TrimPrefix(d.Message, e.file+": ")
+		}
+		c.diags = append(c.diags, d)
+	}
+
+	connTypes := map[string]string{}
+	for _, fs := range p.connSections {
+		connTypes[fs.s.Name()], _ = fs.s.String("type")
+		c.checkConnection(fs)
+	}
+
+	for _, fs := range p.resources {
+		c.checkResource(fs, connTypes)
+	}
+
+	for _, fs := range p.sinkSections {
+		c.checkSink(fs)
+	}
+
+	for _, file := range p.files {
+		c.checkDuplicateSections(file)
+	}
+
+	c.checkDuplicatePaths(p.resources)
+
+	return c.diags
+}
+
+// HasErrors - Whether any diagnostic is an error
+func HasErrors(diags []Diagnostic) bool {
+	for _, d := range diags {
+		if d.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
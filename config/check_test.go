@@ -0,0 +1,164 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name string
+		file string // Name of the file, .ini files are read as resources file
+		data string
+		want []Diagnostic // Expected diagnostics, File is filled in
+	}{
+		{
+			name: "clean",
+			file: "ironsync.yaml",
+			data: `
+connections:
+  - name: web
+    type: http
+    url: https://example.com
+    resources:
+      - path: /tmp/a
+        remote_path: /a
+`,
+		},
+		{
+			name: "yaml typo and key of another type",
+			file: "ironsync.yaml",
+			data: `
+connections:
+  - name: web
+    type: http
+    url: https://example.com
+    resources:
+      - path: /tmp/a
+        remote_path: /a
+        intervall: 30
+        gist_id: abc
+`,
+			want: []Diagnostic{
+				{Severity: SeverityWarning, Line: 10, Section: "/tmp/a", Key: "gist_id", Message: "Does not apply to http connections"},
+				{Severity: SeverityError, Line: 9, Section: "/tmp/a", Key: "intervall", Message: "Unknown setting (did you mean interval?)"},
+			},
+		},
+		{
+			name: "toml invalid perms",
+			file: "ironsync.toml",
+			data: `
+[[connections]]
+name = "web"
+type = "http"
+url = "https://example.com"
+
+[[connections.resources]]
+path = "/tmp/a"
+remote_path = "/a"
+perms = "0999"
+`,
+			want: []Diagnostic{
+				// Load stops at the section, the check points at the key
+				{Severity: SeverityError, Line: 7, Section: "/tmp/a", Message: "Section /tmp/a invalid perms 0999"},
+				{Severity: SeverityError, Line: 10, Section: "/tmp/a", Key: "perms", Message: "Invalid octal permissions 0999"},
+			},
+		},
+		{
+			name: "json unknown owner",
+			file: "ironsync.json",
+			data: `{
+  "connections": [
+    {
+      "name": "web",
+      "type": "http",
+      "url": "https://example.com",
+      "resources": [
+        {
+          "path": "/tmp/a",
+          "remote_path": "/a",
+          "user": "ironsync-no-such-user"
+        }
+      ]
+    }
+  ]
+}
+`,
+			want: []Diagnostic{
+				{Severity: SeverityError, Line: 11, Section: "/tmp/a", Key: "user", Message: "Unknown user ironsync-no-such-user"},
+			},
+		},
+		{
+			name: "ini unknown key",
+			file: "res.ini",
+			data: `[/tmp/a]
+connection = web
+remote_path = /a
+
+[/tmp/b]
+connection = web
+remote_path = /b
+hook_dirr = /tmp
+`,
+			want: []Diagnostic{
+				{Severity: SeverityError, Line: 8, Section: "/tmp/b", Key: "hook_dirr", Message: "Unknown setting (did you mean hook_dir?)"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			file := filepath.Join(dir, tt.file)
+			if err := ioutil.WriteFile(file, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			files := Files{File: file}
+			if filepath.Ext(file) == ".ini" {
+				conn := filepath.Join(dir, "conn.ini")
+				if err := ioutil.WriteFile(conn, []byte("[web]\ntype = http\nurl = https://example.com\n"), 0644); err != nil {
+					t.Fatal(err)
+				}
+				files = Files{ConnFile: conn, ResFile: file}
+			}
+
+			diags := Check(files)
+			if len(diags) != len(tt.want) {
+				t.Fatalf("Check() = %v, want %d diagnostics", diags, len(tt.want))
+			}
+			for i, want := range tt.want {
+				want.File = file
+				if diags[i] != want {
+					t.Errorf("Check() diagnostic %d = %+v, want %+v", i, diags[i], want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckLoadError(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "ironsync.yaml")
+	data := `
+connections:
+  - name: web
+    type: http
+    url: https://example.com
+resources:
+  - path: /tmp/a
+    remote_path: /a
+    connection: other
+`
+	if err := ioutil.WriteFile(file, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	diags := Check(Files{File: file})
+	if !HasErrors(diags) {
+		t.Fatalf("Check() = %v, want an error", diags)
+	}
+	if d := diags[0]; d.File != file || d.Line != 7 || d.Section != "/tmp/a" {
+		t.Errorf("Load error %+v, want %s:7 in section /tmp/a", d, file)
+	}
+}
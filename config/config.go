@@ -2,17 +2,40 @@ package config
 
 import (
 	"fmt"
+	"ironsync/accounts"
+	"ironsync/archive"
 	"ironsync/connection"
+	"ironsync/decompress"
+	"ironsync/drift"
+	"ironsync/merge"
+	"ironsync/permissions"
+	"ironsync/render"
 	"ironsync/resource"
+	"ironsync/secret"
 	"os"
 	"os/user"
-	"strconv"
 	"path"
 	"strings"
-
-	"github.com/robfig/config"
+	"time"
 )
 
+// secretValue - Resolve a secret reference (e.g. env:FTP_PASS) and remember
+// it in ref so it can be resolved again on reload. Plain values are returned
+// unchanged. Errors never include the secret.
+func secretValue(file string, section string, key string, value string, ref *string) (string, error) {
+	if !secret.IsReference(value) {
+		return value, nil
+	}
+
+	*ref = value
+
+	resolved, err := secret.Resolve(value)
+	if err != nil {
+		return "", fmt.Errorf("%s: Section %s invalid %s: %v", file, section, key, err)
+	}
+	return resolved, nil
+}
+
 func findConnection(name string, connections []*connection.Connection) (c *connection.Connection) {
 	for _, c := range connections {
 		if c.Name == name {
@@ -22,295 +45,811 @@ func findConnection(name string, connections []*connection.Connection) (c *conne
 	return nil
 }
 
-func parseConnectionConfig(connFile string) (connections []*connection.Connection, err error) {
-	c, err := config.ReadDefault(connFile)
+// parseSource - Parse a source as connection:remote_path, for Gist
+// connections connection:username/gist_id[/file]
+func parseSource(value string, connections []*connection.Connection) (resource.Source, error) {
+	source := resource.Source{}
+
+	sep := strings.Index(value, ":")
+	if sep <= 0 {
+		return source, fmt.Errorf("expected connection:remote_path")
+	}
+	source.ConnectionName = value[:sep]
+	source.RemotePath = value[sep+1:]
+
+	conn := findConnection(source.ConnectionName, connections)
+	if conn == nil {
+		return source, fmt.Errorf("unknown connection %s", source.ConnectionName)
+	}
+	source.Connection = conn
+
+	if conn.Type == connection.ConnectionTypeGitHubGist {
+		parts := strings.SplitN(source.RemotePath, "/", 3)
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return source, fmt.Errorf("expected %s:username/gist_id[/file]", source.ConnectionName)
+		}
+		source.GitHubUsername = parts[0]
+		source.GistID = parts[1]
+		source.RemotePath = ""
+		if len(parts) == 3 {
+			source.RemotePath = parts[2]
+		}
+	} else if source.RemotePath == "" && conn.Type != connection.ConnectionTypeHTTP {
+		return source, fmt.Errorf("missing remote path")
+	}
+	return source, nil
+}
+
+// findResource - Find a resource (not a copy) and its connection by path
+func findResource(path string, connections []*connection.Connection) (*connection.Connection, *resource.Resource) {
+	for _, c := range connections {
+		for _, r := range c.Resources {
+			if r.Path == path {
+				return c, r
+			}
+		}
+	}
+	return nil, nil
+}
+
+// parseConnection - Parse the settings of a single connection
+func parseConnection(connFile string, s section) (*connection.Connection, error) {
+	section := s.Name()
+
+	connType, err := s.String("type")
 	if err != nil {
-		return
+		return nil, fmt.Errorf("%s: Section %s missing type", connFile, section)
 	}
 
-	for _, section := range c.Sections() {
-		// Skip default section, unused
-		if section == "DEFAULT" {
-			continue
+	if connType == "gist" {
+		conn := connection.CreateGitHubGistConnection(section)
+
+		// Optional
+		connURL, err := s.String("url")
+		if err == nil {
+			conn.URL = connURL
 		}
 
-		if findConnection(section, connections) != nil {
-			return connections, fmt.Errorf("%s: Connection defined twice %s", connFile, section)
+		connTimeout, err := s.Int("timeout")
+		if err == nil {
+			conn.Timeout = connTimeout
 		}
 
-		connType, err := c.String(section, "type")
+		return &conn, nil
+	} else if connType == "http" {
+		// Required
+		connURL, err := s.String("url")
 		if err != nil {
-			return connections, fmt.Errorf("%s: Section %s missing type", connFile, section)
+			return nil, fmt.Errorf("%s: Section %s missing url", connFile, section)
 		}
 
-		if connType == "gist" {
-			conn := connection.CreateGitHubGistConnection(section)
+		conn := connection.CreateHTTPConnection(section, connURL)
 
-			// Optional
-			connURL, err := c.String(section, "url")
-			if err == nil {
-				conn.URL = connURL
-			}
+		// Optional
+		connTimeout, err := s.Int("timeout")
+		if err == nil {
+			conn.Timeout = connTimeout
+		}
 
-			connTimeout, err := c.Int(section, "timeout")
-			if err == nil {
-				conn.Timeout = connTimeout
-			}
+		return &conn, nil
+	} else if connType == "sftp" {
+		// Required
+		connHostname, err := s.String("hostname")
+		if err != nil {
+			return nil, fmt.Errorf("%s: Section %s missing hostname", connFile, section)
 
-			connections = append(connections, &conn)
-		} else if connType == "http" {
-			// Required
-			connURL, err := c.String(section, "url")
-			if err != nil {
-				return connections, fmt.Errorf("%s: Section %s missing url", connFile, section)
-			}
+		}
 
-			conn := connection.CreateHTTPConnection(section, connURL)
+		connAuthUsername, err := s.String("auth_username")
+		if err != nil {
+			return nil, fmt.Errorf("%s: Section %s missing auth_username", connFile, section)
 
-			// Optional
-			connTimeout, err := c.Int(section, "timeout")
-			if err == nil {
-				conn.Timeout = connTimeout
-			}
+		}
 
-			connections = append(connections, &conn)
-		} else if connType == "sftp" {
-			// Required
-			connHostname, err := c.String(section, "hostname")
-			if err != nil {
-				return connections, fmt.Errorf("%s: Section %s missing hostname", connFile, section)
+		conn := connection.CreateSFTPConnection(section, connHostname, connAuthUsername)
 
-			}
+		// Optional
+		connTimeout, err := s.Int("timeout")
+		if err == nil {
+			conn.Timeout = connTimeout
+		}
 
-			connAuthUsername, err := c.String(section, "auth_username")
+		connAuthPassword, err := s.String("auth_password")
+		if err == nil {
+			conn.AuthPassword, err = secretValue(connFile, section, "auth_password", connAuthPassword, &conn.AuthPasswordRef)
 			if err != nil {
-				return connections, fmt.Errorf("%s: Section %s missing auth_username", connFile, section)
-
+				return nil, err
 			}
+		}
 
-			conn := connection.CreateSFTPConnection(section, connHostname, connAuthUsername)
+		connPrivateKey, err := s.String("private_key")
+		if err == nil {
+			conn.PrivateKey = connPrivateKey
+		}
 
-			// Optional
-			connTimeout, err := c.Int(section, "timeout")
-			if err == nil {
-				conn.Timeout = connTimeout
-			}
+		connPort, err := s.Int("port")
+		if err == nil {
+			conn.Port = connPort
+		}
 
-			connAuthPassword, err := c.String(section, "auth_password")
-			if err == nil {
-				conn.AuthPassword = connAuthPassword
-			}
+		connPersistent, err := s.Bool("persistent")
+		if err == nil {
+			conn.Persistent = connPersistent
+		}
 
-			connPrivateKey, err := c.String(section, "private_key")
-			if err == nil {
-				conn.PrivateKey = connPrivateKey
-			}
+		return &conn, nil
+	} else if connType == "ftp" {
+		// Required
+		connHostname, err := s.String("hostname")
+		if err != nil {
+			return nil, fmt.Errorf("%s: Section %s missing hostname", connFile, section)
 
-			connPort, err := c.Int(section, "port")
-			if err == nil {
-				conn.Port = connPort
-			}
+		}
 
-			connPersistent, err := c.Bool(section, "persistent")
-			if err == nil {
-				conn.Persistent = connPersistent
-			}
+		connAuthUsername, err := s.String("auth_username")
+		if err != nil {
+			return nil, fmt.Errorf("%s: Section %s missing auth_username", connFile, section)
+		}
 
-			connections = append(connections, &conn)
-		} else if connType == "ftp" {
-			// Required
-			connHostname, err := c.String(section, "hostname")
-			if err != nil {
-				return connections, fmt.Errorf("%s: Section %s missing hostname", connFile, section)
+		connAuthPassword, err := s.String("auth_password")
+		if err != nil {
+			return nil, fmt.Errorf("%s: Section %s missing auth_password", connFile, section)
+		}
 
-			}
+		var connAuthPasswordRef string
+		connAuthPassword, err = secretValue(connFile, section, "auth_password", connAuthPassword, &connAuthPasswordRef)
+		if err != nil {
+			return nil, err
+		}
 
-			connAuthUsername, err := c.String(section, "auth_username")
-			if err != nil {
-				return connections, fmt.Errorf("%s: Section %s missing auth_username", connFile, section)
-			}
+		conn := connection.CreateFTPConnection(section, connHostname, connAuthUsername, connAuthPassword)
+		conn.AuthPasswordRef = connAuthPasswordRef
 
-			connAuthPassword, err := c.String(section, "auth_password")
-			if err != nil {
-				return connections, fmt.Errorf("%s: Section %s missing auth_password", connFile, section)
-			}
+		// Optional
+		connTimeout, err := s.Int("timeout")
+		if err == nil {
+			conn.Timeout = connTimeout
+		}
 
-			conn := connection.CreateFTPConnection(section, connHostname, connAuthUsername, connAuthPassword)
+		connPort, err := s.Int("port")
+		if err == nil {
+			conn.Port = connPort
+		}
 
-			// Optional
-			connTimeout, err := c.Int(section, "timeout")
-			if err == nil {
-				conn.Timeout = connTimeout
-			}
+		connPersistent, err := s.Bool("persistent")
+		if err == nil {
+			conn.Persistent = connPersistent
+		}
 
-			connPort, err := c.Int(section, "port")
-			if err == nil {
-				conn.Port = connPort
-			}
+		return &conn, nil
+	} else if connType == "dropbox" {
+		// Required
+		connDropboxToken, err := s.String("dropbox_token")
+		if err != nil {
+			return nil, fmt.Errorf("%s: Section %s missing dropbox_token", connFile, section)
+		}
 
-			connPersistent, err := c.Bool(section, "persistent")
-			if err == nil {
-				conn.Persistent = connPersistent
-			}
+		var connDropboxTokenRef string
+		connDropboxToken, err = secretValue(connFile, section, "dropbox_token", connDropboxToken, &connDropboxTokenRef)
+		if err != nil {
+			return nil, err
+		}
 
-			connections = append(connections, &conn)
-		} else if connType == "dropbox" {
-			// Required
-			connDropboxToken, err := c.String(section, "dropbox_token")
-			if err != nil {
-				return connections, fmt.Errorf("%s: Section %s missing dropbox_token", connFile, section)
-			}
+		conn := connection.CreateDropboxConnection(section, connDropboxToken)
+		conn.DropboxTokenRef = connDropboxTokenRef
 
-			conn := connection.CreateDropboxConnection(section, connDropboxToken)
+		connPersistent, err := s.Bool("persistent")
+		if err == nil {
+			conn.Persistent = connPersistent
+		}
 
-			connPersistent, err := c.Bool(section, "persistent")
-			if err == nil {
-				conn.Persistent = connPersistent
-			}
+		return &conn, nil
+	} else {
+		return nil, fmt.Errorf("%s: Section %s invalid type %s", connFile, section, connType)
+	}
+}
 
-			connections = append(connections, &conn)
-		} else {
-			return connections, fmt.Errorf("%s: Section %s invalid type %s", connFile, section, connType)
+// resourcePath - Resource section name with variables expanded and path
+// cleaned up, ~ is the home of the current user
+func resourcePath(s section) (string, error) {
+	local_path, err := s.Expand(s.Name())
+	if err != nil {
+		return "", err
+	}
+
+	return path.Clean(expandHome(local_path)), nil
+}
+
+// expandHome - Replace a leading ~ with the home directory of the current
+// user and ~name with the home directory of user name. ~* (every user) is
+// left alone.
+func expandHome(local_path string) string {
+	if !strings.HasPrefix(local_path, "~") || strings.HasPrefix(local_path, "~*") {
+		return local_path
+	}
+
+	name := strings.SplitN(local_path[1:], "/", 2)[0]
+	if name == "" {
+		user, err := user.Current()
+		if err != nil {
+			panic(err)
 		}
+		return user.HomeDir + local_path[1:]
 	}
 
-	return
+	u, err := user.Lookup(name)
+	if err != nil {
+		return local_path
+	}
+	return u.HomeDir + local_path[1+len(name):]
 }
 
-func parseResourceConfig(resConfig string, connections []*connection.Connection) (err error) {
-	c, err := config.ReadDefault(resConfig)
+// protectedPath - Whether removing local_path would remove / or a home
+// directory
+func protectedPath(local_path string) bool {
+	if local_path == "/" {
+		return true
+	}
+
+	homes, _ := accounts.Homes()
+	if u, err := user.Current(); err == nil && u.HomeDir != "" {
+		homes = append(homes, u.HomeDir)
+	}
+
+	for _, home := range homes {
+		home = path.Clean(home)
+		if home == local_path || strings.HasPrefix(home, local_path+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// homePath - Path of a per-user resource relative to each home directory:
+// sections named ~*/path, or ~/path with users set
+func homePath(s section) (string, bool) {
+	local_path, err := s.Expand(s.Name())
 	if err != nil {
-		return
+		return "", false
 	}
 
-	for _, section := range c.Sections() {
-		// Skip default section, unused
-		if section == "DEFAULT" {
+	if strings.HasPrefix(local_path, "~*/") || (s.Has("users") && strings.HasPrefix(local_path, "~/")) {
+		rel := path.Clean(local_path[strings.Index(local_path, "/")+1:])
+		return rel, rel != "." && !strings.HasPrefix(rel, "../")
+	}
+	return "", false
+}
+
+// addPerUser - Install res below the home directory of each account, owned
+// by that account. Files are downloaded once and installed as copies, other
+// kinds and archives are separate resources. The first resource is
+// returned, res itself if there are no accounts.
+func addPerUser(res *resource.Resource, rel string, targets []accounts.Account, conn *connection.Connection) *resource.Resource {
+	var first *resource.Resource
+
+	for _, a := range targets {
+		r := *res
+		r.Path = path.Join(a.Home, rel)
+		r.Home = a.Home
+		r.User = a.Name
+		if r.Group == "" {
+			r.Group = a.Group
+		}
+		r.Copies = nil
+		// The files of different users are compared, not skipped by time
+		r.LastModifiedTime = time.Time{}
+
+		if first != nil && r.Kind == resource.KindFile && r.Extract == "" {
+			first.Copies = append(first.Copies, &r)
 			continue
 		}
 
-		local_path := section
+		conn.Resources = append(conn.Resources, &r)
+		if first == nil {
+			first = &r
+		}
+	}
 
-		connName, err := c.String(section, "connection")
-		if err != nil {
-			return fmt.Errorf("%s: Section %s missing connection", resConfig, section)
+	if first == nil {
+		return res
+	}
+	return first
+}
+
+// parseResource - Parse the settings of a single resource and add it to its
+// connection
+func parseResource(resConfig string, s section, connections []*connection.Connection) (res *resource.Resource, err error) {
+	section := s.Name()
+
+	var local_path string
+
+	// A copy is installed from its primary resource's download
+	var conn *connection.Connection
+	var primary *resource.Resource
+
+	copyOf, err := s.String("copy_of")
+	if err == nil {
+		conn, primary = findResource(path.Clean(expandHome(copyOf)), connections)
+		if primary == nil {
+			return nil, fmt.Errorf("%s: Section %s copy_of unknown resource %s", resConfig, section, copyOf)
 		}
+	}
 
-		conn := findConnection(connName, connections)
+	connName, err := s.String("connection")
+	if err == nil {
+		if primary != nil && connName != conn.Name {
+			return nil, fmt.Errorf("%s: Section %s connection %s differs from the connection of %s", resConfig, section, connName, copyOf)
+		}
+
+		conn = findConnection(connName, connections)
 		if conn == nil {
-			return fmt.Errorf("%s: Section %s invalid connection %s", resConfig, section, connName)
+			return nil, fmt.Errorf("%s: Section %s invalid connection %s", resConfig, section, connName)
 		}
+	} else if primary == nil {
+		return nil, fmt.Errorf("%s: Section %s missing connection", resConfig, section)
+	}
 
-		// Cleanup path
-		if strings.HasPrefix(section, "~") {
-			user, err := user.Current()
-			if err != nil {
-				panic(err)
-			}
-			local_path = user.HomeDir + section[1:]
+	local_path, err = resourcePath(s)
+	if err != nil {
+		return nil, fmt.Errorf("%s: Section %s invalid path: %v", resConfig, section, err)
+	}
+
+	r := resource.CreateResource(local_path)
+	res = &r
+
+	resStat, err := os.Stat(local_path)
+	if err == nil {
+		res.LastModifiedTime = resStat.ModTime()
+	}
+
+	resKind, err := s.String("kind")
+	if err == nil {
+		switch resKind {
+		case resource.KindFile, resource.KindSymlink, resource.KindDirectory, resource.KindAbsent:
+		default:
+			return nil, fmt.Errorf("%s: Section %s invalid kind %s", resConfig, section, resKind)
 		}
+		res.Kind = resKind
+	}
 
-		local_path = path.Clean(local_path)
+	resTarget, err := s.String("target")
+	if err == nil {
+		if res.Kind != resource.KindSymlink {
+			return nil, fmt.Errorf("%s: Section %s target requires kind symlink", resConfig, section)
+		}
+		res.Target = expandHome(resTarget)
+	} else if res.Kind == resource.KindSymlink {
+		return nil, fmt.Errorf("%s: Section %s missing target", resConfig, section)
+	}
 
-		res := resource.CreateResource(local_path)
+	resPurge, err := s.Bool("purge")
+	if err == nil {
+		if res.Kind != resource.KindDirectory {
+			return nil, fmt.Errorf("%s: Section %s purge requires kind directory", resConfig, section)
+		}
+		res.Purge = resPurge
+	}
 
-		resStat, err := os.Stat(local_path)
-		if err == nil {
-			res.LastModifiedTime = resStat.ModTime()
+	resRecursive, err := s.Bool("recursive")
+	if err == nil {
+		if res.Kind != resource.KindAbsent {
+			return nil, fmt.Errorf("%s: Section %s recursive requires kind absent", resConfig, section)
 		}
+		res.Recursive = resRecursive
+	}
 
-		// Optional
-		resInterval, err := c.Int(section, "interval")
-		if err == nil {
-			res.Interval = resInterval
+	if res.Kind == resource.KindAbsent && protectedPath(local_path) {
+		return nil, fmt.Errorf("%s: Section %s refusing to remove / or a home directory", resConfig, section)
+	}
+
+	// Optional
+	resInterval, err := s.Int("interval")
+	if err == nil {
+		res.Interval = resInterval
+	}
+
+	resRetryInterval, err := s.Int("retry_interval")
+	if err == nil {
+		res.RetryInterval = resRetryInterval
+	}
+
+	resUser, err := s.String("user")
+	if err == nil {
+		res.User = resUser
+	}
+
+	resGroup, err := s.String("group")
+	if err == nil {
+		res.Group = resGroup
+	}
+
+	resPerms, err := s.String("perms")
+	if err == nil {
+		// String to octal
+		res.Perms, err = s.Mode("perms")
+		if err != nil {
+			return nil, fmt.Errorf("%s: Section %s invalid perms %s", resConfig, section, resPerms)
 		}
+	}
 
-		resRetryInterval, err := c.Int(section, "retry_interval")
-		if err == nil {
-			res.RetryInterval = resRetryInterval
+	resDirPerms, err := s.String("dir_perms")
+	if err == nil {
+		// String to octal
+		res.DirPerms, err = s.Mode("dir_perms")
+		if err != nil {
+			return nil, fmt.Errorf("%s: Section %s invalid dir_perms %s", resConfig, section, resDirPerms)
 		}
+	}
 
-		resUser, err := c.String(section, "user")
-		if err == nil {
-			res.User = resUser
+	resBackupCount, err := s.Int("backup")
+	if err == nil {
+		res.BackupCount = resBackupCount
+	}
+
+	resBackupMaxAge, err := s.Int("backup_max_age")
+	if err == nil {
+		res.BackupMaxAge = resBackupMaxAge
+	}
+
+	resBackupCompress, err := s.Bool("backup_compress")
+	if err == nil {
+		res.BackupCompress = resBackupCompress
+	}
+
+	resPreUpdateCommand, err := s.String("pre_update_cmd")
+	if err == nil {
+		res.PreUpdateCommand = resPreUpdateCommand
+	}
+
+	resPreUpdateCommandTimeout, err := s.Int("pre_update_cmd_timeout")
+	if err == nil {
+		res.PreUpdateCommandTimeout = resPreUpdateCommandTimeout
+	}
+
+	resPostUpdateCommand, err := s.String("post_update_cmd")
+	if err == nil {
+		res.PostUpdateCommand = resPostUpdateCommand
+	}
+
+	resPostUpdateCommandTimeout, err := s.Int("post_update_cmd_timeout")
+	if err == nil {
+		res.PostUpdateCommandTimeout = resPostUpdateCommandTimeout
+	}
+
+	resValidateCommand, err := s.String("validate_cmd")
+	if err == nil {
+		res.ValidateCommand = resValidateCommand
+	}
+
+	resValidateCommandTimeout, err := s.Int("validate_cmd_timeout")
+	if err == nil {
+		res.ValidateCommandTimeout = resValidateCommandTimeout
+	}
+
+	resHealthCommand, err := s.String("health_cmd")
+	if err == nil {
+		res.HealthCommand = resHealthCommand
+	}
+
+	resHealthCommandTimeout, err := s.Int("health_cmd_timeout")
+	if err == nil {
+		res.HealthCommandTimeout = resHealthCommandTimeout
+	}
+
+	// Argument list forms, run without a shell
+	hookArgvs := []struct {
+		key     string
+		command string
+		argv    *[]string
+	}{
+		{"pre_update_argv", res.PreUpdateCommand, &res.PreUpdateArgv},
+		{"post_update_argv", res.PostUpdateCommand, &res.PostUpdateArgv},
+		{"validate_argv", res.ValidateCommand, &res.ValidateArgv},
+		{"health_argv", res.HealthCommand, &res.HealthArgv},
+	}
+
+	for _, hook := range hookArgvs {
+		if !s.Has(hook.key) {
+			continue
 		}
 
-		resGroup, err := c.String(section, "group")
-		if err == nil {
-			res.Group = resGroup
+		if hook.command != "" {
+			return nil, fmt.Errorf("%s: Section %s %s conflicts with %s", resConfig, section, hook.key, strings.Replace(hook.key, "_argv", "_cmd", 1))
 		}
 
-		resPerms, err := c.String(section, "perms")
-		if err == nil {
-			// String to octal
-			resPermsInt, err := strconv.ParseInt(resPerms, 8, 64)
-			if err != nil {
-				return fmt.Errorf("%s: Section %s invalid perms %s", resConfig, section, resPerms)
-			}
-			res.Perms = os.FileMode(resPermsInt)
+		*hook.argv, err = s.Args(hook.key)
+		if err != nil || len(*hook.argv) == 0 {
+			return nil, fmt.Errorf("%s: Section %s invalid %s", resConfig, section, hook.key)
 		}
+	}
 
-		resPreUpdateCommand, err := c.String(section, "pre_update_cmd")
-		if err == nil {
-			res.PreUpdateCommand = resPreUpdateCommand
+	resHookUser, err := s.String("hook_user")
+	if err == nil {
+		res.HookUser = resHookUser
+	}
+
+	resHookGroup, err := s.String("hook_group")
+	if err == nil {
+		res.HookGroup = resHookGroup
+	}
+
+	resHookDir, err := s.String("hook_dir")
+	if err == nil {
+		res.HookDir = resHookDir
+	}
+
+	resTemplate, err := s.String("template")
+	if err == nil {
+		if resTemplate != render.TemplateGo {
+			return nil, fmt.Errorf("%s: Section %s invalid template %s", resConfig, section, resTemplate)
 		}
+		res.Template = resTemplate
+		// The local file is rendered, its time says nothing about the template
+		res.LastModifiedTime = time.Time{}
+	}
 
-		resPreUpdateCommandTimeout, err := c.Int(section, "pre_update_cmd_timeout")
-		if err == nil {
-			res.PreUpdateCommandTimeout = resPreUpdateCommandTimeout
+	resTemplateData, err := s.String("template_data")
+	if err == nil {
+		if res.Template == "" {
+			return nil, fmt.Errorf("%s: Section %s template_data requires template", resConfig, section)
 		}
+		res.TemplateData = resTemplateData
+	}
 
-		resPostUpdateCommand, err := c.String(section, "post_update_cmd")
-		if err == nil {
-			res.PostUpdateCommand = resPostUpdateCommand
+	resMode, err := s.String("mode")
+	if err == nil {
+		if resMode != resource.ModeReplace && resMode != resource.ModeMerge && resMode != resource.ModeBlock {
+			return nil, fmt.Errorf("%s: Section %s invalid mode %s", resConfig, section, resMode)
 		}
+		res.Mode = resMode
+	}
 
-		resPostUpdateCommandTimeout, err := c.Int(section, "post_update_cmd_timeout")
-		if err == nil {
-			res.PostUpdateCommandTimeout = resPostUpdateCommandTimeout
+	if res.Mode == resource.ModeMerge {
+		res.MergeFormat = merge.FormatFor(res.Path)
+		res.MergeStrategy = merge.StrategyDeep
+	}
+
+	resMergeFormat, err := s.String("merge_format")
+	if err == nil {
+		res.MergeFormat = resMergeFormat
+	}
+
+	resMergeStrategy, err := s.String("merge_strategy")
+	if err == nil {
+		res.MergeStrategy = resMergeStrategy
+	}
+
+	if res.Mode == resource.ModeMerge {
+		if res.MergeFormat == "" {
+			return nil, fmt.Errorf("%s: Section %s missing merge_format", resConfig, section)
 		}
+		err = merge.Check(res.MergeFormat, res.MergeStrategy)
+		if err != nil {
+			return nil, fmt.Errorf("%s: Section %s %v", resConfig, section, err)
+		}
+	} else if res.MergeFormat != "" || res.MergeStrategy != "" {
+		return nil, fmt.Errorf("%s: Section %s merge_format and merge_strategy require mode merge", resConfig, section)
+	}
 
-		// Required (based on connection type)
-		resRemotePath, err := c.String(section, "remote_path")
-		if err == nil {
-			res.RemotePath = resRemotePath
-		} else if conn.Type == connection.ConnectionTypeFTP ||
-			conn.Type == connection.ConnectionTypeSFTP ||
-			conn.Type == connection.ConnectionTypeDropbox {
-			return fmt.Errorf("%s: Section %s missing remote_path", resConfig, section)
+	if res.Mode == resource.ModeBlock {
+		res.BlockMarker = merge.DefaultMarker
+	}
+
+	resBlockMarker, err := s.String("block_marker")
+	if err == nil {
+		if res.Mode != resource.ModeBlock {
+			return nil, fmt.Errorf("%s: Section %s block_marker requires mode block", resConfig, section)
+		}
+		if !strings.Contains(resBlockMarker, "{mark}") {
+			return nil, fmt.Errorf("%s: Section %s block_marker must contain {mark}", resConfig, section)
+		}
+		res.BlockMarker = resBlockMarker
+	}
+
+	resDecompress, err := s.String("decompress")
+	if err == nil {
+		if !decompress.IsFormat(resDecompress) {
+			return nil, fmt.Errorf("%s: Section %s invalid decompress %s", resConfig, section, resDecompress)
+		}
+		res.Decompress = resDecompress
+	}
+
+	resExtract, err := s.String("extract")
+	if err == nil {
+		if resExtract != archive.TarGz && resExtract != archive.Zip {
+			return nil, fmt.Errorf("%s: Section %s invalid extract %s", resConfig, section, resExtract)
+		}
+		if res.Template != "" || res.BackupEnabled() || res.Mode != resource.ModeReplace {
+			return nil, fmt.Errorf("%s: Section %s extract cannot be combined with template, backups or mode", resConfig, section)
+		}
+		res.Extract = resExtract
+		// The local path is a directory, its time says nothing about the archive
+		res.LastModifiedTime = time.Time{}
+	}
+
+	resStripComponents, err := s.Int("strip_components")
+	if err == nil {
+		if res.Extract == "" {
+			return nil, fmt.Errorf("%s: Section %s strip_components requires extract", resConfig, section)
+		}
+		if resStripComponents < 0 {
+			return nil, fmt.Errorf("%s: Section %s invalid strip_components %d", resConfig, section, resStripComponents)
+		}
+		res.StripComponents = resStripComponents
+	}
+
+	// Required (based on connection type)
+	resRemotePath, err := s.String("remote_path")
+	if err == nil {
+		res.RemotePath = resRemotePath
+	} else if res.Kind == resource.KindFile && primary == nil && (conn.Type == connection.ConnectionTypeFTP ||
+		conn.Type == connection.ConnectionTypeSFTP ||
+		conn.Type == connection.ConnectionTypeDropbox) {
+		return nil, fmt.Errorf("%s: Section %s missing remote_path", resConfig, section)
+	}
+
+	if conn.Type == connection.ConnectionTypeGitHubGist && res.Kind == resource.KindFile && primary == nil {
+		resGistID, err := s.String("gist_id")
+		if err != nil {
+			return nil, fmt.Errorf("%s: Section %s missing gist_id", resConfig, section)
+		}
+		res.GistID = resGistID
+
+		resGitHubUsername, err := s.String("github_username")
+		if err != nil {
+			return nil, fmt.Errorf("%s: Section %s missing github_username", resConfig, section)
 		}
+		res.GitHubUsername = resGitHubUsername
 
-		if conn.Type == connection.ConnectionTypeGitHubGist {
-			resGistID, err := c.String(section, "gist_id")
+		resGitHubToken, err := s.String("github_token")
+		if err == nil {
+			res.GitHubToken, err = secretValue(resConfig, section, "github_token", resGitHubToken, &res.GitHubTokenRef)
 			if err != nil {
-				return fmt.Errorf("%s: Section %s missing gist_id", resConfig, section)
+				return nil, err
 			}
-			res.GistID = resGistID
+		}
+	}
 
-			resGitHubUsername, err := c.String(section, "github_username")
+	resSources, err := s.List("sources")
+	if err == nil {
+		for _, resSource := range resSources {
+			source, err := parseSource(resSource, connections)
 			if err != nil {
-				return fmt.Errorf("%s: Section %s missing github_username", resConfig, section)
+				return nil, fmt.Errorf("%s: Section %s invalid source %s: %v", resConfig, section, resSource, err)
 			}
-			res.GitHubUsername = resGitHubUsername
+			res.Sources = append(res.Sources, source)
+		}
+		if res.Extract != "" {
+			return nil, fmt.Errorf("%s: Section %s sources cannot be combined with extract", resConfig, section)
+		}
+		// The assembled file says nothing about the time of each source
+		res.LastModifiedTime = time.Time{}
+	}
+
+	resDedup, err := s.Bool("dedup")
+	if err == nil {
+		res.Dedup = resDedup
+	}
+
+	resHeader, err := s.String("header")
+	if err == nil {
+		res.Header = resHeader
+	}
+
+	resACL, err := s.String("acl")
+	if err == nil {
+		res.ACL, err = permissions.ParseACL(resACL)
+		if err != nil {
+			return nil, fmt.Errorf("%s: Section %s %v", resConfig, section, err)
+		}
+		// The ACL owner, group and other entries come from perms
+		if len(res.ACL) > 0 && res.Perms == 0 {
+			return nil, fmt.Errorf("%s: Section %s acl requires perms", resConfig, section)
+		}
+	}
 
-			resGitHubToken, err := c.String(section, "github_token")
-			if err == nil {
-				res.GitHubToken = resGitHubToken
+	for _, key := range s.Keys() {
+		if strings.HasPrefix(key, "xattr.") && len(key) > len("xattr.") {
+			value, err := s.String(key)
+			if err != nil {
+				return nil, fmt.Errorf("%s: Section %s invalid %s: %v", resConfig, section, key, err)
 			}
+			if res.Xattrs == nil {
+				res.Xattrs = map[string]string{}
+			}
+			res.Xattrs[strings.TrimPrefix(key, "xattr.")] = value
+		}
+	}
+
+	resSELinuxContext, err := s.String("selinux_context")
+	if err == nil {
+		res.SELinuxContext = resSELinuxContext
+	}
+
+	resDrift, err := s.String("drift")
+	if err == nil {
+		if resDrift != drift.PolicyLog && resDrift != drift.PolicyRestore {
+			return nil, fmt.Errorf("%s: Section %s invalid drift %s", resConfig, section, resDrift)
+		}
+		// Merged files and blocks are edited locally on purpose
+		if res.Mode != resource.ModeReplace || res.Extract != "" {
+			return nil, fmt.Errorf("%s: Section %s drift requires mode replace and no extract", resConfig, section)
+		}
+		res.Drift = resDrift
+	}
+
+	resSyncGroup, err := s.String("sync_group")
+	if err == nil {
+		if res.Kind != resource.KindFile || res.Extract != "" {
+			return nil, fmt.Errorf("%s: Section %s sync_group requires kind file and no extract", resConfig, section)
+		}
+		res.SyncGroup = resSyncGroup
+	}
+
+	// Nothing is downloaded for the other kinds
+	if res.Kind != resource.KindFile && (res.Template != "" || res.Extract != "" || res.Mode != resource.ModeReplace ||
+		res.BackupEnabled() || len(res.Sources) > 0 || res.Drift != "" || res.Decompress != "") {
+		return nil, fmt.Errorf("%s: Section %s kind %s cannot be combined with template, extract, mode, sources, drift, decompress or backups", resConfig, section, res.Kind)
+	}
+
+	rel, perUser := homePath(s)
+	if s.Has("users") && !perUser {
+		return nil, fmt.Errorf("%s: Section %s users requires a path below ~/", resConfig, section)
+	}
+	if strings.HasPrefix(section, "~*") && !perUser {
+		return nil, fmt.Errorf("%s: Section %s invalid path below ~*/", resConfig, section)
+	}
+
+	if perUser {
+		if primary != nil || res.User != "" || res.SyncGroup != "" || s.Has("depends_on") {
+			return nil, fmt.Errorf("%s: Section %s per-user resources cannot have copy_of, user, sync_group or depends_on", resConfig, section)
+		}
+		// Only what can be done relative to a home directory opened without
+		// following links is allowed, the user controls everything below it
+		if res.Kind == resource.KindAbsent || res.Purge || res.Mode != resource.ModeReplace || res.Extract != "" ||
+			res.BackupEnabled() || res.Drift != "" || res.HealthCommand != "" || len(res.HealthArgv) > 0 ||
+			len(res.ACL) > 0 || len(res.Xattrs) > 0 || res.SELinuxContext != "" {
+			return nil, fmt.Errorf("%s: Section %s per-user resources cannot be kind absent or have purge, mode, extract, backups, drift, health_cmd, acl, xattr or selinux_context", resConfig, section)
+		}
+
+		var targets []accounts.Account
+		resUsers, err := s.List("users")
+		if err == nil {
+			targets, err = accounts.Lookup(resUsers)
+		} else {
+			targets, err = accounts.Regular()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: Section %s users: %v", resConfig, section, err)
 		}
 
-		conn.Resources = append(conn.Resources, &res)
+		res.Path = "~*/" + rel
+		return addPerUser(res, rel, targets, conn), nil
 	}
 
-	return
+	if primary != nil {
+		// Only the install settings apply to copies
+		if res.Kind != resource.KindFile || res.RemotePath != "" || len(res.Sources) > 0 || res.Template != "" ||
+			res.Extract != "" || res.Decompress != "" || primary.Extract != "" || res.PreUpdateCommand != "" || len(res.PreUpdateArgv) > 0 {
+			return nil, fmt.Errorf("%s: Section %s copies cannot have kind, remote_path, sources, template, extract, decompress or pre_update_cmd, or copy an extracted archive", resConfig, section)
+		}
+		// Members of sync groups are installed on their own
+		if res.SyncGroup != "" || primary.SyncGroup != "" {
+			return nil, fmt.Errorf("%s: Section %s sync group members cannot have copies", resConfig, section)
+		}
+		if s.Has("depends_on") {
+			return nil, fmt.Errorf("%s: Section %s copies are installed with %s and cannot have depends_on", resConfig, section, copyOf)
+		}
+		primary.Copies = append(primary.Copies, res)
+		return res, nil
+	}
+
+	conn.Resources = append(conn.Resources, res)
+
+	return res, nil
 }
 
 // Parse - Parse connection and resource settings
 func Parse(connFile string, resFile string) (connections []*connection.Connection, err error) {
-	connections, err = parseConnectionConfig(connFile)
-	if err != nil {
-		return
-	}
-	err = parseResourceConfig(resFile, connections)
-	return
+	return Load(Files{ConnFile: connFile, ResFile: resFile})
 }
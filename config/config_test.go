@@ -0,0 +1,111 @@
+package config
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCopies(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources string
+		copies    []string // Expected copies of /tmp/a
+		err       string   // Expected part of the error, "" for none
+	}{
+		{
+			name: "copies before and after the primary",
+			resources: `
+          - path: /tmp/b
+            copy_of: /tmp/a
+            perms: "0600"
+          - path: /tmp/a
+            remote_path: /a
+          - path: /tmp/c
+            copy_of: /tmp/a
+            connection: web`,
+			copies: []string{"/tmp/b", "/tmp/c"},
+		},
+		{
+			name: "unknown primary",
+			resources: `
+          - path: /tmp/b
+            copy_of: /tmp/x`,
+			err: "copy_of unknown resource /tmp/x",
+		},
+		{
+			name: "remote_path on a copy",
+			resources: `
+          - path: /tmp/a
+            remote_path: /a
+          - path: /tmp/b
+            copy_of: /tmp/a
+            remote_path: /b`,
+			err: "copies cannot have",
+		},
+		{
+			name: "copy in a sync group",
+			resources: `
+          - path: /tmp/a
+            remote_path: /a
+            sync_group: g
+          - path: /tmp/b
+            copy_of: /tmp/a`,
+			err: "sync group members cannot have copies",
+		},
+		{
+			name: "copy with depends_on",
+			resources: `
+          - path: /tmp/a
+            remote_path: /a
+          - path: /tmp/x
+            remote_path: /x
+          - path: /tmp/b
+            copy_of: /tmp/a
+            depends_on: /tmp/x`,
+			err: "cannot have depends_on",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "ironsync.yaml")
+			config := `
+connections:
+  - name: web
+    type: http
+    url: https://example.com
+    resources:` + tt.resources + "\n"
+			if err := ioutil.WriteFile(file, []byte(config), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			connections, err := ParseFile(file)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("ParseFile() error %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseFile() failed: %v", err)
+			}
+
+			_, primary := findResource("/tmp/a", connections)
+			if primary == nil {
+				t.Fatalf("Primary resource not found")
+			}
+			var copies []string
+			for _, c := range primary.Copies {
+				copies = append(copies, c.Path)
+			}
+			if strings.Join(copies, ",") != strings.Join(tt.copies, ",") {
+				t.Errorf("Copies %q, want %q", copies, tt.copies)
+			}
+			if len(connections[0].Resources) != 1 {
+				t.Errorf("%d resources of the connection, want only the primary", len(connections[0].Resources))
+			}
+		})
+	}
+}
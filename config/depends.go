@@ -0,0 +1,175 @@
+package config
+
+import (
+	"fmt"
+	"ironsync/connection"
+	"ironsync/resource"
+	"path"
+	"strings"
+)
+
+// findInstalled - Resource that installs path, the primary resource for
+// copies
+func findInstalled(local_path string, connections []*connection.Connection) *resource.Resource {
+	for _, c := range connections {
+		for _, r := range c.Resources {
+			if r.Path == local_path {
+				return r
+			}
+			for _, dest := range r.Copies {
+				if dest.Path == local_path {
+					return r
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// dependent - Resource with depends_on and the section it was parsed from
+type dependent struct {
+	res *resource.Resource
+	fs  fileSection
+}
+
+// resolveDependencies - Look up the depends_on resources of dependents,
+// reject cycles and order the resources of each connection so dependencies
+// come first
+func resolveDependencies(dependents []dependent, connections []*connection.Connection) error {
+	for _, d := range dependents {
+		res, fs := d.res, d.fs
+
+		deps, err := fs.s.List("depends_on")
+		if err != nil {
+			return sectionError(fs, fmt.Errorf("%s: Section %s invalid depends_on", fs.file, fs.s.Name()))
+		}
+
+		for _, dep := range deps {
+			target := findInstalled(path.Clean(expandHome(dep)), connections)
+			if target == nil {
+				return sectionError(fs, fmt.Errorf("%s: Section %s depends_on unknown resource %s", fs.file, fs.s.Name(), dep))
+			}
+			if target == res {
+				return sectionError(fs, fmt.Errorf("%s: Section %s depends on itself", fs.file, fs.s.Name()))
+			}
+			res.DependsOn = append(res.DependsOn, target)
+		}
+	}
+
+	g := dependencyGraph(connections)
+	acyclic := map[*resource.Resource]bool{}
+	for _, c := range connections {
+		for _, r := range c.Resources {
+			cycle := g.cycle(g.node[r], nil, acyclic)
+			if cycle != nil {
+				return fmt.Errorf("Dependency cycle %s", strings.Join(cycle, " -> "))
+			}
+		}
+	}
+
+	for _, c := range connections {
+		c.Resources = orderResources(c.Resources)
+	}
+	return nil
+}
+
+// graph - Resources updated together collapsed into one node: the members
+// of a sync group wait for the dependencies of all of them
+type graph struct {
+	node    map[*resource.Resource]*resource.Resource   // Resource to the first member of its sync group (itself if it has none)
+	members map[*resource.Resource][]*resource.Resource // Node to its resources
+}
+
+// dependencyGraph - Nodes of the resources of connections
+func dependencyGraph(connections []*connection.Connection) graph {
+	g := graph{map[*resource.Resource]*resource.Resource{}, map[*resource.Resource][]*resource.Resource{}}
+
+	for _, c := range connections {
+		groups := map[string]*resource.Resource{}
+		for _, r := range c.Resources {
+			n := r
+			if r.SyncGroup != "" {
+				if first, ok := groups[r.SyncGroup]; ok {
+					n = first
+				} else {
+					groups[r.SyncGroup] = r
+				}
+			}
+			g.node[r] = n
+			g.members[n] = append(g.members[n], r)
+		}
+	}
+	return g
+}
+
+// name - Sync group or path of node n
+func (g graph) name(n *resource.Resource) string {
+	if n.SyncGroup != "" {
+		return "sync group " + n.SyncGroup
+	}
+	return n.Path
+}
+
+// cycle - Names of a dependency cycle reachable from node n ending where it
+// started, nil if there is none. trail holds the nodes on the way to n,
+// acyclic those already known to lead to no cycle.
+func (g graph) cycle(n *resource.Resource, trail []*resource.Resource, acyclic map[*resource.Resource]bool) []string {
+	if acyclic[n] {
+		return nil
+	}
+
+	for i, visited := range trail {
+		if visited == n {
+			var cycle []string
+			for _, c := range trail[i:] {
+				cycle = append(cycle, g.name(c))
+			}
+			return append(cycle, g.name(n))
+		}
+	}
+
+	trail = append(trail, n)
+	for _, r := range g.members[n] {
+		for _, dep := range r.DependsOn {
+			// Dependencies within a sync group are updated along with it
+			if g.node[dep] == n {
+				continue
+			}
+			cycle := g.cycle(g.node[dep], trail, acyclic)
+			if cycle != nil {
+				return cycle
+			}
+		}
+	}
+	acyclic[n] = true
+	return nil
+}
+
+// orderResources - Resources in file order, except that each one comes
+// after the resources of the same connection it depends on
+func orderResources(resources []*resource.Resource) []*resource.Resource {
+	inConnection := map[*resource.Resource]bool{}
+	for _, r := range resources {
+		inConnection[r] = true
+	}
+
+	var ordered []*resource.Resource
+	added := map[*resource.Resource]bool{}
+
+	var add func(r *resource.Resource)
+	add = func(r *resource.Resource) {
+		if added[r] || !inConnection[r] {
+			return
+		}
+		added[r] = true
+		for _, dep := range r.DependsOn {
+			add(dep)
+		}
+		ordered = append(ordered, r)
+	}
+
+	for _, r := range resources {
+		add(r)
+	}
+	return ordered
+}
@@ -0,0 +1,161 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDependencyCycles(t *testing.T) {
+	tests := []struct {
+		name      string
+		resources string
+		cycle     string // Expected part of the error, "" for none
+	}{
+		{
+			name: "chain",
+			resources: `
+          - path: /tmp/a
+            depends_on: /tmp/b
+          - path: /tmp/b
+            depends_on: /tmp/c
+          - path: /tmp/c`,
+		},
+		{
+			name: "self",
+			resources: `
+          - path: /tmp/a
+            depends_on: /tmp/a`,
+			cycle: "depends on itself",
+		},
+		{
+			name: "two resources",
+			resources: `
+          - path: /tmp/a
+            depends_on: /tmp/b
+          - path: /tmp/b
+            depends_on: /tmp/a`,
+			cycle: "Dependency cycle",
+		},
+		{
+			name: "three resources",
+			resources: `
+          - path: /tmp/a
+            depends_on: /tmp/b
+          - path: /tmp/b
+            depends_on: /tmp/c
+          - path: /tmp/c
+            depends_on: /tmp/a`,
+			cycle: "Dependency cycle",
+		},
+		{
+			name: "within a sync group",
+			resources: `
+          - path: /tmp/a
+            sync_group: g
+            depends_on: /tmp/b
+          - path: /tmp/b
+            sync_group: g`,
+		},
+		{
+			name: "through a sync group",
+			resources: `
+          - path: /tmp/a
+            sync_group: g
+            depends_on: /tmp/x
+          - path: /tmp/b
+            sync_group: g
+          - path: /tmp/x
+            depends_on: /tmp/b`,
+			cycle: "sync group g",
+		},
+		{
+			name: "sync group on a resource outside it",
+			resources: `
+          - path: /tmp/a
+            sync_group: g
+            depends_on: /tmp/x
+          - path: /tmp/b
+            sync_group: g
+          - path: /tmp/x`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "ironsync.yaml")
+			config := `
+connections:
+  - name: web
+    type: http
+    url: https://example.com
+    resources:` + tt.resources + "\n"
+			if err := ioutil.WriteFile(file, []byte(config), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := ParseFile(file)
+			if tt.cycle == "" {
+				if err != nil {
+					t.Fatalf("ParseFile() failed: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("ParseFile() succeeded, want error containing %q", tt.cycle)
+			}
+			if !strings.Contains(err.Error(), tt.cycle) {
+				t.Errorf("ParseFile() error %q, want %q", err, tt.cycle)
+			}
+		})
+	}
+}
+
+func TestAbsentResources(t *testing.T) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		t.Skip("No home directory")
+	}
+
+	tests := []struct {
+		name     string
+		resource string
+		err      string // Expected part of the error, "" for none
+	}{
+		{"file", "path: /tmp/old\n            kind: absent", ""},
+		{"recursive", "path: /tmp/old\n            kind: absent\n            recursive: true", ""},
+		{"recursive without absent", "path: /tmp/old\n            recursive: true", "recursive requires kind absent"},
+		{"root", "path: /\n            kind: absent\n            recursive: true", "refusing to remove"},
+		{"home", "path: " + home + "\n            kind: absent", "refusing to remove"},
+		{"above home", "path: " + filepath.Dir(home) + "\n            kind: absent", "refusing to remove"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			file := filepath.Join(t.TempDir(), "ironsync.yaml")
+			config := `
+connections:
+  - name: web
+    type: http
+    url: https://example.com
+    resources:
+          - ` + tt.resource + "\n"
+			if err := ioutil.WriteFile(file, []byte(config), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := ParseFile(file)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("ParseFile() failed: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("ParseFile() error %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,456 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"ironsync/connection"
+	"ironsync/facts"
+	"ironsync/notify"
+	"ironsync/resource"
+	"path/filepath"
+	"strings"
+
+	"github.com/robfig/config"
+)
+
+// Files - Configuration files to load
+type Files struct {
+	ConnFile string // INI connections file (optional)
+	ResFile  string // INI resources file (optional)
+	File     string // YAML, TOML or JSON file (optional)
+	Dir      string // Drop-in directory (optional)
+}
+
+// fileSection - Section and the file it was read from
+type fileSection struct {
+	file  string
+	s     section
+	pos   positions // Positions of the tables of a YAML, TOML or JSON file
+	table string    // Path of the section in pos
+}
+
+// line - Line of key in the section, of the section itself if key is "".
+// 0 if unknown.
+func (fs fileSection) line(key string) int {
+	if IsStructured(fs.file) {
+		return fs.pos.line(fs.table, key)
+	}
+	return iniKeyLine(fs.file, fs.s.Name(), key)
+}
+
+// origin - file:line where the section is defined, or file if the line is
+// unknown
+func (fs fileSection) origin() string {
+	line := fs.line("")
+	if line == 0 {
+		return fs.file
+	}
+	return fmt.Sprintf("%s:%d", fs.file, line)
+}
+
+// loadError - Error found while loading, with where it was found for Check
+type loadError struct {
+	file    string
+	line    int
+	section string
+	err     error
+}
+
+func (e *loadError) Error() string {
+	return e.err.Error()
+}
+
+// sectionError - Error in the section fs
+func sectionError(fs fileSection, err error) error {
+	return &loadError{file: fs.file, line: fs.line(""), section: fs.s.Name(), err: err}
+}
+
+// parser - Merges connections, resources and variables from several files.
+// Sections are parsed once all files are read, so variables apply
+// everywhere and resources may use connections from any file.
+type parser struct {
+	connections  []*connection.Connection
+	connSections []fileSection   // Connection sections in read order
+	resources    []fileSection   // Resource sections in read order
+	sinkSections []fileSection   // Event sink sections in read order
+	sinks        []*notify.Sink  // Parsed event sinks
+	vars         facts.Vars      // User-defined variables ([vars] sections)
+	files        []string        // Files read, in order
+	included     map[string]bool // Files already read
+}
+
+func createParser() parser {
+	return parser{vars: facts.Vars{}, included: map[string]bool{}}
+}
+
+// Load - Parse connection and resource settings from the given files and
+// their includes, then from the drop-in directory in name order
+func Load(files Files) (connections []*connection.Connection, err error) {
+	p := createParser()
+	return p.load(files)
+}
+
+// LoadSinks - Parse the event sinks of the given files, which are read the
+// same way as by Load
+func LoadSinks(files Files) ([]*notify.Sink, error) {
+	p := createParser()
+	_, err := p.load(files)
+	return p.sinks, err
+}
+
+func (p *parser) load(files Files) (connections []*connection.Connection, err error) {
+	if files.ConnFile != "" {
+		err = p.addConnFile(files.ConnFile)
+		if err != nil {
+			return
+		}
+	}
+
+	if files.ResFile != "" {
+		err = p.addResFile(files.ResFile)
+		if err != nil {
+			return
+		}
+	}
+
+	if files.File != "" {
+		err = p.addStructuredFile(files.File)
+		if err != nil {
+			return
+		}
+	}
+
+	if files.Dir != "" {
+		err = p.addDir(files.Dir)
+		if err != nil {
+			return
+		}
+	}
+
+	return p.finish()
+}
+
+// firstTime - Whether file has not been read yet (files are read once even
+// if included from several places)
+func (p *parser) firstTime(file string) bool {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		abs = file
+	}
+
+	if p.included[abs] {
+		return false
+	}
+	p.included[abs] = true
+	p.files = append(p.files, file)
+	return true
+}
+
+// expandIncludes - Files matching the include patterns, relative to the
+// directory of the including file. Patterns without wildcards must exist.
+func expandIncludes(file string, patterns []string) (files []string, err error) {
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(filepath.Dir(file), pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return files, fmt.Errorf("%s: Invalid include %s", file, pattern)
+		}
+
+		if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+			return files, fmt.Errorf("%s: Missing include %s", file, pattern)
+		}
+
+		files = append(files, matches...)
+	}
+	return
+}
+
+// iniIncludes - Files included by an INI file (include in the DEFAULT section)
+func iniIncludes(file string, c *config.Config) ([]string, error) {
+	value, err := c.String("DEFAULT", "include")
+	if err != nil {
+		return nil, nil
+	}
+	return expandIncludes(file, splitList(value))
+}
+
+// addIniVars - Variables from the [vars] section of an INI file
+func (p *parser) addIniVars(file string, c *config.Config) error {
+	if !c.HasSection("vars") {
+		return nil
+	}
+
+	options, err := c.Options("vars")
+	if err != nil {
+		return err
+	}
+
+	for _, option := range options {
+		// DEFAULT options show up in every section
+		if c.HasOption("DEFAULT", option) {
+			continue
+		}
+
+		value, err := c.String("vars", option)
+		if err != nil {
+			return fmt.Errorf("%s: Section vars invalid %s", file, option)
+		}
+		p.vars[option] = value
+	}
+	return nil
+}
+
+// addConnFile - Read an INI connections file and its includes
+func (p *parser) addConnFile(connFile string) error {
+	if !p.firstTime(connFile) {
+		return nil
+	}
+
+	c, err := config.ReadDefault(connFile)
+	if err != nil {
+		return err
+	}
+
+	err = p.addIniVars(connFile, c)
+	if err != nil {
+		return err
+	}
+
+	for _, section := range c.Sections() {
+		// Skip default section, unused
+		if section == "DEFAULT" || section == "vars" {
+			continue
+		}
+
+		if isSinkSection(section) {
+			p.sinkSections = append(p.sinkSections, fileSection{file: connFile, s: iniSection{c, section, p.vars}})
+			continue
+		}
+
+		p.connSections = append(p.connSections, fileSection{file: connFile, s: iniSection{c, section, p.vars}})
+	}
+
+	includes, err := iniIncludes(connFile, c)
+	if err != nil {
+		return err
+	}
+
+	for _, include := range includes {
+		err = p.addConnFile(include)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addResFile - Read an INI resources file and its includes
+func (p *parser) addResFile(resFile string) error {
+	if !p.firstTime(resFile) {
+		return nil
+	}
+
+	c, err := config.ReadDefault(resFile)
+	if err != nil {
+		return err
+	}
+
+	err = p.addIniVars(resFile, c)
+	if err != nil {
+		return err
+	}
+
+	for _, section := range c.Sections() {
+		// Skip default section, unused
+		if section == "DEFAULT" || section == "vars" {
+			continue
+		}
+
+		if isSinkSection(section) {
+			p.sinkSections = append(p.sinkSections, fileSection{file: resFile, s: iniSection{c, section, p.vars}})
+			continue
+		}
+
+		p.resources = append(p.resources, fileSection{file: resFile, s: iniSection{c, section, p.vars}})
+	}
+
+	includes, err := iniIncludes(resFile, c)
+	if err != nil {
+		return err
+	}
+
+	for _, include := range includes {
+		err = p.addResFile(include)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addDir - Read drop-in files in name order: *.conn.ini (connections),
+// *.res.ini (resources) and YAML, TOML or JSON files. Other files are
+// ignored.
+func (p *parser) addDir(dir string) error {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		file := filepath.Join(dir, entry.Name())
+
+		switch {
+		case strings.HasSuffix(entry.Name(), ".conn.ini"):
+			err = p.addConnFile(file)
+		case strings.HasSuffix(entry.Name(), ".res.ini"):
+			err = p.addResFile(file)
+		case IsStructured(file):
+			err = p.addStructuredFile(file)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// finish - Parse the queued sections once all files are read
+func (p *parser) finish() ([]*connection.Connection, error) {
+	connOrigins := map[string]string{}
+
+	for _, pending := range p.connSections {
+		where := pending.origin()
+
+		if first, ok := connOrigins[pending.s.Name()]; ok {
+			return p.connections, sectionError(pending, fmt.Errorf("%s: Connection defined twice %s (first defined at %s)", where, pending.s.Name(), first))
+		}
+
+		err := pending.s.CheckVars()
+		if err != nil {
+			return p.connections, sectionError(pending, fmt.Errorf("%s: Section %s invalid %v", pending.file, pending.s.Name(), err))
+		}
+
+		conn, err := parseConnection(pending.file, pending.s)
+		if err != nil {
+			return p.connections, sectionError(pending, err)
+		}
+
+		p.connections = append(p.connections, conn)
+		connOrigins[pending.s.Name()] = where
+	}
+
+	sinkOrigins := map[string]string{}
+
+	for _, pending := range p.sinkSections {
+		where := pending.origin()
+		name := sinkName(pending.s.Name())
+
+		if first, ok := sinkOrigins[name]; ok {
+			return p.connections, sectionError(pending, fmt.Errorf("%s: Sink defined twice %s (first defined at %s)", where, name, first))
+		}
+
+		err := pending.s.CheckVars()
+		if err != nil {
+			return p.connections, sectionError(pending, fmt.Errorf("%s: Section %s invalid %v", pending.file, pending.s.Name(), err))
+		}
+
+		sink, err := parseSink(pending.file, pending.s)
+		if err != nil {
+			return p.connections, sectionError(pending, err)
+		}
+
+		p.sinks = append(p.sinks, sink)
+		sinkOrigins[name] = where
+	}
+
+	resOrigins := map[string]string{}
+	// Sync groups are updated by the worker of one connection
+	syncGroups := map[string]string{}
+	// Dependencies may be defined after the resources that use them
+	var dependents []dependent
+
+	// Copies refer to resources that may come later
+	pendingResources := []fileSection{}
+	for _, pending := range p.resources {
+		if !pending.s.Has("copy_of") {
+			pendingResources = append(pendingResources, pending)
+		}
+	}
+	for _, pending := range p.resources {
+		if pending.s.Has("copy_of") {
+			pendingResources = append(pendingResources, pending)
+		}
+	}
+
+	for _, pending := range pendingResources {
+		err := pending.s.CheckVars()
+		if err != nil {
+			return p.connections, sectionError(pending, fmt.Errorf("%s: Section %s invalid %v", pending.file, pending.s.Name(), err))
+		}
+
+		res, err := parseResource(pending.file, pending.s, p.connections)
+		if err != nil {
+			return p.connections, sectionError(pending, err)
+		}
+		res.Vars = p.vars
+
+		where := pending.origin()
+
+		if first, ok := resOrigins[res.Path]; ok {
+			return p.connections, sectionError(pending, fmt.Errorf("%s: Resource defined twice %s (first defined at %s)", where, res.Path, first))
+		}
+		resOrigins[res.Path] = where
+
+		if res.SyncGroup != "" {
+			conn, _ := findResource(res.Path, p.connections)
+			if first, ok := syncGroups[res.SyncGroup]; ok && first != conn.Name {
+				return p.connections, sectionError(pending, fmt.Errorf("%s: Sync group %s uses connections %s and %s", where, res.SyncGroup, first, conn.Name))
+			}
+			syncGroups[res.SyncGroup] = conn.Name
+		}
+
+		if pending.s.Has("depends_on") {
+			dependents = append(dependents, dependent{res, pending})
+		}
+	}
+
+	err := resolveDependencies(dependents, p.connections)
+	if err != nil {
+		return p.connections, err
+	}
+
+	// Directories that purge keep the resources inside them
+	for _, conn := range p.connections {
+		for _, res := range conn.Resources {
+			if res.Kind == resource.KindDirectory && res.Purge {
+				res.Managed = managedPaths(res.Path, p.connections)
+			}
+		}
+	}
+
+	return p.connections, nil
+}
+
+// managedPaths - Paths of the resources below dir
+func managedPaths(dir string, connections []*connection.Connection) []string {
+	var paths []string
+	for _, conn := range connections {
+		for _, res := range conn.Resources {
+			for _, r := range append([]*resource.Resource{res}, res.Copies...) {
+				if strings.HasPrefix(r.Path, dir+"/") {
+					paths = append(paths, r.Path)
+				}
+			}
+		}
+	}
+	return paths
+}
@@ -0,0 +1,101 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadIncludes(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string // Files below the test directory
+		dir     bool              // Read conf.d as drop-in directory
+		want    []string          // Resource paths of connection web
+		wantErr string            // Expected part of the error, "" for none
+	}{
+		{
+			name: "include with wildcard",
+			files: map[string]string{
+				"res.ini":           "include = teams/*.res.ini\n\n[/tmp/ironsync-a]\nconnection = web\n",
+				"teams/b.res.ini":   "[/tmp/ironsync-b]\nconnection = web\n",
+				"teams/c.res.ini":   "include = b.res.ini\n\n[/tmp/ironsync-c]\nconnection = web\n",
+				"teams/README.text": "not read",
+			},
+			want: []string{"/tmp/ironsync-a", "/tmp/ironsync-b", "/tmp/ironsync-c"},
+		},
+		{
+			name: "missing include",
+			files: map[string]string{
+				"res.ini": "include = missing.res.ini\n\n[/tmp/ironsync-a]\nconnection = web\n",
+			},
+			wantErr: "Missing include",
+		},
+		{
+			name: "drop-in directory",
+			files: map[string]string{
+				"res.ini":             "[/tmp/ironsync-a]\nconnection = web\n",
+				"conf.d/20-b.res.ini": "[/tmp/ironsync-b]\nconnection = web\n",
+				"conf.d/10-c.yaml":    "resources:\n  - path: /tmp/ironsync-c\n    connection: web\n",
+				"conf.d/other.conf":   "ignored",
+			},
+			dir:  true,
+			want: []string{"/tmp/ironsync-a", "/tmp/ironsync-c", "/tmp/ironsync-b"},
+		},
+		{
+			name: "duplicate resource in drop-in directory",
+			files: map[string]string{
+				"res.ini":          "[/tmp/ironsync-a]\nconnection = web\n",
+				"conf.d/a.res.ini": "[/tmp/ironsync-a]\nconnection = web\n",
+			},
+			dir:     true,
+			wantErr: "/tmp/ironsync-a",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			files := map[string]string{"conn.ini": "[web]\ntype = http\nurl = https://example.com\n"}
+			for name, content := range tt.files {
+				files[name] = content
+			}
+			for name, content := range files {
+				path := filepath.Join(dir, filepath.FromSlash(name))
+				if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+					t.Fatal(err)
+				}
+				if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			load := Files{ConnFile: filepath.Join(dir, "conn.ini"), ResFile: filepath.Join(dir, "res.ini")}
+			if tt.dir {
+				load.Dir = filepath.Join(dir, "conf.d")
+			}
+
+			connections, err := Load(load)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Load() error %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() failed: %v", err)
+			}
+
+			var paths []string
+			for _, r := range connections[0].Resources {
+				paths = append(paths, r.Path)
+			}
+			if !reflect.DeepEqual(paths, tt.want) {
+				t.Errorf("resources = %v, want %v", paths, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// position - Lines of a table of a YAML, TOML or JSON file and of its keys
+type position struct {
+	line int
+	keys map[string]int
+}
+
+// positions - Positions of the tables of a file by their path, e.g.
+// connections/0/resources/1 ("" is the document)
+type positions map[string]*position
+
+// table - Position of the table at path, created if needed
+func (p positions) table(path string, line int) *position {
+	pos, ok := p[path]
+	if !ok {
+		pos = &position{line: line, keys: map[string]int{}}
+		p[path] = pos
+	}
+	return pos
+}
+
+// line - Line of key in the table at path, the line of the table if key is
+// "" or not found. Tables without a position of their own (e.g. TOML inline
+// tables) are found at the key holding them. 0 if unknown.
+func (p positions) line(path string, key string) int {
+	pos, ok := p[path]
+	if !ok {
+		// connections/0/resources/1 is held by key resources of connections/0
+		parts := strings.Split(path, "/")
+		if len(parts) < 2 {
+			return 0
+		}
+		return p.line(strings.Join(parts[:len(parts)-2], "/"), parts[len(parts)-2])
+	}
+
+	if line, ok := pos.keys[key]; ok && key != "" {
+		return line
+	}
+	return pos.line
+}
+
+// joinPath - Path of the child name of the table at path
+func joinPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "/" + name
+}
+
+// findPositions - Positions of the tables of a decoded YAML, TOML or JSON
+// file. Positions that cannot be determined are left out.
+func findPositions(file string, data []byte) positions {
+	p := positions{}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		var root yaml.Node
+		if yaml.Unmarshal(data, &root) == nil {
+			yamlPositions(p, "", &root)
+		}
+	case ".toml":
+		tomlPositions(p, data)
+	case ".json":
+		d := json.NewDecoder(bytes.NewReader(data))
+		jsonPositions(p, "", d, lineIndex(data))
+	}
+	return p
+}
+
+// yamlPositions - Record the mappings below node
+func yamlPositions(p positions, path string, node *yaml.Node) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		for _, child := range node.Content {
+			yamlPositions(p, path, child)
+		}
+	case yaml.MappingNode:
+		pos := p.table(path, node.Line)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			pos.keys[key.Value] = key.Line
+			yamlPositions(p, joinPath(path, key.Value), value)
+		}
+	case yaml.SequenceNode:
+		for i, child := range node.Content {
+			yamlPositions(p, joinPath(path, strconv.Itoa(i)), child)
+		}
+	}
+}
+
+// lineIndex - Offsets at which the lines of data start
+func lineIndex(data []byte) []int {
+	starts := []int{0}
+	for i, ch := range data {
+		if ch == '\n' {
+			starts = append(starts, i+1)
+		}
+	}
+	return starts
+}
+
+// lineAt - Line (from 1) of offset
+func lineAt(starts []int, offset int64) int {
+	line := 0
+	for line < len(starts) && int64(starts[line]) <= offset {
+		line++
+	}
+	return line
+}
+
+// jsonPositions - Record the objects of the value read next from d.
+// Returns false once the input cannot be read.
+func jsonPositions(p positions, path string, d *json.Decoder, starts []int) bool {
+	token, err := d.Token()
+	if err != nil {
+		return false
+	}
+
+	switch token {
+	case json.Delim('{'):
+		// InputOffset is just past the token read last
+		pos := p.table(path, lineAt(starts, d.InputOffset()-1))
+		for d.More() {
+			key, err := d.Token()
+			if err != nil {
+				return false
+			}
+			name := fmt.Sprint(key)
+			pos.keys[name] = lineAt(starts, d.InputOffset()-1)
+
+			if !jsonPositions(p, joinPath(path, name), d, starts) {
+				return false
+			}
+		}
+		_, err = d.Token()
+		return err == nil
+	case json.Delim('['):
+		for i := 0; d.More(); i++ {
+			if !jsonPositions(p, joinPath(path, strconv.Itoa(i)), d, starts) {
+				return false
+			}
+		}
+		_, err = d.Token()
+		return err == nil
+	}
+	return true
+}
+
+// tomlKey - Line starting with a key, such as key = value
+var tomlKey = regexp.MustCompile(`^\s*("[^"]*"|'[^']*'|[A-Za-z0-9_-]+)(\s*\.\s*("[^"]*"|'[^']*'|[A-Za-z0-9_-]+))*\s*=`)
+
+// tomlHeader - Table header such as [table] or [[array.of.tables]]
+var tomlHeader = regexp.MustCompile(`^\s*(\[\[?)\s*([^\]]+?)\s*(\]\]?)\s*(#.*)?$`)
+
+// tomlPositions - Record the tables of a TOML file. TOML does not report
+// positions, so headers and keys are found by scanning the lines; keys of
+// inline tables and multi-line values are not recorded.
+func tomlPositions(p positions, data []byte) {
+	// Decoding first makes sure the lines are valid TOML
+	var doc map[string]interface{}
+	if _, err := toml.Decode(string(data), &doc); err != nil {
+		return
+	}
+
+	current := p.table("", 1)
+	arrays := map[string]int{} // Array of tables path to its number of tables
+	depth := 0                 // Open brackets and braces of multi-line values
+	multiline := ""            // Delimiter of an open multi-line string
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Text()
+
+		if multiline != "" {
+			if strings.Count(text, multiline)%2 == 1 {
+				multiline = ""
+			}
+			continue
+		}
+
+		if depth == 0 {
+			if m := tomlHeader.FindStringSubmatch(text); m != nil {
+				current = p.table(tomlTablePath(m[2], m[1] == "[[", arrays), line)
+				continue
+			}
+
+			if m := tomlKey.FindStringSubmatch(text); m != nil {
+				key := strings.Trim(m[1], `"'`)
+				if _, ok := current.keys[key]; !ok {
+					current.keys[key] = line
+				}
+			}
+		}
+
+		depth, multiline = tomlScan(text, depth)
+	}
+}
+
+// tomlTablePath - Path of a table header. The parents of an array of tables
+// are its last tables, e.g. [[connections.resources]] belongs to the latest
+// [[connections]].
+func tomlTablePath(header string, array bool, arrays map[string]int) string {
+	path := ""
+	names := strings.Split(header, ".")
+	for i, name := range names {
+		path = joinPath(path, strings.Trim(strings.TrimSpace(name), `"'`))
+
+		if i == len(names)-1 && array {
+			arrays[path]++
+		}
+		if n, ok := arrays[path]; ok {
+			path = joinPath(path, strconv.Itoa(n-1))
+		}
+	}
+	return path
+}
+
+// tomlScan - Bracket depth after line and the delimiter of a multi-line
+// string it leaves open ("" if none)
+func tomlScan(text string, depth int) (int, string) {
+	quote := byte(0)
+
+	for i := 0; i < len(text); i++ {
+		ch := text[i]
+
+		if quote != 0 {
+			if ch == '\\' && quote == '"' {
+				i++
+			} else if ch == quote {
+				quote = 0
+			}
+			continue
+		}
+
+		switch ch {
+		case '#':
+			return depth, ""
+		case '"', '\'':
+			delim := strings.Repeat(string(ch), 3)
+			if !strings.HasPrefix(text[i:], delim) {
+				quote = ch
+				continue
+			}
+
+			// Multi-line string, open until the next delimiter
+			end := strings.Index(text[i+3:], delim)
+			if end < 0 {
+				return depth, delim
+			}
+			i += 3 + end + 2
+		case '[', '{':
+			depth++
+		case ']', '}':
+			if depth > 0 {
+				depth--
+			}
+		}
+	}
+	return depth, ""
+}
@@ -0,0 +1,365 @@
+package config
+
+import (
+	"fmt"
+	"ironsync/facts"
+	"ironsync/utils"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/robfig/config"
+)
+
+// section - Settings of a single connection or resource, independent of
+// the file format. Getters return an error if the key is not set or its
+// value has the wrong type. String values have ${variables} expanded, which
+// cannot fail once CheckVars succeeded.
+type section interface {
+	Name() string
+	Keys() []string
+	Expand(text string) (string, error)
+	CheckVars() error
+	Has(key string) bool
+	String(key string) (string, error)
+	Int(key string) (int, error)
+	Bool(key string) (bool, error)
+	Args(key string) ([]string, error)
+	List(key string) ([]string, error)
+	Mode(key string) (os.FileMode, error)
+}
+
+// parseMode - Parse an octal permission string. The setuid (4000), setgid
+// (2000) and sticky (1000) bits become their os.FileMode flags, which
+// os.Chmod understands.
+func parseMode(value string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(value, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	if mode > 07777 {
+		return 0, fmt.Errorf("Invalid permissions %s", value)
+	}
+
+	perms := os.FileMode(mode) & os.ModePerm
+	if mode&04000 != 0 {
+		perms |= os.ModeSetuid
+	}
+	if mode&02000 != 0 {
+		perms |= os.ModeSetgid
+	}
+	if mode&01000 != 0 {
+		perms |= os.ModeSticky
+	}
+	return perms, nil
+}
+
+// parseBool - Parse a boolean the way robfig/config does
+func parseBool(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "1", "t", "true", "y", "yes", "on":
+		return true, nil
+	case "0", "f", "false", "n", "no", "off":
+		return false, nil
+	}
+	return false, fmt.Errorf("Invalid boolean %s", value)
+}
+
+// credentialKeys - Settings taken as they are, without ${variables}, so
+// their values never end up in an error message
+var credentialKeys = []string{"auth_password", "dropbox_token", "github_token", "secret"}
+
+// splitList - Split a comma separated list, dropping empty items
+func splitList(value string) (items []string) {
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return
+}
+
+// iniSection - Section of an INI file
+type iniSection struct {
+	c    *config.Config
+	name string
+	vars facts.Vars
+}
+
+func (s iniSection) Name() string {
+	return s.name
+}
+
+func (s iniSection) Expand(text string) (string, error) {
+	return s.vars.Expand(text)
+}
+
+func (s iniSection) CheckVars() error {
+	for _, key := range s.Keys() {
+		_, err := s.String(key)
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+	}
+	return nil
+}
+
+func (s iniSection) Keys() []string {
+	options, _ := s.c.Options(s.name)
+	return options
+}
+
+func (s iniSection) Has(key string) bool {
+	return s.c.HasOption(s.name, key)
+}
+
+func (s iniSection) String(key string) (string, error) {
+	value, err := s.c.String(s.name, key)
+	if err != nil || contains(credentialKeys, key) {
+		return value, err
+	}
+	return s.vars.Expand(value)
+}
+
+func (s iniSection) Int(key string) (int, error) {
+	value, err := s.String(key)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(value)
+}
+
+func (s iniSection) Bool(key string) (bool, error) {
+	value, err := s.String(key)
+	if err != nil {
+		return false, err
+	}
+	return parseBool(value)
+}
+
+// Args - Command line split into arguments (quotes group whitespace)
+func (s iniSection) Args(key string) ([]string, error) {
+	value, err := s.String(key)
+	if err != nil {
+		return nil, err
+	}
+	return utils.SplitArgs(value)
+}
+
+// List - Comma separated list
+func (s iniSection) List(key string) ([]string, error) {
+	value, err := s.String(key)
+	if err != nil {
+		return nil, err
+	}
+	return splitList(value), nil
+}
+
+func (s iniSection) Mode(key string) (os.FileMode, error) {
+	value, err := s.String(key)
+	if err != nil {
+		return 0, err
+	}
+	return parseMode(value)
+}
+
+// mapSection - Table of a YAML, TOML or JSON file
+type mapSection struct {
+	name   string
+	values map[string]interface{}
+	vars   facts.Vars
+}
+
+func (s mapSection) Name() string {
+	return s.name
+}
+
+func (s mapSection) Expand(text string) (string, error) {
+	return s.vars.Expand(text)
+}
+
+func (s mapSection) CheckVars() error {
+	for key, value := range s.values {
+		if contains(credentialKeys, key) {
+			continue
+		}
+
+		values := []interface{}{value}
+		if list, ok := value.([]interface{}); ok {
+			values = list
+		}
+
+		// Nested tables (e.g. resources) are checked on their own
+		for _, v := range values {
+			if str, ok := v.(string); ok {
+				_, err := s.vars.Expand(str)
+				if err != nil {
+					return fmt.Errorf("%s: %v", key, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Keys - Sorted keys (decoded tables do not keep file order)
+func (s mapSection) Keys() []string {
+	keys := make([]string, 0, len(s.values))
+	for key := range s.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func (s mapSection) Has(key string) bool {
+	_, ok := s.values[key]
+	return ok
+}
+
+func (s mapSection) value(key string) (interface{}, error) {
+	value, ok := s.values[key]
+	if !ok || value == nil {
+		return nil, fmt.Errorf("Option %s not found", key)
+	}
+	return value, nil
+}
+
+func (s mapSection) String(key string) (string, error) {
+	value, err := s.value(key)
+	if err != nil {
+		return "", err
+	}
+
+	switch v := value.(type) {
+	case string:
+		if contains(credentialKeys, key) {
+			return v, nil
+		}
+		return s.vars.Expand(v)
+	case int, int64, uint64, float64, bool:
+		return fmt.Sprint(v), nil
+	}
+	return "", fmt.Errorf("Option %s is not a string", key)
+}
+
+func (s mapSection) Int(key string) (int, error) {
+	value, err := s.value(key)
+	if err != nil {
+		return 0, err
+	}
+
+	switch v := value.(type) {
+	case int:
+		return v, nil
+	case int64:
+		return int(v), nil
+	case uint64:
+		return int(v), nil
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), nil
+		}
+	case string:
+		str, err := s.vars.Expand(v)
+		if err != nil {
+			return 0, err
+		}
+		return strconv.Atoi(str)
+	}
+	return 0, fmt.Errorf("Option %s is not an integer", key)
+}
+
+func (s mapSection) Bool(key string) (bool, error) {
+	value, err := s.value(key)
+	if err != nil {
+		return false, err
+	}
+
+	switch v := value.(type) {
+	case bool:
+		return v, nil
+	case string:
+		str, err := s.vars.Expand(v)
+		if err != nil {
+			return false, err
+		}
+		return parseBool(str)
+	}
+	return false, fmt.Errorf("Option %s is not a boolean", key)
+}
+
+// Args - List of arguments, or a command line string split into arguments
+func (s mapSection) Args(key string) ([]string, error) {
+	value, err := s.value(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := value.(string); ok {
+		str, err := s.String(key)
+		if err != nil {
+			return nil, err
+		}
+		return utils.SplitArgs(str)
+	}
+
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("Option %s is not a list", key)
+	}
+
+	args := make([]string, len(list))
+	for i, item := range list {
+		switch v := item.(type) {
+		case string:
+			args[i], err = s.vars.Expand(v)
+			if err != nil {
+				return nil, err
+			}
+		case int, int64, uint64, float64, bool:
+			args[i] = fmt.Sprint(v)
+		default:
+			return nil, fmt.Errorf("Option %s item %d is not a string", key, i)
+		}
+	}
+	return args, nil
+}
+
+// List - List of strings, or a comma separated string
+func (s mapSection) List(key string) ([]string, error) {
+	value, err := s.value(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := value.(string); ok {
+		str, err := s.String(key)
+		if err != nil {
+			return nil, err
+		}
+		return splitList(str), nil
+	}
+	return s.Args(key)
+}
+
+// Mode - Octal permissions. Numbers are rejected because YAML and TOML
+// would already have converted 0644 to decimal.
+func (s mapSection) Mode(key string) (os.FileMode, error) {
+	value, err := s.value(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if _, ok := value.(string); !ok {
+		return 0, fmt.Errorf("Option %s must be a quoted octal string", key)
+	}
+
+	str, err := s.String(key)
+	if err != nil {
+		return 0, err
+	}
+	return parseMode(str)
+}
@@ -0,0 +1,58 @@
+package config
+
+import (
+	"ironsync/facts"
+	"os"
+	"testing"
+)
+
+func TestSectionCredentialsLiteral(t *testing.T) {
+	vars := facts.Vars{"host": "example.com"}
+	s := mapSection{"web", map[string]interface{}{
+		"url":           "https://${host}/",
+		"auth_password": "pa${ss",
+		"dropbox_token": "${host}",
+	}, vars}
+
+	if err := s.CheckVars(); err != nil {
+		t.Fatalf("CheckVars() failed: %v", err)
+	}
+
+	for key, want := range map[string]string{
+		"url":           "https://example.com/",
+		"auth_password": "pa${ss",
+		"dropbox_token": "${host}",
+	} {
+		got, err := s.String(key)
+		if err != nil || got != want {
+			t.Errorf("String(%s) = %q, %v, want %q", key, got, err, want)
+		}
+	}
+
+	s.values["url"] = "https://${hots}/"
+	if err := s.CheckVars(); err == nil {
+		t.Errorf("CheckVars() with an unknown variable succeeded")
+	}
+}
+
+func TestParseMode(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    os.FileMode
+		wantErr bool
+	}{
+		{value: "0644", want: 0644},
+		{value: "4755", want: 0755 | os.ModeSetuid},
+		{value: "2775", want: 0775 | os.ModeSetgid},
+		{value: "1777", want: 0777 | os.ModeSticky},
+		{value: "0100644", wantErr: true},
+		{value: "0649", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseMode(tt.value)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseMode(%q) = %v, %v, want %v, error %v", tt.value, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
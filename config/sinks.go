@@ -0,0 +1,191 @@
+package config
+
+import (
+	"fmt"
+	"ironsync/notify"
+	"ironsync/utils"
+	"path"
+	"strings"
+)
+
+const (
+	// sinkPrefix - INI sections named sink.<name> define event sinks
+	sinkPrefix = "sink."
+
+	// Sink types
+	sinkWebhook = "webhook"
+	sinkSMTP    = "smtp"
+	sinkSyslog  = "syslog"
+	sinkCommand = "command"
+)
+
+// sinkCommonKeys - Settings read for every sink
+var sinkCommonKeys = []string{"type", "events", "paths", "rate_limit", "rate_interval", "timeout"}
+
+// sinkKeys - Settings read for each sink type
+var sinkKeys = map[string][]string{
+	sinkWebhook: {"url", "secret"},
+	sinkSMTP:    {"smtp_server", "from", "to"},
+	sinkSyslog:  {"tag"},
+	sinkCommand: {"command", "argv", "user", "group"},
+}
+
+// isSinkSection - Whether an INI section defines a sink
+func isSinkSection(name string) bool {
+	return strings.HasPrefix(name, sinkPrefix) && len(name) > len(sinkPrefix)
+}
+
+// sinkName - Name of a sink section without the INI prefix
+func sinkName(name string) string {
+	return strings.TrimPrefix(name, sinkPrefix)
+}
+
+// parseSink - Parse the settings of a single event sink
+func parseSink(sinkConfig string, s section) (*notify.Sink, error) {
+	section := s.Name()
+
+	sinkType, err := s.String("type")
+	if err != nil {
+		return nil, fmt.Errorf("%s: Section %s missing type", sinkConfig, section)
+	}
+
+	sink := notify.CreateSink(sinkName(section), nil)
+
+	sinkEvents, err := s.List("events")
+	if err == nil {
+		for _, event := range sinkEvents {
+			if !notify.IsEventType(event) {
+				return nil, fmt.Errorf("%s: Section %s invalid event %s", sinkConfig, section, event)
+			}
+		}
+		sink.Events = sinkEvents
+	}
+
+	sinkPaths, err := s.List("paths")
+	if err == nil {
+		for _, pattern := range sinkPaths {
+			pattern = expandHome(pattern)
+			_, err := path.Match(pattern, "")
+			if err != nil {
+				return nil, fmt.Errorf("%s: Section %s invalid path %s", sinkConfig, section, pattern)
+			}
+			sink.Paths = append(sink.Paths, pattern)
+		}
+	}
+
+	sinkRateLimit, err := s.Int("rate_limit")
+	if err == nil {
+		if sinkRateLimit < 0 {
+			return nil, fmt.Errorf("%s: Section %s invalid rate_limit %d", sinkConfig, section, sinkRateLimit)
+		}
+		sink.RateLimit = sinkRateLimit
+	}
+
+	sinkRateInterval, err := s.Int("rate_interval")
+	if err == nil {
+		if sinkRateInterval <= 0 {
+			return nil, fmt.Errorf("%s: Section %s invalid rate_interval %d", sinkConfig, section, sinkRateInterval)
+		}
+		sink.RateInterval = sinkRateInterval
+	}
+
+	sinkTimeout, err := s.Int("timeout")
+	if err == nil {
+		if sinkTimeout <= 0 {
+			return nil, fmt.Errorf("%s: Section %s invalid timeout %d", sinkConfig, section, sinkTimeout)
+		}
+		sink.Timeout = sinkTimeout
+	}
+
+	switch sinkType {
+	case sinkWebhook:
+		webhook := &notify.Webhook{}
+
+		webhook.URL, err = s.String("url")
+		if err != nil {
+			return nil, fmt.Errorf("%s: Section %s missing url", sinkConfig, section)
+		}
+
+		sinkSecret, err := s.String("secret")
+		if err == nil {
+			webhook.Secret, err = secretValue(sinkConfig, section, "secret", sinkSecret, &webhook.SecretRef)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		sink.Sender = webhook
+	case sinkSMTP:
+		mail := &notify.SMTP{Server: notify.DefaultSMTPServer}
+
+		sinkServer, err := s.String("smtp_server")
+		if err == nil {
+			mail.Server = sinkServer
+		}
+
+		mail.From, err = s.String("from")
+		if err != nil {
+			return nil, fmt.Errorf("%s: Section %s missing from", sinkConfig, section)
+		}
+
+		mail.To, err = s.List("to")
+		if err != nil || len(mail.To) == 0 {
+			return nil, fmt.Errorf("%s: Section %s missing to", sinkConfig, section)
+		}
+
+		sink.Sender = mail
+	case sinkSyslog:
+		logger := &notify.Syslog{Tag: "ironsync"}
+
+		sinkTag, err := s.String("tag")
+		if err == nil {
+			logger.Tag = sinkTag
+		}
+
+		sink.Sender = logger
+	case sinkCommand:
+		cmd := &notify.Command{Timeout: sink.Timeout}
+
+		sinkCommand, err := s.String("command")
+		if err == nil {
+			cmd.Command = sinkCommand
+		}
+
+		if s.Has("argv") {
+			if cmd.Command != "" {
+				return nil, fmt.Errorf("%s: Section %s argv conflicts with command", sinkConfig, section)
+			}
+			cmd.Argv, err = s.Args("argv")
+			if err != nil || len(cmd.Argv) == 0 {
+				return nil, fmt.Errorf("%s: Section %s invalid argv", sinkConfig, section)
+			}
+		}
+
+		if cmd.Command == "" && len(cmd.Argv) == 0 {
+			return nil, fmt.Errorf("%s: Section %s missing command", sinkConfig, section)
+		}
+
+		sinkUser, err := s.String("user")
+		if err == nil {
+			cmd.User = sinkUser
+		}
+
+		sinkGroup, err := s.String("group")
+		if err == nil {
+			cmd.Group = sinkGroup
+		}
+
+		if cmd.User != "" || cmd.Group != "" {
+			_, _, err = utils.LookupIDs(cmd.User, cmd.Group)
+			if err != nil {
+				return nil, fmt.Errorf("%s: Section %s %v", sinkConfig, section, err)
+			}
+		}
+
+		sink.Sender = cmd
+	default:
+		return nil, fmt.Errorf("%s: Section %s invalid type %s", sinkConfig, section, sinkType)
+	}
+
+	return &sink, nil
+}
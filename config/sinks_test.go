@@ -0,0 +1,34 @@
+package config
+
+import "testing"
+
+func TestParseSinkLimits(t *testing.T) {
+	tests := []struct {
+		name    string
+		values  map[string]interface{}
+		wantErr bool
+	}{
+		{"defaults", map[string]interface{}{}, false},
+		{"timeout", map[string]interface{}{"timeout": 30}, false},
+		{"zero timeout", map[string]interface{}{"timeout": 0}, true},
+		{"negative timeout", map[string]interface{}{"timeout": -5}, true},
+		{"zero rate_interval", map[string]interface{}{"rate_interval": 0}, true},
+		{"negative rate_limit", map[string]interface{}{"rate_limit": -1}, true},
+	}
+
+	for _, tt := range tests {
+		values := map[string]interface{}{"type": sinkSyslog}
+		for key, value := range tt.values {
+			values[key] = value
+		}
+
+		sink, err := parseSink("ironsync.yaml", mapSection{name: "sink.log", values: values})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: parseSink() error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && sink.Timeout <= 0 {
+			t.Errorf("%s: Timeout = %d", tt.name, sink.Timeout)
+		}
+	}
+}
@@ -0,0 +1,467 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"ironsync/connection"
+	"ironsync/utils"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/robfig/config"
+	"gopkg.in/yaml.v3"
+)
+
+// IsStructured - Whether the file is a YAML, TOML or JSON config (by extension)
+func IsStructured(file string) bool {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml", ".toml", ".json":
+		return true
+	}
+	return false
+}
+
+// decodeFile - Decode a YAML, TOML or JSON file into generic values and
+// find the positions of its tables
+func decodeFile(file string) (doc map[string]interface{}, pos positions, err error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return
+	}
+
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &doc)
+	case ".toml":
+		_, err = toml.Decode(string(data), &doc)
+	case ".json":
+		err = json.Unmarshal(data, &doc)
+	default:
+		err = fmt.Errorf("Unknown config format %s", filepath.Ext(file))
+	}
+
+	if err != nil {
+		return nil, nil, &loadError{file: file, line: decodeErrorLine(data, err), err: fmt.Errorf("%s: %v", file, err)}
+	}
+	return doc, findPositions(file, data), nil
+}
+
+// yamlErrorLine - Line reported in YAML error messages
+var yamlErrorLine = regexp.MustCompile(`line (\d+)`)
+
+// decodeErrorLine - Line of a decoding error, 0 if unknown
+func decodeErrorLine(data []byte, err error) int {
+	switch e := err.(type) {
+	case toml.ParseError:
+		return e.Position.Line
+	case *json.SyntaxError:
+		return lineAt(lineIndex(data), e.Offset)
+	case *json.UnmarshalTypeError:
+		return lineAt(lineIndex(data), e.Offset)
+	}
+
+	m := yamlErrorLine.FindStringSubmatch(err.Error())
+	if m != nil {
+		line, _ := strconv.Atoi(m[1])
+		return line
+	}
+	return 0
+}
+
+// encodeFile - Encode generic values as YAML, TOML or JSON (by extension)
+func encodeFile(file string, doc map[string]interface{}) (data []byte, err error) {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		return yaml.Marshal(doc)
+	case ".toml":
+		var b bytes.Buffer
+		err = toml.NewEncoder(&b).Encode(doc)
+		return b.Bytes(), err
+	case ".json":
+		data, err = json.MarshalIndent(doc, "", "  ")
+		return append(data, '\n'), err
+	}
+	return nil, fmt.Errorf("Unknown config format %s", filepath.Ext(file))
+}
+
+// tableList - List of tables stored under key. TOML decodes arrays of
+// tables differently than YAML and JSON.
+func tableList(values map[string]interface{}, key string) ([]map[string]interface{}, error) {
+	switch list := values[key].(type) {
+	case nil:
+		return nil, nil
+	case []map[string]interface{}:
+		return list, nil
+	case []interface{}:
+		tables := make([]map[string]interface{}, len(list))
+		for i, item := range list {
+			table, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s item %d is not a table", key, i+1)
+			}
+			tables[i] = table
+		}
+		return tables, nil
+	}
+	return nil, fmt.Errorf("%s is not a list", key)
+}
+
+// ParseFile - Parse connection and resource settings from a single YAML,
+// TOML or JSON file and its includes
+func ParseFile(file string) (connections []*connection.Connection, err error) {
+	return Load(Files{File: file})
+}
+
+// addStructuredFile - Read a YAML, TOML or JSON file and its includes.
+// Resources are listed under their connection or in a top-level resources
+// list with a connection key.
+func (p *parser) addStructuredFile(file string) error {
+	if !p.firstTime(file) {
+		return nil
+	}
+
+	doc, pos, err := decodeFile(file)
+	if err != nil {
+		return err
+	}
+
+	switch vars := doc["vars"].(type) {
+	case nil:
+	case map[string]interface{}:
+		for name := range vars {
+			value, err := mapSection{"vars", vars, nil}.String(name)
+			if err != nil {
+				return fmt.Errorf("%s: vars: %v", file, err)
+			}
+			p.vars[name] = value
+		}
+	default:
+		return fmt.Errorf("%s: vars is not a table", file)
+	}
+
+	connTables, err := tableList(doc, "connections")
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+
+	for i, table := range connTables {
+		name, ok := table["name"].(string)
+		if !ok || name == "" {
+			return fmt.Errorf("%s: Connection %d missing name", file, i+1)
+		}
+
+		connPath := joinPath("connections", strconv.Itoa(i))
+		p.connSections = append(p.connSections, fileSection{file, mapSection{name, table, p.vars}, pos, connPath})
+
+		resTables, err := tableList(table, "resources")
+		if err != nil {
+			return fmt.Errorf("%s: Connection %s %v", file, name, err)
+		}
+
+		for j, resTable := range resTables {
+			path, ok := resTable["path"].(string)
+			if !ok || path == "" {
+				return fmt.Errorf("%s: Connection %s resource %d missing path", file, name, j+1)
+			}
+
+			if connName, ok := resTable["connection"]; ok && connName != name {
+				return fmt.Errorf("%s: Section %s nested under connection %s", file, path, name)
+			}
+			resTable["connection"] = name
+
+			resPath := joinPath(connPath, joinPath("resources", strconv.Itoa(j)))
+			p.resources = append(p.resources, fileSection{file, mapSection{path, resTable, p.vars}, pos, resPath})
+		}
+	}
+
+	resTables, err := tableList(doc, "resources")
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+
+	for i, resTable := range resTables {
+		path, ok := resTable["path"].(string)
+		if !ok || path == "" {
+			return fmt.Errorf("%s: Resource %d missing path", file, i+1)
+		}
+		p.resources = append(p.resources, fileSection{file, mapSection{path, resTable, p.vars}, pos, joinPath("resources", strconv.Itoa(i))})
+	}
+
+	sinkTables, err := tableList(doc, "sinks")
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+
+	for i, sinkTable := range sinkTables {
+		name, ok := sinkTable["name"].(string)
+		if !ok || name == "" {
+			return fmt.Errorf("%s: Sink %d missing name", file, i+1)
+		}
+		p.sinkSections = append(p.sinkSections, fileSection{file, mapSection{sinkPrefix + name, sinkTable, p.vars}, pos, joinPath("sinks", strconv.Itoa(i))})
+	}
+
+	if doc["include"] == nil {
+		return nil
+	}
+
+	patterns, err := mapSection{file, doc, nil}.List("include")
+	if err != nil {
+		return fmt.Errorf("%s: %v", file, err)
+	}
+
+	includes, err := expandIncludes(file, patterns)
+	if err != nil {
+		return err
+	}
+
+	for _, include := range includes {
+		if !IsStructured(include) {
+			return fmt.Errorf("%s: Include %s is not a YAML, TOML or JSON file", file, include)
+		}
+
+		err = p.addStructuredFile(include)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// intKeys, boolKeys - Settings that are numbers and booleans. Everything
+// else stays a string, so passwords or usernames such as 007 keep their
+// leading zeros.
+var (
+	intKeys = []string{"interval", "retry_interval", "port", "timeout", "pre_update_cmd_timeout", "post_update_cmd_timeout", "validate_cmd_timeout", "health_cmd_timeout",
+		"backup", "backup_max_age", "strip_components", "rate_limit", "rate_interval"}
+	boolKeys = []string{"persistent", "backup_compress", "dedup", "purge", "recursive"}
+)
+
+// convertValue - Give INI string values the natural type of the target format
+func convertValue(key string, value string) interface{} {
+	if strings.HasSuffix(key, "_argv") {
+		args, err := utils.SplitArgs(value)
+		if err == nil {
+			return args
+		}
+	}
+
+	if contains(intKeys, key) {
+		if i, err := strconv.Atoi(value); err == nil {
+			return i
+		}
+	}
+	if contains(boolKeys, key) && (value == "true" || value == "false") {
+		return value == "true"
+	}
+	return value
+}
+
+// iniTable - Effective options of an INI section as a table, including
+// the settings in known it inherits from DEFAULT. Other DEFAULT options
+// only serve %(name)s interpolation and are left out, as is include
+// (included sections are converted along with the including file).
+func iniTable(c *config.Config, section string, known []string) (map[string]interface{}, error) {
+	options, err := c.Options(section)
+	if err != nil {
+		return nil, err
+	}
+
+	table := map[string]interface{}{}
+	for _, option := range options {
+		if option == "include" {
+			continue
+		}
+		if c.HasOption("DEFAULT", option) && !contains(known, option) && !containsPrefix(known, option) {
+			continue
+		}
+
+		value, err := c.String(section, option)
+		if err != nil {
+			return nil, err
+		}
+		table[option] = convertValue(option, value)
+	}
+	return table, nil
+}
+
+// allResourceKeys - Settings of resources of every connection type
+func allResourceKeys() []string {
+	keys := append([]string{}, resourceKeys...)
+	for _, typeKeys := range resourceTypeKeys {
+		keys = append(keys, typeKeys...)
+	}
+	return keys
+}
+
+// iniFile - INI file read by Convert
+type iniFile struct {
+	name string
+	c    *config.Config
+}
+
+// readIniFiles - file followed by the files it includes, in the order Load
+// reads them. Files in seen are skipped, as Load reads each file once.
+func readIniFiles(file string, seen map[string]bool) ([]iniFile, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		abs = file
+	}
+	if seen[abs] {
+		return nil, nil
+	}
+	seen[abs] = true
+
+	c, err := config.ReadDefault(file)
+	if err != nil {
+		return nil, err
+	}
+	files := []iniFile{{file, c}}
+
+	includes, err := iniIncludes(file, c)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, include := range includes {
+		more, err := readIniFiles(include, seen)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, more...)
+	}
+	return files, nil
+}
+
+// Convert - Write the connections and resources of an INI file pair, and
+// of the files they include, as a single YAML, TOML or JSON file with
+// resources nested under their connection
+func Convert(connFile string, resFile string, outFile string) error {
+	// Refuse to convert anything the service would not accept
+	connections, err := Parse(connFile, resFile)
+	if err != nil {
+		return err
+	}
+
+	seen := map[string]bool{}
+	connFiles, err := readIniFiles(connFile, seen)
+	if err != nil {
+		return err
+	}
+
+	resFiles, err := readIniFiles(resFile, seen)
+	if err != nil {
+		return err
+	}
+
+	doc := map[string]interface{}{}
+
+	// Variables of all files end up in one table
+	vars := map[string]interface{}{}
+	for _, f := range append(append([]iniFile{}, connFiles...), resFiles...) {
+		if !f.c.HasSection("vars") {
+			continue
+		}
+
+		table, err := iniTable(f.c, "vars", nil)
+		if err != nil {
+			return err
+		}
+
+		for name, value := range table {
+			vars[name] = value
+		}
+	}
+
+	if len(vars) > 0 {
+		doc["vars"] = vars
+	}
+
+	var connTables, sinkTables []interface{}
+	byName := map[string]map[string]interface{}{}
+
+	// Sinks may be defined in any file
+	for _, f := range append(append([]iniFile{}, connFiles...), resFiles...) {
+		for _, section := range f.c.Sections() {
+			if !isSinkSection(section) {
+				continue
+			}
+
+			table, err := iniTable(f.c, section, append(append([]string{}, sinkCommonKeys...), allSinkKeys()...))
+			if err != nil {
+				return fmt.Errorf("%s: Section %s: %v", f.name, section, err)
+			}
+			table["name"] = sinkName(section)
+
+			sinkTables = append(sinkTables, table)
+		}
+	}
+
+	for _, f := range connFiles {
+		for _, section := range f.c.Sections() {
+			if section == "DEFAULT" || section == "vars" || isSinkSection(section) {
+				continue
+			}
+
+			table, err := iniTable(f.c, section, allConnectionKeys())
+			if err != nil {
+				return fmt.Errorf("%s: Section %s: %v", f.name, section, err)
+			}
+			table["name"] = section
+
+			connTables = append(connTables, table)
+			byName[section] = table
+		}
+	}
+
+	for _, f := range resFiles {
+		for _, section := range f.c.Sections() {
+			if section == "DEFAULT" || section == "vars" || isSinkSection(section) {
+				continue
+			}
+
+			table, err := iniTable(f.c, section, allResourceKeys())
+			if err != nil {
+				return fmt.Errorf("%s: Section %s: %v", f.name, section, err)
+			}
+			table["path"] = section
+
+			connName, ok := table["connection"].(string)
+			if !ok {
+				// Copies may leave out the connection of their primary resource
+				copyOf, _ := table["copy_of"].(string)
+				conn, _ := findResource(path.Clean(expandHome(copyOf)), connections)
+				if conn != nil {
+					connName = conn.Name
+				}
+			}
+
+			connTable := byName[connName]
+			if connTable == nil {
+				return fmt.Errorf("%s: Section %s has no connection to be nested under", f.name, section)
+			}
+			delete(table, "connection")
+
+			resTables, _ := connTable["resources"].([]interface{})
+			connTable["resources"] = append(resTables, table)
+		}
+	}
+
+	doc["connections"] = connTables
+	if len(sinkTables) > 0 {
+		doc["sinks"] = sinkTables
+	}
+
+	data, err := encodeFile(outFile, doc)
+	if err != nil {
+		return err
+	}
+
+	// Credentials may be included
+	return ioutil.WriteFile(outFile, data, 0600)
+}
@@ -0,0 +1,210 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConvertValue(t *testing.T) {
+	tests := []struct {
+		key   string
+		value string
+		want  interface{}
+	}{
+		{"interval", "60", 60},
+		{"port", "22", 22},
+		{"interval", "soon", "soon"},
+		{"perms", "0644", "0644"},
+		{"dir_perms", "0755", "0755"},
+		{"gist_id", "0123456789", "0123456789"},
+		{"zip", "01234", "01234"},
+		{"persistent", "true", true},
+		{"purge", "false", false},
+		{"dedup", "yes", "yes"},
+		{"enabled", "true", "true"},
+		{"post_update_argv", "systemctl 'reload' nginx", []string{"systemctl", "reload", "nginx"}},
+	}
+
+	for _, tt := range tests {
+		got := convertValue(tt.key, tt.value)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("convertValue(%q, %q) = %#v, want %#v", tt.key, tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		name     string
+		resFile  string
+		included map[string]string // Files included by resFile
+		wantErr  bool
+		wantPath []string                     // Resource paths nested under connection web
+		want     map[string]map[string]string // Settings of resources by path
+	}{
+		{
+			name: "resources and copies",
+			resFile: `
+[/tmp/ironsync-a]
+connection = web
+remote_path = a
+perms = 0640
+
+[/tmp/ironsync-b]
+copy_of = /tmp/ironsync-a
+perms = 0600
+`,
+			wantPath: []string{"/tmp/ironsync-a", "/tmp/ironsync-b"},
+		},
+		{
+			name: "included resources",
+			resFile: `include = teams/*.res.ini
+
+[/tmp/ironsync-a]
+connection = web
+remote_path = a
+perms = 0640
+`,
+			included: map[string]string{
+				"teams/b.res.ini": "[/tmp/ironsync-b]\nconnection = web\nremote_path = b\n",
+				"teams/c.res.ini": "[/tmp/ironsync-c]\ncopy_of = /tmp/ironsync-a\n",
+			},
+			wantPath: []string{"/tmp/ironsync-a", "/tmp/ironsync-b", "/tmp/ironsync-c"},
+		},
+		{
+			name: "DEFAULT section",
+			resFile: `[DEFAULT]
+team = web
+interval = 300
+
+[vars]
+site = example
+
+[/tmp/ironsync-a]
+connection = %(team)s
+remote_path = %(team)s/a
+perms = 0640
+interval = 60
+
+[/tmp/ironsync-b]
+connection = web
+remote_path = b
+`,
+			wantPath: []string{"/tmp/ironsync-a", "/tmp/ironsync-b"},
+			want: map[string]map[string]string{
+				"/tmp/ironsync-a": {"remote_path": "web/a", "interval": "60"},
+				"/tmp/ironsync-b": {"remote_path": "b", "interval": "300"},
+			},
+		},
+		{
+			name: "copy of a copy",
+			resFile: `
+[/tmp/ironsync-a]
+connection = web
+remote_path = a
+
+[/tmp/ironsync-b]
+copy_of = /tmp/ironsync-a
+
+[/tmp/ironsync-c]
+copy_of = /tmp/ironsync-b
+`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			connFile := filepath.Join(dir, "conn.ini")
+			resFile := filepath.Join(dir, "res.ini")
+			outFile := filepath.Join(dir, "ironsync.yaml")
+
+			err := ioutil.WriteFile(connFile, []byte("[web]\ntype = http\nurl = https://example.com\n"), 0644)
+			if err == nil {
+				err = ioutil.WriteFile(resFile, []byte(tt.resFile), 0644)
+			}
+			for name, content := range tt.included {
+				if err == nil {
+					err = os.MkdirAll(filepath.Dir(filepath.Join(dir, name)), 0755)
+				}
+				if err == nil {
+					err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+				}
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = Convert(connFile, resFile, outFile)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Convert() succeeded, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Convert() failed: %v", err)
+			}
+
+			doc, _, err := decodeFile(outFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			connections, err := tableList(doc, "connections")
+			if err != nil || len(connections) != 1 {
+				t.Fatalf("connections = %v, %v", connections, err)
+			}
+			resources, err := tableList(connections[0], "resources")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var paths []string
+			for _, r := range resources {
+				paths = append(paths, r["path"].(string))
+				if _, ok := r["connection"]; ok {
+					t.Errorf("%s: connection kept in nested resource", r["path"])
+				}
+				if _, ok := r["include"]; ok {
+					t.Errorf("%s: include kept in nested resource", r["path"])
+				}
+				if _, ok := r["team"]; ok {
+					t.Errorf("%s: DEFAULT option kept in nested resource", r["path"])
+				}
+				for key, want := range tt.want[r["path"].(string)] {
+					if got := fmt.Sprint(r[key]); got != want {
+						t.Errorf("%s: %s = %s, want %s", r["path"], key, got, want)
+					}
+				}
+			}
+			if _, ok := connections[0]["team"]; ok {
+				t.Errorf("DEFAULT option kept in connection")
+			}
+			if vars, ok := doc["vars"].(map[string]interface{}); ok {
+				if _, ok := vars["team"]; ok {
+					t.Errorf("DEFAULT option kept in vars")
+				}
+			}
+			if !reflect.DeepEqual(paths, tt.wantPath) {
+				t.Errorf("paths = %v, want %v", paths, tt.wantPath)
+			}
+			if perms := resources[0]["perms"]; perms != "0640" {
+				t.Errorf("perms = %#v, want \"0640\"", perms)
+			}
+
+			// The converted file must load like the INI pair
+			_, err = ParseFile(outFile)
+			if err != nil {
+				t.Errorf("ParseFile() of converted file failed: %v", err)
+			}
+			if diags := Check(Files{File: outFile}); HasErrors(diags) {
+				t.Errorf("Check() of converted file = %v", diags)
+			}
+		})
+	}
+}
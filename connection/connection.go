@@ -1,13 +1,19 @@
 package connection
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
+	"ironsync/decompress"
 	"ironsync/resource"
+	"ironsync/secret"
 	"net"
 	"net/http"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 
 	"time"
 
@@ -50,7 +56,7 @@ const (
 	DefaultFTPPort = 21
 )
 
-type downloadFunc func(*Connection, *resource.Resource, *os.File) (bool, error)
+type downloadFunc func(context.Context, *Connection, *resource.Resource, *os.File) (bool, error)
 
 // Connection - Remote connection object
 type Connection struct {
@@ -75,40 +81,111 @@ type Connection struct {
 	AuthPassword  string
 	PrivateKey    string
 	DropboxToken  string // Dropbox OAuth 2 access token
+
+	// Secret references (e.g. env:FTP_PASS) resolved into the fields above.
+	// AuthPassword and DropboxToken change on reload with lock held, the
+	// download functions read them with lock held.
+	AuthPasswordRef string
+	DropboxTokenRef string
+
+	// Serializes downloads, resources of other connections may use this
+	// connection for their sources
+	lock *sync.Mutex
+	// Guards changes of the clients, so Interrupt can close them while a
+	// download holds lock
+	clientLock *sync.Mutex
+	// Open network connections of the FTP client, closed by Interrupt
+	ftpConns map[*ftpConn]bool
+}
+
+// ftpConn - Network connection of the FTP client that leaves ftpConns when
+// it is closed
+type ftpConn struct {
+	net.Conn
+	c *Connection
+}
+
+func (conn *ftpConn) Close() error {
+	conn.c.clientLock.Lock()
+	delete(conn.c.ftpConns, conn)
+	conn.c.clientLock.Unlock()
+	return conn.Conn.Close()
+}
+
+// dialFTP - Dial a control or data connection of the FTP client
+func (c *Connection) dialFTP(network, address string) (net.Conn, error) {
+	netConn, err := net.DialTimeout(network, address, time.Duration(c.Timeout)*time.Second)
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &ftpConn{netConn, c}
+	c.clientLock.Lock()
+	c.ftpConns[conn] = true
+	c.clientLock.Unlock()
+	return conn, nil
 }
 
-func downloadFTP(c *Connection, r *resource.Resource, tmpFile *os.File) (modified bool, err error) {
-	if c.FTPClient == nil {
+// ftpClient, sftpClient - Current client, nil if there is none
+func (c *Connection) ftpClient() *ftp.ServerConn {
+	c.clientLock.Lock()
+	defer c.clientLock.Unlock()
+	return c.FTPClient
+}
+
+func (c *Connection) sftpClient() *sftp.Client {
+	c.clientLock.Lock()
+	defer c.clientLock.Unlock()
+	return c.SFTPClient
+}
+
+// setFTPClient, setSFTPClient - Replace a client, with lock held
+func (c *Connection) setFTPClient(client *ftp.ServerConn) {
+	c.clientLock.Lock()
+	c.FTPClient = client
+	c.clientLock.Unlock()
+}
+
+func (c *Connection) setSFTPClient(client *sftp.Client) {
+	c.clientLock.Lock()
+	c.SFTPClient = client
+	c.clientLock.Unlock()
+}
+
+func downloadFTP(ctx context.Context, c *Connection, r *resource.Resource, tmpFile *os.File) (modified bool, err error) {
+	// Interrupt may clear the client meanwhile, the download then fails
+	client := c.ftpClient()
+	if client == nil {
 		addr := fmt.Sprintf("%s:%d", c.Hostname, c.Port)
 
-		conn, err := ftp.DialTimeout(addr, time.Duration(c.Timeout)*time.Second)
+		conn, err := ftp.Dial(addr, ftp.DialWithTimeout(time.Duration(c.Timeout)*time.Second), ftp.DialWithDialFunc(c.dialFTP))
 		if err != nil {
 			return modified, err
 		}
 
 		err = conn.Login(c.AuthUsername, c.AuthPassword)
 		if err != nil {
+			conn.Quit()
 			return modified, err
 		}
 
-		c.FTPClient = conn
+		c.setFTPClient(conn)
+		client = conn
 	}
 
 	if c.Persistent {
-		defer c.FTPClient.Logout()
-		defer c.FTPClient.Quit()
-		defer func() {
-			c.FTPClient = nil
-		}()
+		defer client.Logout()
+		defer client.Quit()
+		defer c.setFTPClient(nil)
 	}
 
-	remoteFile, err := c.FTPClient.Retr(r.RemotePath)
+	remoteFile, err := client.Retr(r.RemotePath)
 	if err != nil {
 		return
 	}
 	defer remoteFile.Close()
 
-	_, err = io.Copy(tmpFile, remoteFile)
+	_, err = utils.CopyContext(ctx, tmpFile, remoteFile)
 	if err != nil {
 		return
 	}
@@ -116,8 +193,10 @@ func downloadFTP(c *Connection, r *resource.Resource, tmpFile *os.File) (modifie
 	return true, err
 }
 
-func downloadSFTP(c *Connection, r *resource.Resource, tmpFile *os.File) (modified bool, err error) {
-	if c.SFTPClient == nil {
+func downloadSFTP(ctx context.Context, c *Connection, r *resource.Resource, tmpFile *os.File) (modified bool, err error) {
+	// Interrupt may clear the client meanwhile, the download then fails
+	client := c.sftpClient()
+	if client == nil {
 		var auths []ssh.AuthMethod
 
 		aconn, err := net.Dial("unix", os.Getenv("SSH_AUTH_SOCK"))
@@ -151,31 +230,30 @@ func downloadSFTP(c *Connection, r *resource.Resource, tmpFile *os.File) (modifi
 			conn.Close()
 			return modified, err
 		}
-		c.SFTPClient = sftpClient
+		c.setSFTPClient(sftpClient)
+		client = sftpClient
 	}
 
 	if !c.Persistent {
-		defer c.SFTPClient.Close()
-		defer func() {
-			c.SFTPClient = nil
-		}()
+		defer client.Close()
+		defer c.setSFTPClient(nil)
 	}
 
-	remoteFile, err := c.SFTPClient.Open(r.RemotePath)
+	remoteFile, err := client.Open(r.RemotePath)
 	if err != nil {
 		return
 	}
 	defer remoteFile.Close()
 
-	_, err = io.Copy(tmpFile, remoteFile)
+	_, err = utils.CopyContext(ctx, tmpFile, remoteFile)
 	if err != nil {
 		return
 	}
 
-	return
+	return true, err
 }
 
-func downloadHTTP(c *Connection, r *resource.Resource, tmpFile *os.File) (modified bool, err error) {
+func downloadHTTP(ctx context.Context, c *Connection, r *resource.Resource, tmpFile *os.File) (modified bool, err error) {
 	url := c.URL
 
 	if c.Type == ConnectionTypeGitHubGist {
@@ -188,7 +266,7 @@ func downloadHTTP(c *Connection, r *resource.Resource, tmpFile *os.File) (modifi
 		Timeout: time.Duration(c.Timeout) * time.Second,
 	}
 
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return
 	}
@@ -198,6 +276,8 @@ func downloadHTTP(c *Connection, r *resource.Resource, tmpFile *os.File) (modifi
 	}
 
 	req.Header.Set("If-Modified-Since", r.LastModifiedTime.UTC().Format(http.TimeFormat))
+	// Setting Accept-Encoding turns off the transport's own gzip handling
+	req.Header.Set("Accept-Encoding", "gzip, zstd")
 
 	resp, err := client.Do(req)
 	if err != nil {
@@ -222,11 +302,32 @@ func downloadHTTP(c *Connection, r *resource.Resource, tmpFile *os.File) (modifi
 		return false, fmt.Errorf("Connection failed to %s (%d)", url, resp.StatusCode)
 	}
 
-	_, err = io.Copy(tmpFile, resp.Body)
+	var body io.Reader = resp.Body
+
+	encoding := strings.ToLower(resp.Header.Get("Content-Encoding"))
+	switch encoding {
+	case "", "identity":
+	case "gzip", "x-gzip", "zstd":
+		format := decompress.Gzip
+		if encoding == "zstd" {
+			format = decompress.Zstd
+		}
+
+		reader, err := decompress.NewReader(resp.Body, format)
+		if err != nil {
+			return false, err
+		}
+		defer reader.Close()
+		body = reader
+	default:
+		return false, fmt.Errorf("Unsupported content encoding %s from %s", encoding, url)
+	}
+
+	_, err = utils.CopyContext(ctx, tmpFile, body)
 	return true, err
 }
 
-func downloadDropbox(c *Connection, r *resource.Resource, tmpFile *os.File) (modified bool, err error) {
+func downloadDropbox(ctx context.Context, c *Connection, r *resource.Resource, tmpFile *os.File) (modified bool, err error) {
 	config := dropbox.NewConfig(c.DropboxToken)
 	config.HTTPClient.Timeout = time.Duration(c.Timeout) * time.Second
 
@@ -252,13 +353,15 @@ func downloadDropbox(c *Connection, r *resource.Resource, tmpFile *os.File) (mod
 		return
 	}
 
-	_, err = io.Copy(tmpFile, dstOutput.Body)
+	defer dstOutput.Body.Close()
+
+	_, err = utils.CopyContext(ctx, tmpFile, dstOutput.Body)
 	return true, err
 }
 
 // CreateConnection - Create a base connection
 func CreateConnection(name string, connType int, connDownloadFunc downloadFunc) Connection {
-	return Connection{name, connType, []*resource.Resource{}, connDownloadFunc, nil, nil, DefaultTimeout, false, "", "", 0, DefaultMaxPacketSize, "", "", "", ""}
+	return Connection{name, connType, []*resource.Resource{}, connDownloadFunc, nil, nil, DefaultTimeout, false, "", "", 0, DefaultMaxPacketSize, "", "", "", "", "", "", &sync.Mutex{}, &sync.Mutex{}, map[*ftpConn]bool{}}
 }
 
 // CreateHTTPConnection - Create a new HTTP connection
@@ -301,21 +404,154 @@ func CreateDropboxConnection(name, token string) Connection {
 	return c
 }
 
-// Download - Download resource
-func (c *Connection) Download(r *resource.Resource) (modified bool, path string, err error) {
-	tmpFile, err := ioutil.TempFile("", c.Name)
+// ResolveSecrets - Look up secret references again, e.g. after they were
+// rotated. Fields keep their value if a reference cannot be resolved. The
+// credentials are replaced with lock held, other connections may be
+// downloading sources through this one.
+func (c *Connection) ResolveSecrets() error {
+	authPassword, dropboxToken := "", ""
+	if c.AuthPasswordRef != "" {
+		var err error
+		authPassword, err = secret.Resolve(c.AuthPasswordRef)
+		if err != nil {
+			return err
+		}
+	}
+
+	if c.DropboxTokenRef != "" {
+		var err error
+		dropboxToken, err = secret.Resolve(c.DropboxTokenRef)
+		if err != nil {
+			return err
+		}
+	}
+
+	c.lock.Lock()
+	if c.AuthPasswordRef != "" {
+		c.AuthPassword = authPassword
+	}
+	if c.DropboxTokenRef != "" {
+		c.DropboxToken = dropboxToken
+	}
+	c.lock.Unlock()
+
+	for _, r := range c.Resources {
+		err := r.ResolveSecrets()
+		if err != nil {
+			return fmt.Errorf("%s: %v", r.Path, err)
+		}
+	}
+	return nil
+}
+
+// Interrupt - Close the clients to unblock transfers stuck on the network,
+// making the downloads still using them fail. The FTP client is not safe
+// for concurrent use, so its network connections are closed instead of
+// quitting it. The clients are cleared, Close has nothing left to do.
+func (c *Connection) Interrupt() {
+	c.clientLock.Lock()
+	defer c.clientLock.Unlock()
+
+	if c.SFTPClient != nil {
+		c.SFTPClient.Close()
+		c.SFTPClient = nil
+	}
+
+	for conn := range c.ftpConns {
+		conn.Conn.Close()
+		delete(c.ftpConns, conn)
+	}
+	c.FTPClient = nil
+}
+
+// Close - Close persistent clients, waiting for a running download
+func (c *Connection) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.SFTPClient != nil {
+		c.SFTPClient.Close()
+		c.setSFTPClient(nil)
+	}
+
+	if c.FTPClient != nil {
+		c.FTPClient.Quit()
+		c.setFTPClient(nil)
+	}
+}
+
+// decompressFile - Replace the downloaded file f with its decompressed
+// content, synced to disk
+func decompressFile(ctx context.Context, f *os.File, format string) error {
+	_, err := f.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	reader, err := decompress.NewReader(f, format)
+	if err != nil {
+		return fmt.Errorf("Decompressing failed: %v", err)
+	}
+	defer reader.Close()
+
+	out, err := ioutil.TempFile(filepath.Dir(f.Name()), filepath.Base(f.Name()))
+	if err != nil {
+		return err
+	}
+	utils.TrackTempFile(out.Name())
+	defer utils.RemoveTempFile(out.Name())
+
+	_, err = utils.CopyContext(ctx, out, reader)
+	if err == nil {
+		err = out.Sync()
+	}
+	out.Close()
+	if err != nil {
+		return fmt.Errorf("Decompressing failed: %v", err)
+	}
+
+	return os.Rename(out.Name(), f.Name())
+}
+
+// Download - Download resource, aborting when ctx is cancelled. The file is
+// staged next to the resource so it can be renamed into place atomically.
+// Per-user resources are staged in the temp directory instead, nothing is
+// written below a home directory before it is opened safely.
+func (c *Connection) Download(ctx context.Context, r *resource.Resource) (modified bool, path string, err error) {
+	dir := ""
+	if r.Home == "" {
+		dir = filepath.Dir(r.Path)
+
+		err = utils.MkdirAll(dir, r.DirPerms)
+		if err != nil {
+			return
+		}
+	}
+
+	tmpFile, err := ioutil.TempFile(dir, "."+filepath.Base(r.Path)+".ironsync")
 	if err != nil {
 		return
 	}
 	defer tmpFile.Close()
 
+	utils.TrackTempFile(tmpFile.Name())
+
 	if c.DownloadFunc == nil {
 		log.Fatalf("Missing DownloadFunc for connection: %d", c.Type)
 	}
 
-	modified, err = c.DownloadFunc(c, r, tmpFile)
+	c.lock.Lock()
+	modified, err = c.DownloadFunc(ctx, c, r, tmpFile)
+	c.lock.Unlock()
+	if err == nil && modified {
+		if r.Decompress != "" {
+			err = decompressFile(ctx, tmpFile, r.Decompress)
+		} else {
+			err = tmpFile.Sync()
+		}
+	}
 	if err != nil {
-		defer os.Remove(tmpFile.Name())
+		defer utils.RemoveTempFile(tmpFile.Name())
 	}
 	return modified, tmpFile.Name(), err
 }
@@ -0,0 +1,66 @@
+package connection
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestInterruptClosesFTPConnections(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	// The server accepts and never answers, like a stuck transfer
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	c := CreateFTPConnection("ftp", "127.0.0.1", "user", "password")
+
+	closed, err := c.dialFTP("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed.Close()
+	if len(c.ftpConns) != 0 {
+		t.Errorf("closed connection still tracked")
+	}
+
+	stuck, err := c.dialFTP("tcp", ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := stuck.Read(make([]byte, 1))
+		done <- err
+	}()
+
+	c.Interrupt()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Read() after Interrupt() succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Read() still blocked after Interrupt()")
+	}
+
+	if len(c.ftpConns) != 0 || c.ftpClient() != nil {
+		t.Errorf("Interrupt() left connections or the client")
+	}
+
+	// Nothing is left to quit
+	c.Close()
+}
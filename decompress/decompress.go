@@ -0,0 +1,86 @@
+package decompress
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const (
+	// Gzip - gzip compressed content
+	Gzip = "gzip"
+	// Zstd - Zstandard compressed content
+	Zstd = "zstd"
+	// Xz - xz compressed content
+	Xz = "xz"
+	// Auto - Detect the format from the content, uncompressed content is
+	// passed through
+	Auto = "auto"
+)
+
+// Magic numbers at the start of compressed content
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// IsFormat - Whether format is a valid decompress setting
+func IsFormat(format string) bool {
+	switch format {
+	case Gzip, Zstd, Xz, Auto:
+		return true
+	}
+	return false
+}
+
+// Detect - Format of the content starting with header, "" if it is not
+// compressed in a known format
+func Detect(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, gzipMagic):
+		return Gzip
+	case bytes.HasPrefix(header, zstdMagic):
+		return Zstd
+	case bytes.HasPrefix(header, xzMagic):
+		return Xz
+	}
+	return ""
+}
+
+// NewReader - Reader returning the decompressed content of r
+func NewReader(r io.Reader, format string) (io.ReadCloser, error) {
+	if format == Auto {
+		br := bufio.NewReader(r)
+		header, _ := br.Peek(len(xzMagic))
+		format = Detect(header)
+		if format == "" {
+			return ioutil.NopCloser(br), nil
+		}
+		r = br
+	}
+
+	switch format {
+	case Gzip:
+		return gzip.NewReader(r)
+	case Zstd:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	case Xz:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.NopCloser(xr), nil
+	}
+	return nil, fmt.Errorf("Unknown compression format %s", format)
+}
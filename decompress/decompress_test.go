@@ -0,0 +1,91 @@
+package decompress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+const content = "line one\nline two\n"
+
+// compress - content compressed in format
+func compress(t *testing.T, format string) []byte {
+	var b bytes.Buffer
+	var w io.WriteCloser
+	var err error
+
+	switch format {
+	case Gzip:
+		w = gzip.NewWriter(&b)
+	case Zstd:
+		w, err = zstd.NewWriter(&b)
+	case Xz:
+		w, err = xz.NewWriter(&b)
+	default:
+		return []byte(content)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := io.WriteString(w, content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return b.Bytes()
+}
+
+func TestNewReader(t *testing.T) {
+	tests := []struct {
+		compressed string // Format the content is compressed in, "" for none
+		format     string // Format passed to NewReader
+		wantErr    bool
+	}{
+		{compressed: Gzip, format: Gzip},
+		{compressed: Zstd, format: Zstd},
+		{compressed: Xz, format: Xz},
+		{compressed: Gzip, format: Auto},
+		{compressed: Zstd, format: Auto},
+		{compressed: Xz, format: Auto},
+		{compressed: "", format: Auto},
+		{compressed: "", format: Gzip, wantErr: true},
+		{compressed: Gzip, format: Xz, wantErr: true},
+		{compressed: Gzip, format: "bzip2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		data := compress(t, tt.compressed)
+		if got := Detect(data); got != tt.compressed {
+			t.Errorf("Detect() of %q content = %q", tt.compressed, got)
+		}
+
+		r, err := NewReader(bytes.NewReader(data), tt.format)
+		var got []byte
+		if err == nil {
+			got, err = ioutil.ReadAll(r)
+			r.Close()
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q content as %s: error = %v, want error %v", tt.compressed, tt.format, err, tt.wantErr)
+			continue
+		}
+		if err == nil && string(got) != content {
+			t.Errorf("%q content as %s = %q, want %q", tt.compressed, tt.format, got, content)
+		}
+	}
+}
+
+func TestIsFormat(t *testing.T) {
+	for format, want := range map[string]bool{Gzip: true, Zstd: true, Xz: true, Auto: true, "": false, "zip": false} {
+		if got := IsFormat(format); got != want {
+			t.Errorf("IsFormat(%q) = %v, want %v", format, got, want)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"ironsync/connection"
+	"ironsync/resource"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dependencyTracker - Outcome of the last update of each resource, shared by
+// the connection workers so resources wait for the ones they depend on
+type dependencyTracker struct {
+	lock    sync.Mutex
+	updated map[*resource.Resource]time.Time // Next update of resources whose last update succeeded
+	waiting map[*resource.Resource]bool      // Resources whose wait is logged
+}
+
+// dependencies - Tracks the resources of all connections
+var dependencies = &dependencyTracker{
+	updated: map[*resource.Resource]time.Time{},
+	waiting: map[*resource.Resource]bool{},
+}
+
+// done - Record the outcome of an update of r, after its next update time
+// is set
+func (t *dependencyTracker) done(r *resource.Resource, ok bool) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	if ok {
+		t.updated[r] = r.NextUpdateTime
+	} else {
+		delete(t.updated, r)
+	}
+}
+
+// wait - Whether r has to wait for a dependency that has not been updated
+// successfully yet, failed or is due for an update. Dependencies listed
+// in together are updated along with r (sync groups). Logs the first wait.
+func (t *dependencyTracker) wait(c *connection.Connection, r *resource.Resource, together []*resource.Resource) bool {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	var pending []string
+	for _, dep := range r.DependsOn {
+		if containsResource(together, dep) {
+			continue
+		}
+		next, ok := t.updated[dep]
+		if !ok || time.Now().After(next) {
+			pending = append(pending, dep.Path)
+		}
+	}
+
+	if len(pending) == 0 {
+		if t.waiting[r] {
+			log.Printf("[%s][%s] Dependencies updated", c.Name, r.Path)
+			delete(t.waiting, r)
+		}
+		return false
+	}
+
+	if !t.waiting[r] {
+		log.Printf("[%s][%s] Waiting for %s", c.Name, r.Path, strings.Join(pending, ", "))
+		t.waiting[r] = true
+	}
+	return true
+}
+
+func containsResource(resources []*resource.Resource, r *resource.Resource) bool {
+	for _, i := range resources {
+		if i == r {
+			return true
+		}
+	}
+	return false
+}
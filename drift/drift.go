@@ -0,0 +1,445 @@
+package drift
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"ironsync/utils"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// DefaultDir - Default location of the last known good files
+	DefaultDir = "/var/lib/ironsync/cache"
+
+	// PolicyLog - Log drift and leave the file alone
+	PolicyLog = "log"
+	// PolicyRestore - Log drift and restore the last known good file
+	PolicyRestore = "restore"
+
+	// settle - Time to wait for more changes before checking a file
+	settle = 500 * time.Millisecond
+)
+
+// State - Last known good content, mode and ownership of a file
+type State struct {
+	Hash string      `json:"hash"`
+	Mode os.FileMode `json:"mode"`
+	UID  int         `json:"uid"`
+	GID  int         `json:"gid"`
+}
+
+// watched - A managed file
+type watched struct {
+	connection string
+	policy     string
+	busy       bool        // Being installed, changes are expected
+	timer      *time.Timer // Pending check
+}
+
+// Watcher - Watches managed files for local changes and restores them from
+// the cache of last known good files
+type Watcher struct {
+	Dir     string                                 // Cache directory
+	OnDrift func(connection, path, message string) // Called for each drift detected (optional)
+	// Applies the attributes State does not hold (ACLs, extended attributes,
+	// SELinux context) of path to target, the restored file before it is
+	// renamed into place or path itself (optional)
+	OnRestore func(connection, path, target string) error
+
+	fsw   *fsnotify.Watcher
+	lock  sync.Mutex
+	files map[string]*watched
+	dirs  map[string]bool
+}
+
+// CreateWatcher - Create a watcher keeping last known good files in dir
+func CreateWatcher(dir string) (*Watcher, error) {
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Watcher{
+		Dir:   dir,
+		fsw:   fsw,
+		files: map[string]*watched{},
+		dirs:  map[string]bool{},
+	}, nil
+}
+
+// cachePath - Cache location of a file, without extension
+func (w *Watcher) cachePath(path string) string {
+	return filepath.Join(w.Dir, url.PathEscape(path))
+}
+
+// Watch - Start watching path. The directory is watched, so the file is
+// noticed when it is deleted or replaced. A file that already differs from
+// its cached state is checked right away.
+func (w *Watcher) Watch(connection string, path string, policy string) error {
+	dir := filepath.Dir(path)
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	if !w.dirs[dir] {
+		err := w.fsw.Add(dir)
+		if err != nil {
+			return err
+		}
+		w.dirs[dir] = true
+	}
+
+	w.files[path] = &watched{connection: connection, policy: policy}
+	w.schedule(path)
+	return nil
+}
+
+// Pause - Ignore changes to path while it is being installed
+func (w *Watcher) Pause(path string) {
+	if w == nil {
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	f, ok := w.files[path]
+	if ok {
+		f.busy = true
+	}
+}
+
+// Update - Record path as it is now as the last known good file and watch
+// for changes again
+func (w *Watcher) Update(path string) {
+	if w == nil {
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	f, ok := w.files[path]
+	if !ok {
+		return
+	}
+	f.busy = false
+
+	err := w.save(path)
+	if err != nil {
+		log.Printf("[%s][%s] Caching last known good file failed: %v", f.connection, path, err)
+	}
+}
+
+// Adopt - Record path as the last known good file if nothing is known about
+// it yet, e.g. a file that was already up to date when first synced
+func (w *Watcher) Adopt(path string) {
+	if w == nil {
+		return
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	f, ok := w.files[path]
+	if !ok {
+		return
+	}
+
+	_, err := w.load(path)
+	if !os.IsNotExist(err) {
+		return
+	}
+
+	err = w.save(path)
+	if err != nil {
+		log.Printf("[%s][%s] Caching last known good file failed: %v", f.connection, path, err)
+	}
+}
+
+// Cached - Whether a last known good file of path is cached. False on a
+// nil watcher.
+func (w *Watcher) Cached(path string) bool {
+	if w == nil {
+		return false
+	}
+
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	_, err := w.load(path)
+	return err == nil
+}
+
+// Record - Record path as it is now as the last known good file in the
+// cache directory dir, if it has been cached before. Lets another process
+// (rollback) change a watched file without it being restored.
+func Record(dir string, path string) error {
+	w := &Watcher{Dir: dir}
+
+	_, err := w.load(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return w.save(path)
+}
+
+// Run - Handle file events until stop is closed
+func (w *Watcher) Run(stop <-chan struct{}) {
+	for {
+		select {
+		case <-stop:
+			w.fsw.Close()
+			w.lock.Lock()
+			for _, f := range w.files {
+				if f.timer != nil {
+					f.timer.Stop()
+				}
+			}
+			w.lock.Unlock()
+			return
+		case event, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			w.lock.Lock()
+			w.schedule(event.Name)
+			w.lock.Unlock()
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			log.Printf("Watching files failed: %v", err)
+		}
+	}
+}
+
+// schedule - Check path once changes settle. Called with the lock held.
+func (w *Watcher) schedule(path string) {
+	f, ok := w.files[path]
+	if !ok || f.busy {
+		return
+	}
+
+	if f.timer != nil {
+		f.timer.Stop()
+	}
+	f.timer = time.AfterFunc(settle, func() {
+		w.check(path)
+	})
+}
+
+// check - Compare path with its last known good state and act on drift
+func (w *Watcher) check(path string) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	f, ok := w.files[path]
+	if !ok || f.busy {
+		return
+	}
+
+	good, err := w.load(path)
+	if os.IsNotExist(err) {
+		// Nothing known about the file yet
+		return
+	} else if err != nil {
+		log.Printf("[%s][%s] Reading last known good file failed: %v", f.connection, path, err)
+		return
+	}
+
+	current, err := stateOf(path)
+	if os.IsNotExist(err) {
+		w.drifted(f, path, "file deleted", good, true)
+		return
+	} else if err != nil {
+		log.Printf("[%s][%s] Checking for drift failed: %v", f.connection, path, err)
+		return
+	}
+
+	switch {
+	case current.Hash != good.Hash:
+		w.drifted(f, path, "content changed", good, true)
+	case current.Mode != good.Mode:
+		w.drifted(f, path, fmt.Sprintf("mode changed from %s to %s", utils.FormatMode(good.Mode), utils.FormatMode(current.Mode)), good, false)
+	case current.UID != good.UID || current.GID != good.GID:
+		w.drifted(f, path, fmt.Sprintf("owner changed from %d:%d to %d:%d", good.UID, good.GID, current.UID, current.GID), good, false)
+	}
+}
+
+// drifted - Log drift and restore the file if the policy says so
+func (w *Watcher) drifted(f *watched, path string, what string, good State, content bool) {
+	if f.policy != PolicyRestore {
+		log.Printf("[%s][%s] Drift detected: %s", f.connection, path, what)
+		w.report(f, path, what)
+		return
+	}
+
+	log.Printf("[%s][%s] Drift detected: %s, restoring last known good file", f.connection, path, what)
+
+	var err error
+	if content {
+		err = w.restore(f, path, good)
+	} else {
+		err = setState(path, good)
+		if err == nil {
+			err = w.applyAttributes(f, path, path)
+		}
+	}
+	if err != nil {
+		log.Printf("[%s][%s] Restoring last known good file failed: %v", f.connection, path, err)
+		w.report(f, path, fmt.Sprintf("%s, restoring last known good file failed: %v", what, err))
+		return
+	}
+	w.report(f, path, what+", last known good file restored")
+}
+
+// report - Pass drift on to OnDrift
+func (w *Watcher) report(f *watched, path string, message string) {
+	if w.OnDrift != nil {
+		w.OnDrift(f.connection, path, message)
+	}
+}
+
+// applyAttributes - Pass target on to OnRestore
+func (w *Watcher) applyAttributes(f *watched, path string, target string) error {
+	if w.OnRestore == nil {
+		return nil
+	}
+	return w.OnRestore(f.connection, path, target)
+}
+
+// stateOf - Current state of path
+func stateOf(path string) (State, error) {
+	fileInfo, err := os.Lstat(path)
+	if err != nil {
+		return State{}, err
+	}
+
+	hash := ""
+	if fileInfo.Mode().IsRegular() {
+		hash, err = utils.HashFile(path)
+		if err != nil {
+			return State{}, err
+		}
+	}
+
+	uid, gid := utils.FileOwner(fileInfo)
+	return State{Hash: hash, Mode: fileInfo.Mode(), UID: uid, GID: gid}, nil
+}
+
+// setState - Set the ownership and mode of path, keeping the setuid,
+// setgid and sticky bits. The mode is set last, chown clears setuid and
+// setgid.
+func setState(path string, s State) error {
+	if s.UID != -1 || s.GID != -1 {
+		err := os.Chown(path, s.UID, s.GID)
+		if err != nil {
+			return err
+		}
+	}
+	return os.Chmod(path, s.Mode&utils.ModeBits)
+}
+
+// save - Copy path and its state to the cache, unless it is already there
+func (w *Watcher) save(path string) error {
+	current, err := stateOf(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	good, err := w.load(path)
+	if err == nil && good == current {
+		return nil
+	}
+
+	err = os.MkdirAll(w.Dir, 0700)
+	if err != nil {
+		return err
+	}
+
+	cachePath := w.cachePath(path)
+
+	err = utils.CopyFile(path, cachePath+".data.tmp", 0600)
+	if err != nil {
+		os.Remove(cachePath + ".data.tmp")
+		return err
+	}
+
+	err = utils.ReplaceFile(cachePath+".data.tmp", cachePath+".data")
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+
+	err = ioutil.WriteFile(cachePath+".json.tmp", content, 0600)
+	if err != nil {
+		os.Remove(cachePath + ".json.tmp")
+		return err
+	}
+	return utils.ReplaceFile(cachePath+".json.tmp", cachePath+".json")
+}
+
+// load - Cached state of path
+func (w *Watcher) load(path string) (State, error) {
+	var s State
+
+	content, err := ioutil.ReadFile(w.cachePath(path) + ".json")
+	if err != nil {
+		return s, err
+	}
+
+	err = json.Unmarshal(content, &s)
+	return s, err
+}
+
+// restore - Put the cached content back, staged next to path and renamed
+// into place
+func (w *Watcher) restore(f *watched, path string, good State) error {
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".ironsync")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+	utils.TrackTempFile(tmpPath)
+	defer utils.RemoveTempFile(tmpPath)
+
+	err = utils.CopyFile(w.cachePath(path)+".data", tmpPath, 0600)
+	if err != nil {
+		return err
+	}
+
+	hash, err := utils.HashFile(tmpPath)
+	if err != nil {
+		return err
+	}
+	if hash != good.Hash {
+		return fmt.Errorf("Cached file does not match its hash")
+	}
+
+	err = setState(tmpPath, good)
+	if err != nil {
+		return err
+	}
+
+	err = w.applyAttributes(f, path, tmpPath)
+	if err != nil {
+		return err
+	}
+	return utils.ReplaceFile(tmpPath, path)
+}
@@ -0,0 +1,176 @@
+package drift
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// createWatcher - Watcher of path with its cache in a temp directory
+func createWatcher(t *testing.T, path string, policy string) *Watcher {
+	t.Helper()
+
+	w, err := CreateWatcher(filepath.Join(t.TempDir(), "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Watch("web", path, policy); err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+// cachedHash - Hash of the last known good file of path, "" if none
+func cachedHash(w *Watcher, path string) string {
+	s, _ := w.load(path)
+	return s.Hash
+}
+
+func TestCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(path, []byte("first"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w := createWatcher(t, path, PolicyLog)
+
+	if err := Record(w.Dir, path); err != nil || cachedHash(w, path) != "" {
+		t.Errorf("Record() of an uncached file = %v, cached %q", err, cachedHash(w, path))
+	}
+
+	w.Adopt(path)
+	first := cachedHash(w, path)
+	if first == "" {
+		t.Fatalf("Adopt() cached nothing")
+	}
+
+	// A file already known is not adopted again, e.g. after it drifted
+	if err := ioutil.WriteFile(path, []byte("drifted"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w.Adopt(path)
+	if cachedHash(w, path) != first {
+		t.Errorf("Adopt() replaced the last known good file")
+	}
+
+	w.Pause(path)
+	w.Update(path)
+	second := cachedHash(w, path)
+	if second == first || second == "" {
+		t.Errorf("Update() did not cache the installed file")
+	}
+
+	if err := ioutil.WriteFile(path, []byte("rolled back"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := Record(w.Dir, path); err != nil {
+		t.Fatalf("Record() failed: %v", err)
+	}
+	if h := cachedHash(w, path); h == second || h == "" {
+		t.Errorf("Record() did not cache the rolled back file")
+	}
+
+	// Nil watchers (drift not used) do nothing
+	var none *Watcher
+	none.Pause(path)
+	none.Update(path)
+	none.Adopt(path)
+}
+
+func TestRestore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(path, []byte("good"), 0640); err != nil {
+		t.Fatal(err)
+	}
+	w := createWatcher(t, path, PolicyRestore)
+	w.Adopt(path)
+
+	reports := make(chan string, 10)
+	w.OnDrift = func(connection, path, message string) {
+		reports <- message
+	}
+	targets := make(chan string, 10)
+	w.OnRestore = func(connection, path, target string) error {
+		targets <- target
+		return nil
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go w.Run(stop)
+
+	if err := ioutil.WriteFile(path, []byte("bad"), 0640); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case message := <-reports:
+		if message != "content changed, last known good file restored" {
+			t.Errorf("Reported %q", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No drift reported")
+	}
+
+	content, err := ioutil.ReadFile(path)
+	if err != nil || string(content) != "good" {
+		t.Errorf("Restored content %q, %v, want good", content, err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0640 {
+		t.Errorf("Restored mode %v, want 0640", info.Mode())
+	}
+
+	// Attributes are applied to the staged file before it is renamed
+	select {
+	case target := <-targets:
+		if target == path || filepath.Dir(target) != filepath.Dir(path) {
+			t.Errorf("OnRestore() target %s, want a file staged next to %s", target, path)
+		}
+	default:
+		t.Errorf("OnRestore() not called")
+	}
+
+	if _, err := os.Stat(w.cachePath(path) + ".json.tmp"); !os.IsNotExist(err) {
+		t.Errorf("Cached state left staged: %v", err)
+	}
+}
+
+func TestRestoreMode(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(path, []byte("good"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	mode := os.FileMode(0755) | os.ModeSetuid | os.ModeSetgid
+	if err := os.Chmod(path, mode); err != nil {
+		t.Fatal(err)
+	}
+	w := createWatcher(t, path, PolicyRestore)
+	w.Adopt(path)
+
+	reports := make(chan string, 10)
+	w.OnDrift = func(connection, path, message string) {
+		reports <- message
+	}
+
+	stop := make(chan struct{})
+	defer close(stop)
+	go w.Run(stop)
+
+	if err := os.Chmod(path, 0700|os.ModeSetuid|os.ModeSetgid); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case message := <-reports:
+		if message != "mode changed from 6755 to 6700, last known good file restored" {
+			t.Errorf("Reported %q", message)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("No drift reported")
+	}
+
+	if info, _ := os.Stat(path); info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky) != mode {
+		t.Errorf("Restored mode %v, want %v", info.Mode(), mode)
+	}
+}
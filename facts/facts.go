@@ -0,0 +1,149 @@
+package facts
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+const (
+	// maxDepth - Limit for variables referring to variables
+	maxDepth = 10
+)
+
+// Facts - Information about the host, available to config values as
+// ${hostname} etc. and to templates as {{.hostname}}
+type Facts struct {
+	Hostname string // Short host name
+	FQDN     string // Fully qualified domain name (Hostname if unknown)
+	OS       string // Operating system (runtime.GOOS)
+	Arch     string // Architecture (runtime.GOARCH)
+	User     string // User running ironsync
+}
+
+var (
+	current Facts
+	once    sync.Once
+)
+
+// fqdn - Fully qualified name of the host from DNS, or hostname
+func fqdn(hostname string) string {
+	addrs, err := net.LookupHost(hostname)
+	if err != nil {
+		return hostname
+	}
+
+	for _, addr := range addrs {
+		names, err := net.LookupAddr(addr)
+		if err == nil && len(names) > 0 {
+			return strings.TrimSuffix(names[0], ".")
+		}
+	}
+	return hostname
+}
+
+// Get - Facts about this host, gathered on first use
+func Get() Facts {
+	once.Do(func() {
+		hostname, _ := os.Hostname()
+
+		current = Facts{
+			Hostname: strings.SplitN(hostname, ".", 2)[0],
+			FQDN:     fqdn(hostname),
+			OS:       runtime.GOOS,
+			Arch:     runtime.GOARCH,
+		}
+
+		u, err := user.Current()
+		if err == nil {
+			current.User = u.Username
+		}
+	})
+	return current
+}
+
+// Map - Facts by variable name
+func (f Facts) Map() map[string]string {
+	return map[string]string{
+		"hostname": f.Hostname,
+		"fqdn":     f.FQDN,
+		"os":       f.OS,
+		"arch":     f.Arch,
+		"user":     f.User,
+	}
+}
+
+// Vars - User-defined variables. Names are looked up in Vars, then in the
+// host facts, then in the environment.
+type Vars map[string]string
+
+// Lookup - Raw value of a variable
+func (v Vars) Lookup(name string) (string, bool) {
+	if value, ok := v[name]; ok {
+		return value, true
+	}
+
+	if value, ok := Get().Map()[name]; ok {
+		return value, true
+	}
+
+	return os.LookupEnv(name)
+}
+
+// Expand - Replace ${name} with the value of the variable. $${ is a
+// literal ${. Unknown variables are an error.
+func (v Vars) Expand(text string) (string, error) {
+	return v.expand(text, 0)
+}
+
+func (v Vars) expand(text string, depth int) (string, error) {
+	if !strings.Contains(text, "${") {
+		return text, nil
+	}
+
+	if depth > maxDepth {
+		return "", fmt.Errorf("Variables nested too deeply")
+	}
+
+	var b strings.Builder
+	for {
+		i := strings.Index(text, "${")
+		if i < 0 {
+			b.WriteString(text)
+			break
+		}
+
+		// Escaped
+		if i > 0 && text[i-1] == '$' {
+			b.WriteString(text[:i-1])
+			b.WriteString("${")
+			text = text[i+2:]
+			continue
+		}
+
+		end := strings.Index(text[i:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("Unterminated variable")
+		}
+
+		name := text[i+2 : i+end]
+		value, ok := v.Lookup(name)
+		if !ok {
+			return "", fmt.Errorf("Unknown variable ${%s}", name)
+		}
+
+		value, err := v.expand(value, depth+1)
+		if err != nil {
+			return "", err
+		}
+
+		b.WriteString(text[:i])
+		b.WriteString(value)
+		text = text[i+end+1:]
+	}
+	return b.String(), nil
+}
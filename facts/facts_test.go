@@ -0,0 +1,52 @@
+package facts
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestExpand(t *testing.T) {
+	t.Setenv("IRONSYNC_TEST_VAR", "from env")
+
+	v := Vars{
+		"base":  "/srv",
+		"app":   "${base}/app",
+		"loop":  "${loop}",
+		"token": "s3cret",
+		"os":    "overridden",
+	}
+
+	tests := []struct {
+		text    string
+		want    string
+		wantErr string // Expected part of the error, "" for none
+	}{
+		{text: "plain $HOME", want: "plain $HOME"},
+		{text: "${base}/a", want: "/srv/a"},
+		{text: "${app}/data", want: "/srv/app/data"},
+		{text: "${os}", want: "overridden"},
+		{text: "${arch}", want: runtime.GOARCH},
+		{text: "${IRONSYNC_TEST_VAR}", want: "from env"},
+		{text: "$${base} ${base}", want: "${base} /srv"},
+		{text: "${missing}", wantErr: "Unknown variable ${missing}"},
+		{text: "${base", wantErr: "Unterminated variable"},
+		{text: "${loop}", wantErr: "nested too deeply"},
+		{text: "${token}${", wantErr: "Unterminated variable"},
+	}
+
+	for _, tt := range tests {
+		got, err := v.Expand(tt.text)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expand(%q) error %v, want %q", tt.text, err, tt.wantErr)
+			} else if strings.Contains(err.Error(), "s3cret") {
+				t.Errorf("Expand(%q) error %q contains a value", tt.text, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Expand(%q) = %q, %v, want %q", tt.text, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"ironsync/connection"
+	"ironsync/notify"
+	"ironsync/resource"
+	"ironsync/utils"
+	"log"
+	"strings"
+	"time"
+)
+
+// groupMember - Staged file of a sync group member
+type groupMember struct {
+	r        *resource.Resource
+	path     string   // Staged file
+	prevPath string   // Previous file kept for restoring ("" if there was none)
+	data     hookData // Command fields of the member
+}
+
+// syncGroupMembers - Resources of the connection in sync group name
+func syncGroupMembers(c *connection.Connection, name string) []*resource.Resource {
+	var members []*resource.Resource
+	for _, r := range c.Resources {
+		if r.SyncGroup == name {
+			members = append(members, r)
+		}
+	}
+	return members
+}
+
+// postUpdateHook, healthHook - Command of a member for runGroupHooks and
+// whether it is a template
+func postUpdateHook(r *resource.Resource) (string, []string, int, bool) {
+	return r.PostUpdateCommand, r.PostUpdateArgv, r.PostUpdateCommandTimeout, false
+}
+
+func healthHook(r *resource.Resource) (string, []string, int, bool) {
+	return r.HealthCommand, r.HealthArgv, r.HealthCommandTimeout, true
+}
+
+// runGroupHooks - Run each distinct command of the members once, with the
+// fields of the first member that has it
+func runGroupHooks(ctx context.Context, c *connection.Connection, members []*groupMember, name string, hook func(*resource.Resource) (string, []string, int, bool)) error {
+	seen := map[string]bool{}
+
+	for _, m := range members {
+		command, argv, timeout, templated := hook(m.r)
+		if command == "" && len(argv) == 0 {
+			continue
+		}
+
+		key := command + "\x00" + strings.Join(argv, "\x00")
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		err := runHook(ctx, c, m.r, name, templated, command, argv, timeout, &m.data)
+		if err != nil {
+			return fmt.Errorf("%s: %v", m.r.Path, err)
+		}
+	}
+	return nil
+}
+
+// restoreGroup - Put back the previous files of the installed members
+func restoreGroup(installed []*groupMember) error {
+	var failed []string
+	for i := len(installed) - 1; i >= 0; i-- {
+		m := installed[i]
+		err := restoreFile(m.prevPath, m.r.Path)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", m.r.Path, err))
+		}
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// processGroup - Update the members of a sync group together. Every member
+// is downloaded, staged and validated first; if any of them fails nothing
+// is installed. The changed files are then installed, and each distinct
+// post-update and health command of the group runs once. A failing health
+// command restores the previous files of all of them.
+func processGroup(ctx context.Context, c *connection.Connection, members []*resource.Resource) (bool, error) {
+	var all, changed []*groupMember
+
+	for _, r := range members {
+		m := &groupMember{r: r, data: hookData{Path: r.Path, RemotePath: r.RemotePath, Connection: c.Name}}
+		m.data.OldHash, _ = utils.HashFile(r.Path)
+		m.data.NewHash = m.data.OldHash
+		all = append(all, m)
+
+		modified, path, err := downloadResource(ctx, c, r, &m.data)
+		if err != nil {
+			return false, fmt.Errorf("%s: %v", r.Path, err)
+		}
+		defer utils.RemoveTempFile(path)
+
+		if !modified {
+			continue
+		}
+
+		staged, err := stageFile(ctx, c, r, path, &m.data)
+		if err != nil {
+			return false, fmt.Errorf("%s: %v", r.Path, err)
+		}
+
+		if staged {
+			m.path = path
+			changed = append(changed, m)
+		}
+	}
+
+	if len(changed) == 0 {
+		return false, nil
+	}
+
+	var installed []*groupMember
+
+	for _, m := range changed {
+		err := backupFile(c, m.r)
+		if err == nil {
+			m.prevPath, err = utils.KeepFile(m.r.Path)
+			if err != nil {
+				err = fmt.Errorf("Keeping previous file failed: %v", err)
+			}
+		}
+		if m.prevPath != "" {
+			defer utils.RemoveTempFile(m.prevPath)
+		}
+
+		if err == nil {
+			// Whatever is in place when processing ends is the last known good file
+			driftWatcher.Pause(m.r.Path)
+			defer driftWatcher.Update(m.r.Path)
+
+			err = utils.ReplaceFile(m.path, m.r.Path)
+			if err != nil {
+				err = fmt.Errorf("Moving file failed %s: %v", m.path, err)
+			}
+		}
+
+		if err != nil {
+			restoreErr := restoreGroup(installed)
+			if restoreErr != nil {
+				return false, fmt.Errorf("%s: %v, restoring installed members failed: %v", m.r.Path, err, restoreErr)
+			}
+			return false, fmt.Errorf("%s: %v, installed members restored", m.r.Path, err)
+		}
+
+		m.data.TmpPath = ""
+		installed = append(installed, m)
+	}
+
+	err := runGroupHooks(ctx, c, all, "Post-update cmd", postUpdateHook)
+	if err != nil {
+		return false, fmt.Errorf("Post-update cmd failed: %v", err)
+	}
+
+	err = runGroupHooks(ctx, c, all, "Health cmd", healthHook)
+	if err != nil {
+		log.Printf("[%s][sync group %s] Health cmd failed, restoring previous files: %v", c.Name, changed[0].r.SyncGroup, err)
+		for _, m := range installed {
+			notifier.Emit(notify.EventRollback, c.Name, m.r.Path, fmt.Sprintf("Health cmd failed: %v", err))
+		}
+
+		restoreErr := restoreGroup(installed)
+		if restoreErr != nil {
+			return false, fmt.Errorf("Health cmd failed: %v, restoring previous files failed: %v", err, restoreErr)
+		}
+
+		for _, m := range changed {
+			m.data.OldHash, m.data.NewHash = m.data.NewHash, m.data.OldHash
+		}
+
+		postErr := runGroupHooks(ctx, c, all, "Post-update cmd", postUpdateHook)
+		if postErr != nil {
+			return false, fmt.Errorf("Health cmd failed: %v, post-update cmd after restore failed: %v", err, postErr)
+		}
+
+		return false, fmt.Errorf("Health cmd failed, previous files restored: %v", err)
+	}
+
+	for _, m := range changed {
+		log.Printf("[%s][%s] Installed with sync group %s", c.Name, m.r.Path, m.r.SyncGroup)
+		notifier.Emit(notify.EventUpdated, c.Name, m.r.Path, "")
+		recordPatch(c, m.r, &m.data)
+		m.r.SetLastUpdateTime()
+	}
+	return true, nil
+}
+
+// updateGroup - Update sync group name if any member is due (or force is
+// set) and schedule all members together
+func updateGroup(ctx context.Context, c *connection.Connection, name string, force bool) {
+	members := syncGroupMembers(c, name)
+
+	due := force
+	for _, r := range members {
+		if time.Now().After(r.NextUpdateTime) {
+			due = true
+		}
+	}
+	if !due {
+		return
+	}
+
+	for _, r := range members {
+		if dependencies.wait(c, r, members) {
+			// Stay due until the dependencies are updated
+			r.NextUpdateTime = time.Time{}
+			return
+		}
+	}
+
+	if force {
+		log.Printf("[%s][sync group %s] Force updating sync group", c.Name, name)
+	} else {
+		log.Printf("[%s][sync group %s] Updating sync group", c.Name, name)
+	}
+
+	modified, err := processGroup(ctx, c, members)
+	for _, r := range members {
+		reconcileResource(c, r)
+	}
+
+	if err != nil {
+		log.Printf("[%s][sync group %s] Sync group failed to update: %v", c.Name, name, err)
+		for _, r := range members {
+			r.SetNextUpdateTime(r.RetryInterval)
+			dependencies.done(r, false)
+			reportOutcome(c, r, false, err)
+		}
+		return
+	}
+
+	if modified {
+		log.Printf("[%s][sync group %s] Sync group successfully updated", c.Name, name)
+	} else {
+		log.Printf("[%s][sync group %s] Sync group not modified", c.Name, name)
+	}
+	for _, r := range members {
+		r.SetNextUpdateTime(r.Interval)
+		dependencies.done(r, true)
+		// processGroup reports the members it installed
+		reportOutcome(c, r, false, nil)
+	}
+}
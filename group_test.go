@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"ironsync/connection"
+	"ironsync/resource"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRestoreGroup(t *testing.T) {
+	dir := t.TempDir()
+
+	// a replaced a previous file, b was new
+	a := resource.CreateResource(filepath.Join(dir, "a"))
+	b := resource.CreateResource(filepath.Join(dir, "b"))
+	prev := filepath.Join(dir, ".a.ironsync1")
+	for path, content := range map[string]string{a.Path: "new a", b.Path: "new b", prev: "old a"} {
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := restoreGroup([]*groupMember{{r: &a, prevPath: prev}, {r: &b}})
+	if err != nil {
+		t.Fatalf("restoreGroup() failed: %v", err)
+	}
+
+	content, err := ioutil.ReadFile(a.Path)
+	if err != nil || string(content) != "old a" {
+		t.Errorf("%s = %q, %v, want old a", a.Path, content, err)
+	}
+	if _, err := os.Stat(b.Path); !os.IsNotExist(err) {
+		t.Errorf("%s was not removed", b.Path)
+	}
+
+	// Every member is restored, failures are collected
+	if err := ioutil.WriteFile(a.Path, []byte("new a"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = restoreGroup([]*groupMember{{r: &a}, {r: &b}})
+	if err == nil || !strings.Contains(err.Error(), b.Path) {
+		t.Errorf("restoreGroup() error %v, want one naming %s", err, b.Path)
+	}
+	if _, err := os.Stat(a.Path); !os.IsNotExist(err) {
+		t.Errorf("%s was not removed", a.Path)
+	}
+}
+
+func TestRunGroupHooks(t *testing.T) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "log")
+	c := &connection.Connection{Name: "web"}
+
+	var members []*groupMember
+	for i, command := range []string{"echo reload >> " + log, "echo reload >> " + log, "", "echo restart >> " + log} {
+		r := resource.CreateResource(filepath.Join(dir, string(rune('a'+i))))
+		r.PostUpdateCommand = command
+		members = append(members, &groupMember{r: &r, data: hookData{Path: r.Path}})
+	}
+
+	err := runGroupHooks(context.Background(), c, members, "Post-update cmd", postUpdateHook)
+	if err != nil {
+		t.Fatalf("runGroupHooks() failed: %v", err)
+	}
+	content, _ := ioutil.ReadFile(log)
+	if string(content) != "reload\nrestart\n" {
+		t.Errorf("Commands ran as %q, want each once", content)
+	}
+
+	members[3].r.PostUpdateCommand = "exit 1"
+	err = runGroupHooks(context.Background(), c, members, "Post-update cmd", postUpdateHook)
+	if err == nil || !strings.Contains(err.Error(), members[3].r.Path) {
+		t.Errorf("runGroupHooks() error %v, want one naming %s", err, members[3].r.Path)
+	}
+}
+
+func TestSyncGroupMembers(t *testing.T) {
+	c := &connection.Connection{}
+	for _, group := range []string{"g", "", "g", "h"} {
+		r := resource.CreateResource("/tmp/" + group)
+		r.SyncGroup = group
+		c.Resources = append(c.Resources, &r)
+	}
+
+	if members := syncGroupMembers(c, "g"); len(members) != 2 || members[0] != c.Resources[0] || members[1] != c.Resources[2] {
+		t.Errorf("syncGroupMembers(g) = %v", members)
+	}
+	if members := syncGroupMembers(c, "x"); len(members) != 0 {
+		t.Errorf("syncGroupMembers(x) = %v", members)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"ironsync/connection"
+	"ironsync/resource"
+	"ironsync/utils"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// homeDir - Open the directory of a per-user resource below its home
+// directory, creating missing directories if create is set. Returns the
+// name of the resource in it.
+func homeDir(r *resource.Resource, create bool) (*utils.HomeDir, string, error) {
+	uid, gid, err := utils.LookupOwner(r.User, r.Group)
+	if err != nil {
+		return nil, "", err
+	}
+
+	rel, err := filepath.Rel(r.Home, r.Path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	d, err := utils.OpenHomeDir(r.Home, filepath.Dir(rel), uid, gid, r.DirPerms, create)
+	if err != nil {
+		return nil, "", err
+	}
+	return d, filepath.Base(rel), nil
+}
+
+// localHash - Hash of the installed file of r, "" if there is none. Links
+// below home directories are not followed.
+func localHash(r *resource.Resource) string {
+	if r.Home == "" {
+		hash, _ := utils.HashFile(r.Path)
+		return hash
+	}
+
+	d, name, err := homeDir(r, false)
+	if err != nil {
+		return ""
+	}
+	defer d.Close()
+	return d.Hash(name)
+}
+
+// installHomeFile - Install the staged file in path to a per-user resource:
+// validate it, then copy it into place relative to the opened home
+// directory and run the post-update command. Per-user resources are never
+// merged, backed up or health checked.
+func installHomeFile(ctx context.Context, c *connection.Connection, r *resource.Resource, path string, data *hookData) (bool, error) {
+	d, name, err := homeDir(r, true)
+	if err != nil {
+		return false, err
+	}
+	defer d.Close()
+
+	data.OldHash = d.Hash(name)
+	data.NewHash, _ = utils.HashFile(path)
+
+	// Avoid unnecessary overwrite if files are the same
+	if data.NewHash == data.OldHash {
+		_, err = d.Reconcile(name, r.Perms)
+		return false, err
+	}
+
+	perms := r.Perms
+	if perms == 0 {
+		perms = d.Mode(name)
+		if perms == 0 {
+			perms = os.FileMode(int(0664))
+		}
+	}
+
+	data.TmpPath = path
+
+	err = runHook(ctx, c, r, "Validate cmd", true, r.ValidateCommand, r.ValidateArgv, r.ValidateCommandTimeout, data)
+	if err != nil {
+		return false, fmt.Errorf("Validate cmd failed: %v", err)
+	}
+
+	err = d.Install(name, path, perms)
+	if err != nil {
+		return false, fmt.Errorf("Installing file failed: %v", err)
+	}
+
+	data.TmpPath = ""
+
+	err = runHook(ctx, c, r, "Post-update cmd", false, r.PostUpdateCommand, r.PostUpdateArgv, r.PostUpdateCommandTimeout, data)
+	if err != nil {
+		return false, fmt.Errorf("Post-update cmd failed: %v", err)
+	}
+	return true, nil
+}
+
+// reconcileHomeResource - Correct the mode and ownership of an installed
+// per-user file
+func reconcileHomeResource(r *resource.Resource) ([]string, error) {
+	d, name, err := homeDir(r, false)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+	return d.Reconcile(name, r.Perms)
+}
+
+// ensureHomeSymlink - Point a per-user link at r.Target
+func ensureHomeSymlink(r *resource.Resource) ([]string, error) {
+	d, name, err := homeDir(r, true)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	changed, err := d.Symlink(name, r.Target)
+	if err != nil || !changed {
+		return nil, err
+	}
+	return []string{"Linked to " + r.Target}, nil
+}
+
+// ensureHomeDirectory - Create a per-user directory owned by its user
+func ensureHomeDirectory(r *resource.Resource) ([]string, error) {
+	var changes []string
+
+	d, name, err := homeDir(r, true)
+	if err != nil {
+		return nil, err
+	}
+	defer d.Close()
+
+	perms := r.Perms
+	if perms == 0 {
+		perms = r.DirPerms
+	}
+
+	created, err := d.Mkdir(name, perms)
+	if created {
+		changes = append(changes, "Created directory")
+	}
+	if err != nil || created {
+		return changes, err
+	}
+
+	corrections, err := d.Reconcile(name, r.Perms)
+	if len(corrections) > 0 {
+		changes = append(changes, "Corrected "+strings.Join(corrections, ", "))
+	}
+	if err != nil {
+		return changes, fmt.Errorf("Correcting permissions failed: %v", err)
+	}
+	return changes, nil
+}
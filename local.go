@@ -0,0 +1,231 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"ironsync/connection"
+	"ironsync/permissions"
+	"ironsync/resource"
+	"ironsync/utils"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// processLocalResource - Make sure a symlink, directory or absent resource
+// is in place. Nothing is downloaded; the post-update command runs when
+// something changed.
+func processLocalResource(ctx context.Context, c *connection.Connection, r *resource.Resource) (bool, error) {
+	var changes []string
+	var err error
+
+	switch {
+	case r.Home != "" && r.Kind == resource.KindSymlink:
+		changes, err = ensureHomeSymlink(r)
+	case r.Home != "" && r.Kind == resource.KindDirectory:
+		changes, err = ensureHomeDirectory(r)
+	case r.Kind == resource.KindSymlink:
+		changes, err = ensureSymlink(r)
+	case r.Kind == resource.KindDirectory:
+		changes, err = ensureDirectory(r)
+	case r.Kind == resource.KindAbsent:
+		changes, err = ensureAbsent(r)
+	default:
+		return false, fmt.Errorf("Unknown kind %s", r.Kind)
+	}
+
+	if len(changes) > 0 {
+		log.Printf("[%s][%s] %s", c.Name, r.Path, strings.Join(changes, ", "))
+	}
+	if err != nil {
+		return false, err
+	}
+	if len(changes) == 0 {
+		return false, nil
+	}
+
+	data := hookData{Path: r.Path, Connection: c.Name}
+	err = runHook(ctx, c, r, "Post-update cmd", false, r.PostUpdateCommand, r.PostUpdateArgv, r.PostUpdateCommandTimeout, &data)
+	if err != nil {
+		return false, fmt.Errorf("Post-update cmd failed: %v", err)
+	}
+	return true, nil
+}
+
+// ensureSymlink - Point r.Path at r.Target. An existing link or file is
+// replaced atomically, a directory is never replaced.
+func ensureSymlink(r *resource.Resource) ([]string, error) {
+	fileInfo, err := os.Lstat(r.Path)
+	if err == nil {
+		if fileInfo.Mode()&os.ModeSymlink != 0 {
+			target, err := os.Readlink(r.Path)
+			if err == nil && target == r.Target {
+				return nil, setLinkOwner(r)
+			}
+		} else if fileInfo.IsDir() {
+			return nil, fmt.Errorf("Not replacing directory %s with a symbolic link", r.Path)
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	dir := filepath.Dir(r.Path)
+	err = utils.MkdirAll(dir, r.DirPerms)
+	if err != nil {
+		return nil, err
+	}
+
+	// Reserve a temp name, then put the link there
+	tmpFile, err := ioutil.TempFile(dir, "."+filepath.Base(r.Path)+".ironsync")
+	if err != nil {
+		return nil, err
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+	utils.TrackTempFile(tmpPath)
+	defer utils.RemoveTempFile(tmpPath)
+
+	os.Remove(tmpPath)
+	err = os.Symlink(r.Target, tmpPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = utils.ReplaceFile(tmpPath, r.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	return []string{"Linked to " + r.Target}, setLinkOwner(r)
+}
+
+// setLinkOwner - Set the owner of the link itself
+func setLinkOwner(r *resource.Resource) error {
+	if r.User == "" && r.Group == "" {
+		return nil
+	}
+
+	uid, gid, err := utils.LookupOwner(r.User, r.Group)
+	if err != nil {
+		return err
+	}
+	return os.Lchown(r.Path, uid, gid)
+}
+
+// ensureDirectory - Create r.Path with its attributes and, with Purge,
+// remove the entries that are not resources
+func ensureDirectory(r *resource.Resource) ([]string, error) {
+	var changes []string
+
+	fileInfo, err := os.Lstat(r.Path)
+	if os.IsNotExist(err) {
+		perms := r.Perms
+		if perms == 0 {
+			perms = r.DirPerms
+		}
+
+		err = utils.MkdirAll(r.Path, perms)
+		if err != nil {
+			return nil, err
+		}
+
+		err = permissions.SetFilePermissions(r.Path, r.Attributes(perms))
+		if err != nil {
+			return nil, fmt.Errorf("Setting file permissions failed: %v", err)
+		}
+		changes = append(changes, "Created directory")
+	} else if err != nil {
+		return nil, err
+	} else if !fileInfo.IsDir() {
+		return nil, fmt.Errorf("Not a directory: %s", r.Path)
+	} else {
+		corrections, err := permissions.Reconcile(r.Path, r.Attributes(r.Perms))
+		if len(corrections) > 0 {
+			changes = append(changes, "Corrected "+strings.Join(corrections, ", "))
+		}
+		if err != nil && err != permissions.ErrReconcileUnsupported {
+			return changes, fmt.Errorf("Correcting permissions failed: %v", err)
+		}
+	}
+
+	if r.Purge {
+		removed, err := purgeDirectory(r.Path, r.Managed)
+		for _, path := range removed {
+			changes = append(changes, "Removed unmanaged "+path)
+		}
+		if err != nil {
+			return changes, fmt.Errorf("Purging failed: %v", err)
+		}
+	}
+	return changes, nil
+}
+
+// purgeDirectory - Remove the entries of dir that are neither a managed path
+// nor lead to one. Temp files of updates in progress are kept.
+func purgeDirectory(dir string, managed []string) ([]string, error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var removed []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, ".") && strings.Contains(name, ".ironsync") {
+			continue
+		}
+
+		path := filepath.Join(dir, name)
+		keep, below := false, false
+		for _, m := range managed {
+			if m == path {
+				keep = true
+			} else if strings.HasPrefix(m, path+"/") {
+				below = true
+			}
+		}
+
+		switch {
+		case keep:
+		case below && entry.IsDir():
+			more, err := purgeDirectory(path, managed)
+			removed = append(removed, more...)
+			if err != nil {
+				return removed, err
+			}
+		default:
+			err = os.RemoveAll(path)
+			if err != nil {
+				return removed, err
+			}
+			removed = append(removed, path)
+		}
+	}
+	return removed, nil
+}
+
+// ensureAbsent - Remove r.Path. A directory must be empty unless
+// r.Recursive is set, then everything below it is removed too.
+func ensureAbsent(r *resource.Resource) ([]string, error) {
+	info, err := os.Lstat(r.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() && r.Recursive {
+		err = os.RemoveAll(r.Path)
+	} else {
+		err = os.Remove(r.Path)
+	}
+	if err != nil {
+		if info.IsDir() && !r.Recursive {
+			return nil, fmt.Errorf("Removing directory failed, set recursive to remove its contents: %v", err)
+		}
+		return nil, err
+	}
+	return []string{"Removed"}, nil
+}
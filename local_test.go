@@ -0,0 +1,156 @@
+package main
+
+import (
+	"io/ioutil"
+	"ironsync/resource"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestEnsureSymlink(t *testing.T) {
+	dir := t.TempDir()
+	r := resource.CreateResource(filepath.Join(dir, "sub", "link"))
+	r.Kind = resource.KindSymlink
+	r.Target = "target"
+
+	changes, err := ensureSymlink(&r)
+	if err != nil || len(changes) != 1 {
+		t.Fatalf("ensureSymlink() = %v, %v, want one change", changes, err)
+	}
+	if target, _ := os.Readlink(r.Path); target != "target" {
+		t.Errorf("Link points to %q, want target", target)
+	}
+
+	changes, err = ensureSymlink(&r)
+	if err != nil || len(changes) != 0 {
+		t.Errorf("ensureSymlink() again = %v, %v, want no changes", changes, err)
+	}
+
+	// A file in the way is replaced
+	os.Remove(r.Path)
+	if err := ioutil.WriteFile(r.Path, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ensureSymlink(&r); err != nil {
+		t.Fatalf("ensureSymlink() over a file failed: %v", err)
+	}
+	if target, _ := os.Readlink(r.Path); target != "target" {
+		t.Errorf("Link over a file points to %q, want target", target)
+	}
+
+	// A directory in the way is not
+	d := resource.CreateResource(filepath.Join(dir, "dir"))
+	d.Kind = resource.KindSymlink
+	d.Target = "target"
+	if err := os.Mkdir(d.Path, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ensureSymlink(&d); err == nil {
+		t.Errorf("ensureSymlink() over a directory succeeded")
+	}
+}
+
+func TestEnsureDirectory(t *testing.T) {
+	dir := t.TempDir()
+	r := resource.CreateResource(filepath.Join(dir, "d"))
+	r.Kind = resource.KindDirectory
+	r.Perms = 0750
+
+	changes, err := ensureDirectory(&r)
+	if err != nil || len(changes) != 1 {
+		t.Fatalf("ensureDirectory() = %v, %v, want one change", changes, err)
+	}
+	info, err := os.Stat(r.Path)
+	if err != nil || !info.IsDir() {
+		t.Fatalf("Directory not created: %v", err)
+	}
+
+	// Purge keeps managed paths and the directories leading to them
+	for _, p := range []string{"keep", "sub/keep", "sub/drop", "drop", ".f.ironsync123"} {
+		path := filepath.Join(r.Path, p)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r.Purge = true
+	r.Managed = []string{filepath.Join(r.Path, "keep"), filepath.Join(r.Path, "sub", "keep")}
+
+	if _, err := ensureDirectory(&r); err != nil {
+		t.Fatalf("ensureDirectory() with purge failed: %v", err)
+	}
+	for p, want := range map[string]bool{"keep": true, "sub/keep": true, "sub/drop": false, "drop": false, ".f.ironsync123": true} {
+		_, err := os.Lstat(filepath.Join(r.Path, p))
+		if (err == nil) != want {
+			t.Errorf("%s exists = %v, want %v", p, err == nil, want)
+		}
+	}
+
+	// Anything but a directory is an error
+	f := resource.CreateResource(filepath.Join(dir, "d", "keep"))
+	f.Kind = resource.KindDirectory
+	if _, err := ensureDirectory(&f); err == nil {
+		t.Errorf("ensureDirectory() on a file succeeded")
+	}
+}
+
+func TestEnsureAbsent(t *testing.T) {
+	dir := t.TempDir()
+
+	file := filepath.Join(dir, "file")
+	empty := filepath.Join(dir, "empty")
+	full := filepath.Join(dir, "full")
+	link := filepath.Join(dir, "link")
+	if err := ioutil.WriteFile(file, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Mkdir(empty, 0755)
+	os.Mkdir(full, 0755)
+	if err := ioutil.WriteFile(filepath.Join(full, "f"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(full, link); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		path      string
+		recursive bool
+		fail      bool // Expect an error and the path to be kept
+	}{
+		{"missing", filepath.Join(dir, "missing"), false, false},
+		{"file", file, false, false},
+		{"link to a directory", link, false, false},
+		{"empty directory", empty, false, false},
+		// Removing the link left full alone
+		{"directory with contents", full, false, true},
+		{"directory with contents, recursive", full, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := resource.CreateResource(tt.path)
+			r.Kind = resource.KindAbsent
+			r.Recursive = tt.recursive
+
+			_, err := ensureAbsent(&r)
+			if tt.fail {
+				if err == nil {
+					t.Errorf("ensureAbsent() succeeded")
+				}
+				if _, err := os.Stat(filepath.Join(tt.path, "f")); err != nil {
+					t.Errorf("Contents were removed: %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ensureAbsent() failed: %v", err)
+			}
+			if _, err := os.Lstat(tt.path); !os.IsNotExist(err) {
+				t.Errorf("%s still exists", tt.path)
+			}
+		})
+	}
+}
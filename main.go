@@ -1,26 +1,51 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"io/ioutil"
+	"ironsync/archive"
+	"ironsync/backup"
 	"ironsync/config"
 	"ironsync/connection"
+	"ironsync/drift"
+	"ironsync/merge"
+	"ironsync/notify"
 	"ironsync/permissions"
+	"ironsync/render"
 	"ironsync/resource"
 	"ironsync/utils"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 )
 
 // Command-line arguments
 var (
-	connFile = flag.String("connfile", "conn.ini", "Connection configuration file")
-	resFile  = flag.String("resfile", "res.ini", "Resource configuration file")
+	connFile  = flag.String("connfile", "conn.ini", "Connection configuration file")
+	resFile   = flag.String("resfile", "res.ini", "Resource configuration file")
+	confFile  = flag.String("config", "", "YAML, TOML or JSON configuration file (replaces -connfile and -resfile)")
+	confDir   = flag.String("confdir", "", "Drop-in directory of *.conn.ini, *.res.ini, YAML, TOML and JSON files")
+	grace     = flag.Int("grace", 30, "Shutdown grace period (seconds)")
+	backupDir = flag.String("backupdir", backup.DefaultDir, "Backup store directory")
+	cacheDir  = flag.String("cachedir", drift.DefaultDir, "Last known good files of resources with drift")
 )
 
+// sinkDrainTimeout - How long events queued at shutdown are sent for
+const sinkDrainTimeout = 10 * time.Second
+
+// driftWatcher - Watches resources with a drift policy (nil if there are none)
+var driftWatcher *drift.Watcher
+
+// notifier - Sends events to the configured sinks (nil if there are none)
+var notifier *notify.Notifier
+
 // Program information
 var (
 	// ProgName - Program name
@@ -29,33 +54,570 @@ var (
 	progVersion = "0.1.0"
 )
 
-// Global variables
-var (
-	doReload = false
-)
+// hookData - Fields available to validate and health command templates
+// (e.g. {{.TmpPath}}), also passed to every command as IRONSYNC_*
+// environment variables
+type hookData struct {
+	Path       string // Resource path
+	TmpPath    string // Staged file (validate_cmd only)
+	RemotePath string // Resource remote path
+	Connection string // Connection name
+	OldHash    string // SHA-256 of the file being replaced
+	NewHash    string // SHA-256 of the downloaded file
+
+	patchHash string // SHA-256 of the JSON patch being applied (not passed to commands)
+}
+
+func (d *hookData) env() []string {
+	return []string{
+		"IRONSYNC_PATH=" + d.Path,
+		"IRONSYNC_TMP_PATH=" + d.TmpPath,
+		"IRONSYNC_REMOTE_PATH=" + d.RemotePath,
+		"IRONSYNC_CONNECTION=" + d.Connection,
+		"IRONSYNC_OLD_HASH=" + d.OldHash,
+		"IRONSYNC_NEW_HASH=" + d.NewHash,
+	}
+}
+
+func (d *hookData) fields() map[string]string {
+	return map[string]string{
+		"Path":       d.Path,
+		"TmpPath":    d.TmpPath,
+		"RemotePath": d.RemotePath,
+		"Connection": d.Connection,
+		"OldHash":    d.OldHash,
+		"NewHash":    d.NewHash,
+	}
+}
+
+// recordPatch - Remember the JSON patch installed to r.Path, if there is one
+func recordPatch(c *connection.Connection, r *resource.Resource, data *hookData) {
+	if data.patchHash == "" {
+		return
+	}
+
+	err := merge.RecordPatch(r.Path, data.patchHash)
+	if err != nil {
+		log.Printf("[%s][%s] Recording JSON patch hash failed: %v", c.Name, r.Path, err)
+	}
+}
+
+// runHook - Run a resource command (shell or argv form), logging its output
+// if it fails. Templates are expanded only in templated commands, others run
+// as written (e.g. docker ps --format '{{.Names}}'). Does nothing if neither
+// form is set.
+func runHook(ctx context.Context, c *connection.Connection, r *resource.Resource, name string, templated bool, command string, argv []string, timeout int, data *hookData) error {
+	if command == "" && len(argv) == 0 {
+		return nil
+	}
 
-func processResource(c *connection.Connection, r *resource.Resource) (bool, error) {
-	if r.PreUpdateCommand != "" {
-		err := utils.RunCmd(r.PreUpdateCommand, r.PreUpdateCommandTimeout)
+	cmd := utils.Command{
+		Command: command,
+		Argv:    argv,
+		User:    r.HookUser,
+		Group:   r.HookGroup,
+		Dir:     r.HookDir,
+		Env:     data.env(),
+		Timeout: timeout,
+	}
+
+	if templated {
+		err := cmd.Expand(data.fields())
 		if err != nil {
-			return false, fmt.Errorf("Pre-update cmd failed: %v", err)
+			return err
 		}
 	}
 
-	modified, path, err := c.Download(r)
+	output, err := cmd.Run(ctx)
+	if err != nil && len(output) > 0 {
+		log.Printf("[%s][%s] %s output:\n%s", c.Name, r.Path, name, output)
+	}
+	return err
+}
+
+// restoreFile - Put back the file kept before an update, removing the
+// updated file if there was none
+func restoreFile(prevPath string, path string) error {
+	if prevPath == "" {
+		return os.Remove(path)
+	}
+	return utils.ReplaceFile(prevPath, path)
+}
+
+// extractResource - Unpack the downloaded archive in path into a staging
+// directory next to r.Path and swap it in
+func extractResource(ctx context.Context, c *connection.Connection, r *resource.Resource, path string, data *hookData) (bool, error) {
+	data.OldHash = archive.ReadHash(r.Path)
+	data.NewHash, _ = utils.HashFile(path)
+
+	// Avoid unnecessary extraction if the archive is the same
+	_, err := os.Stat(r.Path)
+	if err == nil && data.NewHash == data.OldHash {
+		return false, nil
+	}
+
+	stagePath, err := ioutil.TempDir(filepath.Dir(r.Path), "."+filepath.Base(r.Path)+".ironsync")
 	if err != nil {
-		return false, fmt.Errorf("Downloading resource failed: %v", err)
+		return false, fmt.Errorf("Creating staging directory failed: %v", err)
 	}
+	utils.TrackTempFile(stagePath)
+	defer utils.RemoveTempFile(stagePath)
 
-	defer os.Remove(path)
+	err = archive.Extract(path, stagePath, r.Extract, r.StripComponents)
+	if err != nil {
+		return false, fmt.Errorf("Extracting archive failed: %v", err)
+	}
+
+	err = os.Chmod(stagePath, r.DirPerms)
+	if err != nil {
+		return false, fmt.Errorf("Setting file permissions failed: %v", err)
+	}
+
+	err = permissions.SetTreePermissions(stagePath, r.User, r.Group, r.Perms)
+	if err != nil {
+		return false, fmt.Errorf("Setting file permissions failed: %v", err)
+	}
+
+	data.TmpPath = stagePath
+
+	err = runHook(ctx, c, r, "Validate cmd", true, r.ValidateCommand, r.ValidateArgv, r.ValidateCommandTimeout, data)
+	if err != nil {
+		return false, fmt.Errorf("Validate cmd failed: %v", err)
+	}
+
+	prevPath, err := utils.ReplaceDir(stagePath, r.Path)
+	if err != nil {
+		return false, fmt.Errorf("Moving directory failed %s: %v", stagePath, err)
+	}
+	if prevPath != "" {
+		defer utils.RemoveTempFile(prevPath)
+	}
+
+	data.TmpPath = ""
+
+	err = archive.WriteHash(r.Path, data.NewHash)
+	if err != nil {
+		log.Printf("[%s][%s] Recording archive hash failed: %v", c.Name, r.Path, err)
+	}
+
+	err = runHook(ctx, c, r, "Post-update cmd", false, r.PostUpdateCommand, r.PostUpdateArgv, r.PostUpdateCommandTimeout, data)
+	if err != nil {
+		return false, fmt.Errorf("Post-update cmd failed: %v", err)
+	}
+
+	if r.HealthCommand != "" || len(r.HealthArgv) > 0 {
+		err = runHook(ctx, c, r, "Health cmd", true, r.HealthCommand, r.HealthArgv, r.HealthCommandTimeout, data)
+		if err != nil {
+			log.Printf("[%s][%s] Health cmd failed, restoring previous directory: %v", c.Name, r.Path, err)
+			notifier.Emit(notify.EventRollback, c.Name, r.Path, fmt.Sprintf("Health cmd failed: %v", err))
+
+			restoreErr := restoreDir(prevPath, r.Path)
+			if restoreErr != nil {
+				return false, fmt.Errorf("Health cmd failed: %v, restoring previous directory failed: %v", err, restoreErr)
+			}
+			archive.WriteHash(r.Path, data.OldHash)
+
+			data.OldHash, data.NewHash = data.NewHash, data.OldHash
+
+			postErr := runHook(ctx, c, r, "Post-update cmd", false, r.PostUpdateCommand, r.PostUpdateArgv, r.PostUpdateCommandTimeout, data)
+			if postErr != nil {
+				return false, fmt.Errorf("Health cmd failed: %v, post-update cmd after restore failed: %v", err, postErr)
+			}
+
+			return false, fmt.Errorf("Health cmd failed, previous directory restored: %v", err)
+		}
+	}
+
+	return true, nil
+}
+
+// restoreDir - Put the directory kept by utils.ReplaceDir back in place
+func restoreDir(prevPath string, path string) error {
+	if prevPath == "" {
+		return os.RemoveAll(path)
+	}
+
+	failedPath, err := utils.ReplaceDir(prevPath, path)
+	if failedPath != "" {
+		utils.RemoveTempFile(failedPath)
+	}
+	return err
+}
+
+// concatSources - Append the sources of r to the downloaded file in path.
+// Any failing source fails the update, so the local file is kept.
+func concatSources(ctx context.Context, r *resource.Resource, path string) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	parts := [][]byte{content}
+
+	for i, source := range r.Sources {
+		sourceRes := *r
+		sourceRes.Path = fmt.Sprintf("%s.source%d", r.Path, i+1)
+		sourceRes.RemotePath = source.RemotePath
+		sourceRes.LastModifiedTime = time.Time{}
+		// decompress applies to the primary file only
+		sourceRes.Decompress = ""
+		if source.GistID != "" {
+			sourceRes.GistID = source.GistID
+			sourceRes.GitHubUsername = source.GitHubUsername
+		}
+
+		_, sourcePath, err := source.Connection.Download(ctx, &sourceRes)
+		if err != nil {
+			return fmt.Errorf("Downloading source %s:%s failed: %v", source.ConnectionName, source.RemotePath, err)
+		}
+
+		content, err := ioutil.ReadFile(sourcePath)
+		utils.RemoveTempFile(sourcePath)
+		if err != nil {
+			return fmt.Errorf("Reading source %s:%s failed: %v", source.ConnectionName, source.RemotePath, err)
+		}
+		parts = append(parts, content)
+	}
+
+	return ioutil.WriteFile(path, merge.Concat(parts, r.Header, r.Dedup), 0600)
+}
+
+// reconcileResource - Correct the mode, ownership and security attributes
+// of an installed file that were changed locally
+func reconcileResource(c *connection.Connection, r *resource.Resource) {
+	if r.Kind != resource.KindFile || r.Extract != "" || (r.Perms == 0 && r.User == "" && r.Group == "" && len(r.ACL) == 0 && len(r.Xattrs) == 0 && r.SELinuxContext == "") {
+		return
+	}
+
+	var corrections []string
+	var err error
+	if r.Home != "" {
+		corrections, err = reconcileHomeResource(r)
+	} else {
+		corrections, err = permissions.Reconcile(r.Path, r.Attributes(r.Perms))
+	}
+	if len(corrections) > 0 {
+		log.Printf("[%s][%s] Corrected %s", c.Name, r.Path, strings.Join(corrections, ", "))
+	}
+	if err != nil && !os.IsNotExist(err) && err != permissions.ErrReconcileUnsupported {
+		log.Printf("[%s][%s] Correcting permissions failed: %v", c.Name, r.Path, err)
+	}
+}
+
+// renderResource - Render the downloaded template in path, downloading the
+// template data file from the same connection first
+func renderResource(ctx context.Context, c *connection.Connection, r *resource.Resource, path string) error {
+	var data interface{}
+
+	if r.TemplateData != "" {
+		dataRes := *r
+		dataRes.Path = r.Path + ".data"
+		dataRes.RemotePath = r.TemplateData
+		dataRes.LastModifiedTime = time.Time{}
+		dataRes.Decompress = ""
+
+		_, dataPath, err := c.Download(ctx, &dataRes)
+		if err != nil {
+			return fmt.Errorf("Downloading data file %s failed: %v", r.TemplateData, err)
+		}
+		defer utils.RemoveTempFile(dataPath)
+
+		data, err = render.LoadData(dataPath, r.TemplateData)
+		if err != nil {
+			return err
+		}
+	}
+
+	return render.File(path, render.Data(r.Vars, data))
+}
+
+func processResource(ctx context.Context, c *connection.Connection, r *resource.Resource) (modified bool, err error) {
+	if r.Kind != resource.KindFile {
+		return processLocalResource(ctx, c, r)
+	}
+
+	// The download sets the remote modification time, which only counts once
+	// the file is installed. Otherwise a file that failed validation would
+	// not be downloaded again.
+	lastModifiedTime := r.LastModifiedTime
+	defer func() {
+		if err != nil {
+			r.LastModifiedTime = lastModifiedTime
+		}
+	}()
+
+	data := hookData{Path: r.Path, RemotePath: r.RemotePath, Connection: c.Name}
+	data.OldHash = localHash(r)
+
+	modified, path, err := downloadResource(ctx, c, r, &data)
+	if err != nil {
+		return false, err
+	}
+
+	defer utils.RemoveTempFile(path)
 
 	if !modified {
 		return false, nil
 	}
 
+	if r.Extract != "" {
+		return extractResource(ctx, c, r, path, &data)
+	}
+
+	if len(r.Copies) == 0 {
+		return installFile(ctx, c, r, path, &data)
+	}
+	return installCopies(ctx, c, r, path, &data)
+}
+
+// downloadResource - Run the pre-update command, then download the remote
+// file to a temp file, append the sources and render the template. The temp
+// file is removed on errors.
+func downloadResource(ctx context.Context, c *connection.Connection, r *resource.Resource, data *hookData) (bool, string, error) {
+	err := runHook(ctx, c, r, "Pre-update cmd", false, r.PreUpdateCommand, r.PreUpdateArgv, r.PreUpdateCommandTimeout, data)
+	if err != nil {
+		return false, "", fmt.Errorf("Pre-update cmd failed: %v", err)
+	}
+
+	// The data file and sources may change while the remote file does not,
+	// merged keys are enforced again after local edits and sync groups
+	// compare every member
+	if r.TemplateData != "" || r.Mode != resource.ModeReplace || len(r.Sources) > 0 || r.SyncGroup != "" {
+		r.LastModifiedTime = time.Time{}
+	}
+
+	// Missing copies are installed again even if the remote file is unchanged
+	for _, dest := range r.Copies {
+		_, err := os.Stat(dest.Path)
+		if err != nil || dest.Mode != resource.ModeReplace {
+			r.LastModifiedTime = time.Time{}
+		}
+	}
+
+	// Files already current on the first sync are downloaded once, so the
+	// drift watcher gets a last known good file to compare with
+	for _, dest := range append([]*resource.Resource{r}, r.Copies...) {
+		if dest.Drift != "" && !driftWatcher.Cached(dest.Path) {
+			r.LastModifiedTime = time.Time{}
+		}
+	}
+
+	modified, path, err := c.Download(ctx, r)
+	if err != nil {
+		return false, "", fmt.Errorf("Downloading resource failed: %v", err)
+	}
+
+	if !modified {
+		return false, path, nil
+	}
+
+	if len(r.Sources) > 0 {
+		err = concatSources(ctx, r, path)
+		if err != nil {
+			utils.RemoveTempFile(path)
+			return false, "", err
+		}
+	}
+
+	if r.Template != "" {
+		err = renderResource(ctx, c, r, path)
+		if err != nil {
+			utils.RemoveTempFile(path)
+			return false, "", fmt.Errorf("Rendering template failed: %v", err)
+		}
+	}
+
+	return true, path, nil
+}
+
+// installCopies - Install the staged file in path to the resource and each
+// of its copies. A failing destination does not stop the others.
+func installCopies(ctx context.Context, c *connection.Connection, r *resource.Resource, path string, data *hookData) (bool, error) {
+	var failed []string
+
+	// Stage the copies first, installing the resource moves path away
+	copyPaths := map[*resource.Resource]string{}
+	for _, dest := range r.Copies {
+		copyPath, err := stageCopy(path, dest)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", dest.Path, err))
+			continue
+		}
+		defer utils.RemoveTempFile(copyPath)
+		copyPaths[dest] = copyPath
+	}
+
+	modified, err := installFile(ctx, c, r, path, data)
+	if err != nil {
+		failed = append(failed, fmt.Sprintf("%s: %v", r.Path, err))
+	}
+
+	for _, dest := range r.Copies {
+		copyPath, ok := copyPaths[dest]
+		if !ok {
+			continue
+		}
+
+		destData := hookData{Path: dest.Path, RemotePath: r.RemotePath, Connection: c.Name}
+		destData.OldHash = localHash(dest)
+
+		changed, err := installFile(ctx, c, dest, copyPath, &destData)
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", dest.Path, err))
+			continue
+		}
+		if changed {
+			log.Printf("[%s][%s] Copy successfully updated", c.Name, dest.Path)
+			notifier.Emit(notify.EventUpdated, c.Name, dest.Path, "")
+			dest.SetLastUpdateTime()
+		}
+		modified = modified || changed
+	}
+
+	if len(failed) > 0 {
+		return modified, fmt.Errorf("Installing failed: %s", strings.Join(failed, "; "))
+	}
+	return modified, nil
+}
+
+// stageCopy - Copy the staged file to a temp file next to the destination.
+// Per-user copies are staged in the temp directory, installHomeFile copies
+// them into place.
+func stageCopy(path string, dest *resource.Resource) (string, error) {
+	dir := ""
+	if dest.Home == "" {
+		dir = filepath.Dir(dest.Path)
+
+		err := utils.MkdirAll(dir, dest.DirPerms)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	tmpFile, err := ioutil.TempFile(dir, "."+filepath.Base(dest.Path)+".ironsync")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := tmpFile.Name()
+	tmpFile.Close()
+	utils.TrackTempFile(tmpPath)
+
+	err = utils.CopyFile(path, tmpPath, 0600)
+	if err != nil {
+		utils.RemoveTempFile(tmpPath)
+		return "", err
+	}
+	return tmpPath, nil
+}
+
+// installFile - Install the staged file in path to r.Path: merge it into the
+// local file if the mode says so, then set permissions, validate, back up,
+// replace and run the post-update and health commands
+func installFile(ctx context.Context, c *connection.Connection, r *resource.Resource, path string, data *hookData) (bool, error) {
+	if r.Home != "" {
+		return installHomeFile(ctx, c, r, path, data)
+	}
+
+	changed, err := stageFile(ctx, c, r, path, data)
+	if err != nil || !changed {
+		return false, err
+	}
+
+	err = backupFile(c, r)
+	if err != nil {
+		return false, err
+	}
+
+	// Keep the previous file around until the update is known to be healthy
+	prevPath := ""
+	if r.HealthCommand != "" || len(r.HealthArgv) > 0 {
+		prevPath, err = utils.KeepFile(r.Path)
+		if err != nil {
+			return false, fmt.Errorf("Keeping previous file failed: %v", err)
+		}
+		if prevPath != "" {
+			defer utils.RemoveTempFile(prevPath)
+		}
+	}
+
+	// Whatever is in place when processing ends is the last known good file
+	driftWatcher.Pause(r.Path)
+	defer driftWatcher.Update(r.Path)
+
+	err = utils.ReplaceFile(path, r.Path)
+	if err != nil {
+		return false, fmt.Errorf("Moving file failed %s: %v", path, err)
+	}
+
+	data.TmpPath = ""
+
+	err = runHook(ctx, c, r, "Post-update cmd", false, r.PostUpdateCommand, r.PostUpdateArgv, r.PostUpdateCommandTimeout, data)
+	if err != nil {
+		return false, fmt.Errorf("Post-update cmd failed: %v", err)
+	}
+
+	if r.HealthCommand != "" || len(r.HealthArgv) > 0 {
+		err = runHook(ctx, c, r, "Health cmd", true, r.HealthCommand, r.HealthArgv, r.HealthCommandTimeout, data)
+		if err != nil {
+			log.Printf("[%s][%s] Health cmd failed, restoring previous file: %v", c.Name, r.Path, err)
+			notifier.Emit(notify.EventRollback, c.Name, r.Path, fmt.Sprintf("Health cmd failed: %v", err))
+
+			restoreErr := restoreFile(prevPath, r.Path)
+			if restoreErr != nil {
+				return false, fmt.Errorf("Health cmd failed: %v, restoring previous file failed: %v", err, restoreErr)
+			}
+
+			data.OldHash, data.NewHash = data.NewHash, data.OldHash
+
+			postErr := runHook(ctx, c, r, "Post-update cmd", false, r.PostUpdateCommand, r.PostUpdateArgv, r.PostUpdateCommandTimeout, data)
+			if postErr != nil {
+				return false, fmt.Errorf("Health cmd failed: %v, post-update cmd after restore failed: %v", err, postErr)
+			}
+
+			return false, fmt.Errorf("Health cmd failed, previous file restored: %v", err)
+		}
+	}
+
+	recordPatch(c, r, data)
+	return true, nil
+}
+
+// stageFile - Prepare the staged file in path for installing to r.Path:
+// merge it into the local file if the mode says so, set permissions and
+// validate it. Returns false if it is the same as the local file.
+func stageFile(ctx context.Context, c *connection.Connection, r *resource.Resource, path string, data *hookData) (bool, error) {
+	var err error
+
+	if r.Mode == resource.ModeMerge && r.MergeStrategy == merge.StrategyJSONPatch {
+		var applied bool
+		data.patchHash, applied = merge.PatchApplied(r.Path, path)
+		if applied {
+			return false, nil
+		}
+	}
+
+	if r.Mode != resource.ModeReplace {
+		var merged []byte
+		if r.Mode == resource.ModeBlock {
+			merged, err = merge.Block(r.Path, path, r.BlockMarker)
+		} else {
+			merged, err = merge.File(r.Path, path, r.MergeFormat, r.MergeStrategy)
+		}
+		if err != nil {
+			return false, fmt.Errorf("Merging failed: %v", err)
+		}
+
+		err = ioutil.WriteFile(path, merged, 0600)
+		if err != nil {
+			return false, fmt.Errorf("Merging failed: %v", err)
+		}
+	}
+
 	// Avoid unnecessary overwrite if files are the same
 	equal := utils.DeepCompare(path, r.Path)
 	if equal {
+		// Local mode and owner changes are drift, not the new good state
+		driftWatcher.Adopt(r.Path)
+		recordPatch(c, r, data)
 		return false, nil
 	}
 
@@ -69,41 +631,108 @@ func processResource(c *connection.Connection, r *resource.Resource) (bool, erro
 		}
 	}
 
-	err = permissions.SetFilePermissions(path, r.User, r.Group, perms)
+	err = permissions.SetFilePermissions(path, r.Attributes(perms))
 	if err != nil {
 		return false, fmt.Errorf("Setting file permissions failed: %v", err)
 	}
 
-	err = os.Rename(path, r.Path)
+	data.TmpPath = path
+	data.NewHash, _ = utils.HashFile(path)
+
+	err = runHook(ctx, c, r, "Validate cmd", true, r.ValidateCommand, r.ValidateArgv, r.ValidateCommandTimeout, data)
 	if err != nil {
-		return false, fmt.Errorf("Moving file failed %s: %v", path, err)
+		return false, fmt.Errorf("Validate cmd failed: %v", err)
 	}
 
-	if r.PostUpdateCommand != "" {
-		err := utils.RunCmd(r.PostUpdateCommand, r.PostUpdateCommandTimeout)
-		if err != nil {
-			return false, fmt.Errorf("Post-update cmd failed: %v", err)
+	return true, nil
+}
+
+// backupFile - Back up the local file before it is replaced, if backups
+// are enabled
+func backupFile(c *connection.Connection, r *resource.Resource) error {
+	if !r.BackupEnabled() {
+		return nil
+	}
+
+	store := backup.CreateStore(*backupDir)
+
+	err := store.Save(r.Path, r.BackupCompress)
+	if err != nil {
+		return fmt.Errorf("Backup failed: %v", err)
+	}
+
+	err = store.Prune(r.Path, r.BackupCount, time.Duration(r.BackupMaxAge)*time.Second)
+	if err != nil {
+		log.Printf("[%s][%s] Pruning backups failed: %v", c.Name, r.Path, err)
+	}
+	return nil
+}
+
+// reportOutcome - Emit the events of an update of r: updated, and failed or
+// recovered when it starts or stops failing
+func reportOutcome(c *connection.Connection, r *resource.Resource, modified bool, err error) {
+	if err != nil {
+		if !r.Failing {
+			notifier.Emit(notify.EventFailed, c.Name, r.Path, err.Error())
 		}
+		r.Failing = true
+		return
 	}
 
-	return true, nil
+	if r.Failing {
+		notifier.Emit(notify.EventRecovered, c.Name, r.Path, "")
+		r.Failing = false
+	}
+	if modified {
+		notifier.Emit(notify.EventUpdated, c.Name, r.Path, "")
+	}
 }
 
-func connectionWorker(c *connection.Connection) {
+// connectionWorker - Update resources of a connection until stop is
+// cancelled. In-flight work is aborted when abort is cancelled. A message on
+// reload resolves secrets again and forces all resources to update.
+func connectionWorker(stop context.Context, abort context.Context, c *connection.Connection, reload <-chan struct{}, wg *sync.WaitGroup) {
+	defer wg.Done()
+
 	log.Printf("[%s] Connected started", c.Name)
 
+	force := false
+
 	for {
+		groups := map[string]bool{}
+
 		for _, r := range c.Resources {
-			if doReload || time.Now().After(r.NextUpdateTime) {
-				if doReload {
+			if stop.Err() != nil {
+				break
+			}
+
+			// Sync groups are updated as a whole, at their first member
+			if r.SyncGroup != "" {
+				if !groups[r.SyncGroup] {
+					groups[r.SyncGroup] = true
+					updateGroup(abort, c, r.SyncGroup, force)
+				}
+				continue
+			}
+
+			if force || time.Now().After(r.NextUpdateTime) {
+				if dependencies.wait(c, r, nil) {
+					// Stay due until the dependencies are updated
+					r.NextUpdateTime = time.Time{}
+					continue
+				}
+
+				if force {
 					log.Printf("[%s][%s] Force updating resource", c.Name, r.Path)
 				} else {
 					log.Printf("[%s][%s] Updating resource", c.Name, r.Path)
 				}
 
-				doReload = false
-
-				modified, err := processResource(c, r)
+				modified, err := processResource(abort, c, r)
+				reconcileResource(c, r)
+				for _, dest := range r.Copies {
+					reconcileResource(c, dest)
+				}
 				if err != nil {
 					log.Printf("[%s][%s] Resource failed to update: %v", c.Name, r.Path, err)
 					r.SetNextUpdateTime(r.RetryInterval)
@@ -116,34 +745,234 @@ func connectionWorker(c *connection.Connection) {
 					}
 					r.SetNextUpdateTime(r.Interval)
 				}
+				dependencies.done(r, err == nil)
+				reportOutcome(c, r, modified, err)
+			}
+		}
+
+		force = false
+
+		select {
+		case <-stop.Done():
+			log.Printf("[%s] Connection stopped", c.Name)
+			return
+		case <-reload:
+			err := c.ResolveSecrets()
+			if err != nil {
+				log.Printf("[%s] Resolving secrets failed: %v", c.Name, err)
+			}
+			force = true
+		case <-time.After(1000 * time.Millisecond):
+		}
+	}
+}
+
+// configFiles - The structured config file if given, otherwise the INI file
+// pair, followed by the drop-in directory
+func configFiles() config.Files {
+	files := config.Files{Dir: *confDir}
+	if *confFile != "" {
+		files.File = *confFile
+	} else {
+		files.ConnFile = *connFile
+		files.ResFile = *resFile
+	}
+	return files
+}
+
+// waitTimeout - Wait for the group to finish, returns false on timeout
+func waitTimeout(wg *sync.WaitGroup, timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// shutdown - Stop all workers, giving in-flight work the grace period to
+// finish before it is aborted, then send the queued events and release
+// connections and temp files.
+func shutdown(wg *sync.WaitGroup, stop context.CancelFunc, abort context.CancelFunc, stopSinks context.CancelFunc, connections []*connection.Connection) {
+	log.Printf("Shutting down (grace period %d sec)", *grace)
+
+	stop()
+
+	stopped := waitTimeout(wg, time.Duration(*grace)*time.Second)
+	if !stopped {
+		log.Printf("Grace period expired, aborting in-flight work")
+		abort()
+		// Closing the clients unblocks transfers that are stuck on the network
+		for _, c := range connections {
+			c.Interrupt()
+		}
+		stopped = waitTimeout(wg, 5*time.Second)
+		if !stopped {
+			log.Printf("Workers did not stop, exiting anyway")
+		}
+	}
+	abort()
+
+	stopSinks()
+	notifier.Wait()
+
+	// Workers that did not stop may still be using the clients
+	if stopped {
+		for _, c := range connections {
+			c.Close()
+		}
+	}
+
+	utils.RemoveTempFiles()
+}
+
+// watchResources - Start watching the resources that have a drift policy
+func watchResources(connections []*connection.Connection, stop <-chan struct{}) error {
+	// Complete before watching starts, restores look up their resource
+	watched := map[string]*resource.Resource{}
+	connectionOf := map[string]*connection.Connection{}
+	var paths []string
+	for _, c := range connections {
+		var resources []*resource.Resource
+		for _, r := range c.Resources {
+			resources = append(append(resources, r), r.Copies...)
+		}
+
+		for _, r := range resources {
+			if r.Drift == "" {
+				continue
 			}
+			watched[r.Path] = r
+			connectionOf[r.Path] = c
+			paths = append(paths, r.Path)
 		}
-		time.Sleep(1000 * time.Millisecond)
 	}
+
+	for _, path := range paths {
+		r := watched[path]
+
+		if driftWatcher == nil {
+			w, err := drift.CreateWatcher(*cacheDir)
+			if err != nil {
+				return err
+			}
+			driftWatcher = w
+			driftWatcher.OnDrift = func(connection, path, message string) {
+				notifier.Emit(notify.EventDrift, connection, path, message)
+			}
+			driftWatcher.OnRestore = func(connection, path, target string) error {
+				return restoreAttributes(watched[path], target)
+			}
+			go driftWatcher.Run(stop)
+		}
+
+		err := utils.MkdirAll(filepath.Dir(r.Path), r.DirPerms)
+		if err != nil {
+			return err
+		}
+
+		err = driftWatcher.Watch(connectionOf[path].Name, r.Path, r.Drift)
+		if err != nil {
+			return fmt.Errorf("%s: %v", r.Path, err)
+		}
+	}
+	return nil
+}
+
+// restoreAttributes - Apply the ACL, extended attributes and SELinux
+// context of r to target, a file restored by the drift watcher that already
+// has the last known good mode and owner
+func restoreAttributes(r *resource.Resource, target string) error {
+	if r == nil || (len(r.ACL) == 0 && len(r.Xattrs) == 0 && r.SELinuxContext == "") {
+		return nil
+	}
+
+	fileInfo, err := os.Stat(target)
+	if err != nil {
+		return err
+	}
+	return permissions.SetFilePermissions(target, r.Attributes(fileInfo.Mode().Perm()))
 }
 
 func main() {
+	flag.Usage = usage
 	flag.Parse()
 
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args()))
+	}
+
 	log.Printf("%s (version %s)", progName, progVersion)
 
-	connections, err := config.Parse(*connFile, *resFile)
+	connections, err := config.Load(configFiles())
 	if err != nil {
 		log.Fatalf("Failed to parse config: %v", err)
 	} else if len(connections) == 0 {
-		log.Fatalf("%s: No connections defined", *connFile)
+		log.Fatalf("No connections defined")
+	}
+
+	stop, stopCancel := context.WithCancel(context.Background())
+	abort, abortCancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+
+	sinks, err := config.LoadSinks(configFiles())
+	if err != nil {
+		log.Fatalf("Failed to parse config: %v", err)
+	}
+	// Sinks outlive the workers, so the events of in-flight work are sent
+	sinkCtx, stopSinks := context.WithCancel(context.Background())
+	if len(sinks) > 0 {
+		notifier = notify.CreateNotifier(sinks)
+		notifier.Run(sinkCtx, sinkDrainTimeout)
 	}
 
+	err = watchResources(connections, stop.Done())
+	if err != nil {
+		log.Fatalf("Failed to watch resources: %v", err)
+	}
+
+	var reloads []chan struct{}
+
 	for _, c := range connections {
 		if len(c.Resources) > 0 {
-			go connectionWorker(c)
+			reload := make(chan struct{}, 1)
+			reloads = append(reloads, reload)
+
+			wg.Add(1)
+			go connectionWorker(stop, abort, c, reload, &wg)
 		}
 	}
 
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, syscall.SIGHUP)
+	signal.Notify(c, syscall.SIGHUP, syscall.SIGTERM, syscall.SIGINT)
+
+	for sig := range c {
+		if sig == syscall.SIGHUP {
+			err := notifier.ResolveSecrets()
+			if err != nil {
+				log.Printf("Resolving secrets failed: %v", err)
+			}
+			for _, reload := range reloads {
+				// A reload that is already pending covers this one
+				select {
+				case reload <- struct{}{}:
+				default:
+				}
+			}
+			continue
+		}
 
-	for _ = range c {
-		doReload = true
+		log.Printf("Received %v", sig)
+		signal.Stop(c)
+		shutdown(&wg, stopCancel, abortCancel, stopSinks, connections)
+		log.Printf("Stopped")
+		return
 	}
 }
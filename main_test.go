@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"ironsync/connection"
+	"ironsync/drift"
+	"ironsync/resource"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWaitTimeout(t *testing.T) {
+	var wg sync.WaitGroup
+	if !waitTimeout(&wg, time.Second) {
+		t.Errorf("waitTimeout() without workers = false")
+	}
+
+	wg.Add(1)
+	if waitTimeout(&wg, 50*time.Millisecond) {
+		t.Errorf("waitTimeout() with a running worker = true")
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		wg.Done()
+	}()
+	if !waitTimeout(&wg, 5*time.Second) {
+		t.Errorf("waitTimeout() with a worker that stops = false")
+	}
+}
+
+func TestProcessResourceCachesCurrentFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.conf")
+	if err := ioutil.WriteFile(path, []byte("current"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The remote file is older than the local one, as after a previous run
+	remoteTime := fileInfo.ModTime().Add(-time.Hour)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		http.ServeContent(w, req, "app.conf", remoteTime, strings.NewReader("current"))
+	}))
+	defer server.Close()
+
+	c := connection.CreateHTTPConnection("web", server.URL)
+	r := resource.CreateResource(path)
+	r.RemotePath = "app.conf"
+	r.Drift = drift.PolicyRestore
+	r.LastModifiedTime = fileInfo.ModTime()
+	c.Resources = append(c.Resources, &r)
+
+	w, err := drift.CreateWatcher(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Watch(c.Name, path, r.Drift); err != nil {
+		t.Fatal(err)
+	}
+	driftWatcher = w
+	defer func() { driftWatcher = nil }()
+
+	modified, err := processResource(context.Background(), &c, &r)
+	if err != nil || modified {
+		t.Fatalf("processResource() = %v, %v, want unmodified", modified, err)
+	}
+	if !w.Cached(path) {
+		t.Errorf("Current file was not cached for drift detection")
+	}
+}
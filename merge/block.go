@@ -0,0 +1,67 @@
+package merge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+const (
+	// DefaultMarker - Marker lines around a managed block, {mark} is BEGIN
+	// or END
+	DefaultMarker = "# {mark} IRONSYNC MANAGED BLOCK"
+)
+
+// Block - Replace the lines between the begin and end markers in the local
+// file path (which may be missing) with the content of blockPath and return
+// the result. Without markers the block is added at the end of the file.
+func Block(path string, blockPath string, marker string) ([]byte, error) {
+	local, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	block, err := ioutil.ReadFile(blockPath)
+	if err != nil {
+		return nil, err
+	}
+
+	begin := strings.Replace(marker, "{mark}", "BEGIN", -1)
+	end := strings.Replace(marker, "{mark}", "END", -1)
+
+	var lines []string
+	if len(local) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(local), "\n"), "\n")
+	}
+
+	beginAt, endAt := -1, -1
+	for i, line := range lines {
+		line = strings.TrimRight(line, " \t\r")
+		if beginAt == -1 && line == begin {
+			beginAt = i
+		} else if beginAt != -1 && line == end {
+			endAt = i
+			break
+		}
+	}
+	if beginAt != -1 && endAt == -1 {
+		return nil, fmt.Errorf("Local file has %q without %q", begin, end)
+	}
+
+	managed := []string{begin}
+	if len(block) > 0 {
+		managed = append(managed, strings.Split(strings.TrimSuffix(string(block), "\n"), "\n")...)
+	}
+	managed = append(managed, end)
+
+	var result []string
+	if beginAt == -1 {
+		result = append(lines, managed...)
+	} else {
+		result = append(result, lines[:beginAt]...)
+		result = append(result, managed...)
+		result = append(result, lines[endAt+1:]...)
+	}
+	return []byte(strings.Join(result, "\n") + "\n"), nil
+}
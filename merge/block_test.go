@@ -0,0 +1,91 @@
+package merge
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlock(t *testing.T) {
+	tests := []struct {
+		name    string
+		local   string // "" for a missing file
+		block   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "missing file",
+			block: "a\nb\n",
+			want:  "# BEGIN IRONSYNC MANAGED BLOCK\na\nb\n# END IRONSYNC MANAGED BLOCK\n",
+		},
+		{
+			name:  "appended without markers",
+			local: "keep\n",
+			block: "a",
+			want:  "keep\n# BEGIN IRONSYNC MANAGED BLOCK\na\n# END IRONSYNC MANAGED BLOCK\n",
+		},
+		{
+			name:  "replaced between markers",
+			local: "before\n# BEGIN IRONSYNC MANAGED BLOCK\nold\nolder\n# END IRONSYNC MANAGED BLOCK  \nafter\n",
+			block: "new\n",
+			want:  "before\n# BEGIN IRONSYNC MANAGED BLOCK\nnew\n# END IRONSYNC MANAGED BLOCK\nafter\n",
+		},
+		{
+			name:  "empty block",
+			local: "before\n# BEGIN IRONSYNC MANAGED BLOCK\nold\n# END IRONSYNC MANAGED BLOCK\n",
+			want:  "before\n# BEGIN IRONSYNC MANAGED BLOCK\n# END IRONSYNC MANAGED BLOCK\n",
+		},
+		{
+			name:    "begin without end",
+			local:   "# BEGIN IRONSYNC MANAGED BLOCK\nold\n",
+			block:   "new\n",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			path := filepath.Join(dir, "local")
+			blockPath := filepath.Join(dir, "block")
+			if tt.local != "" {
+				if err := ioutil.WriteFile(path, []byte(tt.local), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := ioutil.WriteFile(blockPath, []byte(tt.block), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := Block(path, blockPath, DefaultMarker)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Block() error = %v, want error %v", err, tt.wantErr)
+			}
+			if err == nil && string(got) != tt.want {
+				t.Errorf("Block() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBlockMarker(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "local")
+	blockPath := filepath.Join(dir, "block")
+	if err := ioutil.WriteFile(path, []byte("// >>> app\nold\n// <<< app\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(blockPath, []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Only lines of the configured marker count, so the block is added
+	got, err := Block(path, blockPath, "// {mark} app")
+	if err != nil {
+		t.Fatalf("Block() failed: %v", err)
+	}
+	if want := "// >>> app\nold\n// <<< app\n// BEGIN app\nnew\n// END app\n"; string(got) != want {
+		t.Errorf("Block() = %q, want %q", got, want)
+	}
+}
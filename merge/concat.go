@@ -0,0 +1,45 @@
+package merge
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Concat - Join the parts in order, each ending with a newline, after an
+// optional header line. With dedup repeated lines are dropped, keeping the
+// first; empty lines are always kept.
+func Concat(parts [][]byte, header string, dedup bool) []byte {
+	var b bytes.Buffer
+	seen := map[string]bool{}
+
+	if header != "" {
+		b.WriteString(strings.TrimSuffix(header, "\n"))
+		b.WriteString("\n")
+	}
+
+	for _, part := range parts {
+		if len(part) == 0 {
+			continue
+		}
+		if !bytes.HasSuffix(part, []byte("\n")) {
+			part = append(part, '\n')
+		}
+
+		if !dedup {
+			b.Write(part)
+			continue
+		}
+
+		for _, line := range strings.SplitAfter(string(part), "\n") {
+			key := strings.TrimRight(line, " \t\r\n")
+			if key != "" {
+				if seen[key] {
+					continue
+				}
+				seen[key] = true
+			}
+			b.WriteString(line)
+		}
+	}
+	return b.Bytes()
+}
@@ -0,0 +1,53 @@
+package merge
+
+import "testing"
+
+func TestConcat(t *testing.T) {
+	tests := []struct {
+		name   string
+		parts  []string
+		header string
+		dedup  bool
+		want   string
+	}{
+		{
+			name:  "newlines added",
+			parts: []string{"a", "b\n", "", "c"},
+			want:  "a\nb\nc\n",
+		},
+		{
+			name:   "header",
+			parts:  []string{"a\n"},
+			header: "# Managed by ironsync\n",
+			want:   "# Managed by ironsync\na\n",
+		},
+		{
+			name:  "duplicates kept",
+			parts: []string{"a\nb\n", "b\n"},
+			want:  "a\nb\nb\n",
+		},
+		{
+			name:  "dedup keeps the first line and empty lines",
+			parts: []string{"a\n\nb  \n", "b\n\nc\na"},
+			dedup: true,
+			want:  "a\n\nb  \n\nc\n",
+		},
+		{
+			name:   "nothing but the header",
+			header: "# header",
+			want:   "# header\n",
+		},
+	}
+
+	for _, tt := range tests {
+		var parts [][]byte
+		for _, p := range tt.parts {
+			parts = append(parts, []byte(p))
+		}
+
+		got := Concat(parts, tt.header, tt.dedup)
+		if string(got) != tt.want {
+			t.Errorf("%s: Concat() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
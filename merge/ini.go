@@ -0,0 +1,111 @@
+package merge
+
+import (
+	"fmt"
+	"strings"
+)
+
+// iniKey - A key line of an INI file
+type iniKey struct {
+	section string
+	key     string
+	value   string
+}
+
+// iniLine - Section and key of an INI line ("" key for anything but a key
+// line, "" section before the first section header)
+func iniLine(line string, section string) (string, string, string, bool) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || strings.HasPrefix(trimmed, ";") || strings.HasPrefix(trimmed, "#") {
+		return section, "", "", true
+	}
+
+	if strings.HasPrefix(trimmed, "[") {
+		if !strings.HasSuffix(trimmed, "]") {
+			return section, "", "", false
+		}
+		return strings.TrimSpace(trimmed[1 : len(trimmed)-1]), "", "", true
+	}
+
+	sep := strings.IndexAny(trimmed, "=:")
+	if sep <= 0 {
+		return section, "", "", false
+	}
+	return section, strings.TrimSpace(trimmed[:sep]), strings.TrimSpace(trimmed[sep+1:]), true
+}
+
+// mergeINI - Set the keys of the fragment in the local file. Lines of the
+// local file, including comments, keep their order; new keys are added at the
+// end of their section and new sections at the end of the file.
+func mergeINI(local []byte, fragment []byte) ([]byte, error) {
+	var keys []iniKey
+	section := ""
+	for i, line := range strings.Split(string(fragment), "\n") {
+		var key, value string
+		var ok bool
+		section, key, value, ok = iniLine(line, section)
+		if !ok {
+			return nil, fmt.Errorf("Remote fragment: line %d: invalid INI line %q", i+1, strings.TrimSpace(line))
+		}
+		if key != "" {
+			keys = append(keys, iniKey{section, key, value})
+		}
+	}
+
+	var lines []string
+	if len(local) > 0 {
+		lines = strings.Split(strings.TrimSuffix(string(local), "\n"), "\n")
+	}
+
+	for _, k := range keys {
+		lines = setINIKey(lines, k)
+	}
+	return []byte(strings.Join(lines, "\n") + "\n"), nil
+}
+
+// setINIKey - Replace the key's line in its section or add it
+func setINIKey(lines []string, k iniKey) []string {
+	newLine := k.key + " = " + k.value
+
+	section := ""
+	found := k.section == ""
+	// Where a new key goes: after the last key or header of the section
+	insertAt := -1
+	if found {
+		insertAt = 0
+	}
+
+	for i, line := range lines {
+		lineSection, key, _, _ := iniLine(line, section)
+		if lineSection != section || strings.HasPrefix(strings.TrimSpace(line), "[") {
+			section = lineSection
+			if section == k.section {
+				found = true
+				insertAt = i + 1
+			}
+			continue
+		}
+		if section != k.section {
+			continue
+		}
+		if key == k.key {
+			lines[i] = newLine
+			return lines
+		}
+		if key != "" {
+			insertAt = i + 1
+		}
+	}
+
+	if !found {
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		return append(lines, "["+k.section+"]", newLine)
+	}
+
+	lines = append(lines, "")
+	copy(lines[insertAt+1:], lines[insertAt:])
+	lines[insertAt] = newLine
+	return lines
+}
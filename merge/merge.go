@@ -0,0 +1,268 @@
+package merge
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// FormatJSON - JSON file (comments and trailing commas are accepted)
+	FormatJSON = "json"
+	// FormatYAML - YAML file
+	FormatYAML = "yaml"
+	// FormatINI - INI file
+	FormatINI = "ini"
+
+	// StrategyDeep - Objects are merged recursively, other values replaced
+	StrategyDeep = "deep"
+	// StrategyMergePatch - JSON Merge Patch (RFC 7396), null removes keys
+	StrategyMergePatch = "merge-patch"
+	// StrategyJSONPatch - JSON Patch (RFC 6902) operations
+	StrategyJSONPatch = "json-patch"
+)
+
+// FormatFor - Format of the local file path by its extension, "" if unknown
+func FormatFor(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		return FormatJSON
+	case ".yaml", ".yml":
+		return FormatYAML
+	case ".ini":
+		return FormatINI
+	}
+	return ""
+}
+
+// Check - Whether format and strategy are valid and can be combined
+func Check(format string, strategy string) error {
+	switch format {
+	case FormatJSON, FormatYAML, FormatINI:
+	default:
+		return fmt.Errorf("Unknown merge format %s", format)
+	}
+
+	switch strategy {
+	case StrategyDeep:
+	case StrategyMergePatch, StrategyJSONPatch:
+		if format == FormatINI {
+			return fmt.Errorf("Merge strategy %s is not supported for %s", strategy, format)
+		}
+	default:
+		return fmt.Errorf("Unknown merge strategy %s", strategy)
+	}
+	return nil
+}
+
+// File - Merge the fragment in fragmentPath into the local file path (which
+// may be missing) and return the result. Keys of the local file the fragment
+// does not cover are kept.
+func File(path string, fragmentPath string, format string, strategy string) ([]byte, error) {
+	err := Check(format, strategy)
+	if err != nil {
+		return nil, err
+	}
+
+	local, err := ioutil.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	fragment, err := ioutil.ReadFile(fragmentPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if format == FormatINI {
+		return mergeINI(local, fragment)
+	}
+
+	doc, err := decode(local, format)
+	if err != nil {
+		return nil, fmt.Errorf("Local file: %v", err)
+	}
+
+	var patch interface{}
+	if strategy == StrategyJSONPatch {
+		// Patches are JSON documents whatever the target format
+		patch, err = decode(fragment, FormatJSON)
+	} else {
+		patch, err = decode(fragment, format)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Remote fragment: %v", err)
+	}
+
+	switch strategy {
+	case StrategyDeep:
+		doc = deepMerge(doc, patch, false)
+	case StrategyMergePatch:
+		doc = deepMerge(doc, patch, true)
+	case StrategyJSONPatch:
+		doc, err = applyPatch(doc, patch)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return encode(doc, format)
+}
+
+// decode - Decode JSON or YAML into maps, slices and scalars. An empty
+// document is an empty object.
+func decode(content []byte, format string) (interface{}, error) {
+	var doc interface{}
+
+	if format == FormatJSON {
+		content = stripJSONC(content)
+		if len(bytes.TrimSpace(content)) == 0 {
+			return map[string]interface{}{}, nil
+		}
+
+		d := json.NewDecoder(bytes.NewReader(content))
+		d.UseNumber()
+		err := d.Decode(&doc)
+		if err != nil {
+			return nil, err
+		}
+		return doc, nil
+	}
+
+	err := yaml.Unmarshal(content, &doc)
+	if err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		return map[string]interface{}{}, nil
+	}
+	return normalize(doc), nil
+}
+
+// normalize - Turn YAML maps with non-string keys into string keyed maps
+func normalize(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalize(item)
+		}
+		return v
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for key, item := range v {
+			m[fmt.Sprint(key)] = normalize(item)
+		}
+		return m
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalize(item)
+		}
+		return v
+	}
+	return value
+}
+
+func encode(doc interface{}, format string) ([]byte, error) {
+	if format == FormatJSON {
+		var b bytes.Buffer
+		e := json.NewEncoder(&b)
+		e.SetEscapeHTML(false)
+		e.SetIndent("", "  ")
+		err := e.Encode(doc)
+		return b.Bytes(), err
+	}
+
+	var b bytes.Buffer
+	e := yaml.NewEncoder(&b)
+	e.SetIndent(2)
+	err := e.Encode(doc)
+	if err != nil {
+		return nil, err
+	}
+	err = e.Close()
+	return b.Bytes(), err
+}
+
+// deepMerge - Merge patch into doc. Objects are merged key by key, anything
+// else replaces the local value. With mergePatch a null removes the key
+// (RFC 7396).
+func deepMerge(doc interface{}, patch interface{}, mergePatch bool) interface{} {
+	patchMap, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	docMap, ok := doc.(map[string]interface{})
+	if !ok {
+		docMap = map[string]interface{}{}
+	}
+
+	for key, value := range patchMap {
+		if value == nil && mergePatch {
+			delete(docMap, key)
+			continue
+		}
+		docMap[key] = deepMerge(docMap[key], value, mergePatch)
+	}
+	return docMap
+}
+
+// stripJSONC - Remove comments and trailing commas (as in VS Code settings)
+// so the content can be decoded as JSON
+func stripJSONC(content []byte) []byte {
+	var out []byte
+	inString := false
+
+	for i := 0; i < len(content); i++ {
+		ch := content[i]
+
+		if inString {
+			out = append(out, ch)
+			if ch == '\\' && i+1 < len(content) {
+				i++
+				out = append(out, content[i])
+			} else if ch == '"' {
+				inString = false
+			}
+			continue
+		}
+
+		switch {
+		case ch == '"':
+			inString = true
+			out = append(out, ch)
+		case ch == '/' && i+1 < len(content) && content[i+1] == '/':
+			for i < len(content) && content[i] != '\n' {
+				i++
+			}
+			if i < len(content) {
+				out = append(out, '\n')
+			}
+		case ch == '/' && i+1 < len(content) && content[i+1] == '*':
+			i += 2
+			for i+1 < len(content) && !(content[i] == '*' && content[i+1] == '/') {
+				i++
+			}
+			i++
+		case ch == ']' || ch == '}':
+			// Drop a trailing comma before the closing bracket
+			j := len(out) - 1
+			for j >= 0 && (out[j] == ' ' || out[j] == '\t' || out[j] == '\n' || out[j] == '\r') {
+				j--
+			}
+			if j >= 0 && out[j] == ',' {
+				out = append(out[:j], out[j+1:]...)
+			}
+			out = append(out, ch)
+		default:
+			out = append(out, ch)
+		}
+	}
+	return out
+}
@@ -0,0 +1,312 @@
+package merge
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"ironsync/utils"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// patchHashPath - File next to path recording the hash of the JSON patch
+// applied to it last
+func patchHashPath(path string) string {
+	return filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".ironsync-patch")
+}
+
+// PatchApplied - Hash of the JSON patch in fragmentPath and whether it was
+// applied to the local file path already. Operations such as appending to
+// an array are not idempotent, so each version of a patch is applied once.
+func PatchApplied(path string, fragmentPath string) (string, bool) {
+	hash, err := utils.HashFile(fragmentPath)
+	if err != nil {
+		return "", false
+	}
+
+	// A missing local file gets the patch again
+	_, err = os.Stat(path)
+	if err != nil {
+		return hash, false
+	}
+
+	recorded, err := ioutil.ReadFile(patchHashPath(path))
+	return hash, err == nil && strings.TrimSpace(string(recorded)) == hash
+}
+
+// RecordPatch - Record hash as the JSON patch applied to path
+func RecordPatch(path string, hash string) error {
+	return ioutil.WriteFile(patchHashPath(path), []byte(hash+"\n"), 0644)
+}
+
+// applyPatch - Apply JSON Patch (RFC 6902) operations to doc
+func applyPatch(doc interface{}, patch interface{}) (interface{}, error) {
+	ops, ok := patch.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("JSON Patch must be an array of operations")
+	}
+
+	for i, item := range ops {
+		op, ok := item.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("JSON Patch operation %d is not an object", i)
+		}
+
+		name, _ := op["op"].(string)
+		path, ok := op["path"].(string)
+		if !ok {
+			return nil, fmt.Errorf("JSON Patch operation %d has no path", i)
+		}
+
+		var err error
+		switch name {
+		case "add":
+			value, ok := op["value"]
+			if !ok {
+				return nil, fmt.Errorf("JSON Patch operation %d has no value", i)
+			}
+			doc, err = patchAdd(doc, path, copyValue(value))
+		case "remove":
+			doc, _, err = patchRemove(doc, path)
+		case "replace":
+			value, ok := op["value"]
+			if !ok {
+				return nil, fmt.Errorf("JSON Patch operation %d has no value", i)
+			}
+			doc, _, err = patchRemove(doc, path)
+			if err == nil {
+				doc, err = patchAdd(doc, path, copyValue(value))
+			}
+		case "move", "copy":
+			from, ok := op["from"].(string)
+			if !ok {
+				return nil, fmt.Errorf("JSON Patch operation %d has no from", i)
+			}
+			var value interface{}
+			if name == "move" {
+				if strings.HasPrefix(path, from+"/") {
+					return nil, fmt.Errorf("JSON Patch operation %d moves %s into itself", i, from)
+				}
+				doc, value, err = patchRemove(doc, from)
+			} else {
+				value, err = patchGet(doc, from)
+				value = copyValue(value)
+			}
+			if err == nil {
+				doc, err = patchAdd(doc, path, value)
+			}
+		case "test":
+			var value interface{}
+			value, err = patchGet(doc, path)
+			if err == nil && !equalValues(value, op["value"]) {
+				err = fmt.Errorf("test failed")
+			}
+		default:
+			return nil, fmt.Errorf("JSON Patch operation %d has unknown op %q", i, name)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("JSON Patch operation %d (%s %s): %v", i, name, path, err)
+		}
+	}
+	return doc, nil
+}
+
+// splitPointer - Reference tokens of a JSON Pointer (RFC 6901)
+func splitPointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON Pointer %q", pointer)
+	}
+
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		token = strings.ReplaceAll(token, "~1", "/")
+		tokens[i] = strings.ReplaceAll(token, "~0", "~")
+	}
+	return tokens, nil
+}
+
+// arrayIndex - Index of token in an array of length n; "-" is n (append)
+func arrayIndex(token string, n int, allowEnd bool) (int, error) {
+	if token == "-" && allowEnd {
+		return n, nil
+	}
+
+	index, err := strconv.Atoi(token)
+	if err != nil || index < 0 || (token != "0" && strings.HasPrefix(token, "0")) {
+		return 0, fmt.Errorf("invalid array index %q", token)
+	}
+	if index > n || (index == n && !allowEnd) {
+		return 0, fmt.Errorf("array index %d out of range", index)
+	}
+	return index, nil
+}
+
+func patchGet(doc interface{}, pointer string) (interface{}, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	value := doc
+	for _, token := range tokens {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			item, ok := v[token]
+			if !ok {
+				return nil, fmt.Errorf("no member %q", token)
+			}
+			value = item
+		case []interface{}:
+			index, err := arrayIndex(token, len(v), false)
+			if err != nil {
+				return nil, err
+			}
+			value = v[index]
+		default:
+			return nil, fmt.Errorf("cannot descend into %q", token)
+		}
+	}
+	return value, nil
+}
+
+// patchAdd - Add value at pointer and return the (possibly new) document
+func patchAdd(doc interface{}, pointer string, value interface{}) (interface{}, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	return update(doc, tokens, func(parent interface{}, token string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			p[token] = value
+			return p, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(p), true)
+			if err != nil {
+				return nil, err
+			}
+			p = append(p, nil)
+			copy(p[index+1:], p[index:])
+			p[index] = value
+			return p, nil
+		}
+		return nil, fmt.Errorf("cannot add member %q", token)
+	})
+}
+
+// patchRemove - Remove the value at pointer and return the new document and
+// the removed value
+func patchRemove(doc interface{}, pointer string) (interface{}, interface{}, error) {
+	tokens, err := splitPointer(pointer)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(tokens) == 0 {
+		return nil, nil, fmt.Errorf("cannot remove the whole document")
+	}
+
+	var removed interface{}
+	doc, err = update(doc, tokens, func(parent interface{}, token string) (interface{}, error) {
+		switch p := parent.(type) {
+		case map[string]interface{}:
+			item, ok := p[token]
+			if !ok {
+				return nil, fmt.Errorf("no member %q", token)
+			}
+			removed = item
+			delete(p, token)
+			return p, nil
+		case []interface{}:
+			index, err := arrayIndex(token, len(p), false)
+			if err != nil {
+				return nil, err
+			}
+			removed = p[index]
+			return append(p[:index], p[index+1:]...), nil
+		}
+		return nil, fmt.Errorf("cannot remove member %q", token)
+	})
+	return doc, removed, err
+}
+
+// update - Walk to the parent of the last token and replace it with the
+// result of change. Arrays may be reallocated, so every level is written
+// back.
+func update(doc interface{}, tokens []string, change func(interface{}, string) (interface{}, error)) (interface{}, error) {
+	if len(tokens) == 1 {
+		return change(doc, tokens[0])
+	}
+
+	token := tokens[0]
+	switch v := doc.(type) {
+	case map[string]interface{}:
+		item, ok := v[token]
+		if !ok {
+			return nil, fmt.Errorf("no member %q", token)
+		}
+		item, err := update(item, tokens[1:], change)
+		if err != nil {
+			return nil, err
+		}
+		v[token] = item
+		return v, nil
+	case []interface{}:
+		index, err := arrayIndex(token, len(v), false)
+		if err != nil {
+			return nil, err
+		}
+		item, err := update(v[index], tokens[1:], change)
+		if err != nil {
+			return nil, err
+		}
+		v[index] = item
+		return v, nil
+	}
+	return nil, fmt.Errorf("cannot descend into %q", token)
+}
+
+// copyValue - Deep copy of a decoded value, so a copied or added value is
+// not shared with the patch
+func copyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		m := map[string]interface{}{}
+		for key, item := range v {
+			m[key] = copyValue(item)
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i, item := range v {
+			s[i] = copyValue(item)
+		}
+		return s
+	}
+	return value
+}
+
+// equalValues - Compare decoded values, numbers by value whatever their
+// Go type
+func equalValues(a interface{}, b interface{}) bool {
+	aJSON, errA := json.Marshal(a)
+	bJSON, errB := json.Marshal(b)
+	if errA != nil || errB != nil {
+		return reflect.DeepEqual(a, b)
+	}
+
+	var aValue, bValue interface{}
+	json.Unmarshal(aJSON, &aValue)
+	json.Unmarshal(bJSON, &bValue)
+	return reflect.DeepEqual(aValue, bValue)
+}
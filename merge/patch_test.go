@@ -0,0 +1,209 @@
+package merge
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// mergeJSON - Apply the JSON patch to the local JSON document with File
+func mergeJSON(t *testing.T, dir string, local string, patch string) ([]byte, error) {
+	t.Helper()
+
+	path := filepath.Join(dir, "local.json")
+	fragmentPath := filepath.Join(dir, "patch.json")
+	if err := ioutil.WriteFile(path, []byte(local), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(fragmentPath, []byte(patch), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return File(path, fragmentPath, FormatJSON, StrategyJSONPatch)
+}
+
+func TestJSONPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		local   string
+		patch   string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:  "add key",
+			local: `{"a": 1}`,
+			patch: `[{"op": "add", "path": "/b", "value": {"c": [1]}}]`,
+			want:  `{"a": 1, "b": {"c": [1]}}`,
+		},
+		{
+			name:  "append to array",
+			local: `{"list": [1, 2]}`,
+			patch: `[{"op": "add", "path": "/list/-", "value": 3}]`,
+			want:  `{"list": [1, 2, 3]}`,
+		},
+		{
+			name:  "insert into array",
+			local: `{"list": [1, 3]}`,
+			patch: `[{"op": "add", "path": "/list/1", "value": 2}]`,
+			want:  `{"list": [1, 2, 3]}`,
+		},
+		{
+			name:  "remove",
+			local: `{"a": 1, "b": 2}`,
+			patch: `[{"op": "remove", "path": "/a"}]`,
+			want:  `{"b": 2}`,
+		},
+		{
+			name:    "remove missing",
+			local:   `{"a": 1}`,
+			patch:   `[{"op": "remove", "path": "/b"}]`,
+			wantErr: true,
+		},
+		{
+			name:  "replace",
+			local: `{"a": {"b": 1}}`,
+			patch: `[{"op": "replace", "path": "/a/b", "value": "x"}]`,
+			want:  `{"a": {"b": "x"}}`,
+		},
+		{
+			name:  "move",
+			local: `{"a": 1, "b": {}}`,
+			patch: `[{"op": "move", "from": "/a", "path": "/b/a"}]`,
+			want:  `{"b": {"a": 1}}`,
+		},
+		{
+			name:    "move into itself",
+			local:   `{"a": {"b": 1}}`,
+			patch:   `[{"op": "move", "from": "/a", "path": "/a/b/c"}]`,
+			wantErr: true,
+		},
+		{
+			name:  "copy",
+			local: `{"a": [1]}`,
+			patch: `[{"op": "copy", "from": "/a", "path": "/b"}, {"op": "add", "path": "/b/-", "value": 2}]`,
+			want:  `{"a": [1], "b": [1, 2]}`,
+		},
+		{
+			name:  "test passes",
+			local: `{"a": 1.0}`,
+			patch: `[{"op": "test", "path": "/a", "value": 1}, {"op": "add", "path": "/b", "value": true}]`,
+			want:  `{"a": 1, "b": true}`,
+		},
+		{
+			name:    "test fails",
+			local:   `{"a": 1}`,
+			patch:   `[{"op": "test", "path": "/a", "value": 2}]`,
+			wantErr: true,
+		},
+		{
+			name:  "escaped pointer",
+			local: `{"a/b": {"c~d": 1}}`,
+			patch: `[{"op": "replace", "path": "/a~1b/c~0d", "value": 2}]`,
+			want:  `{"a/b": {"c~d": 2}}`,
+		},
+		{
+			name:  "comments in local file",
+			local: "{\n  // Set by hand\n  \"a\": 1 /* one */\n}",
+			patch: `[{"op": "add", "path": "/b", "value": 2}]`,
+			want:  `{"a": 1, "b": 2}`,
+		},
+		{
+			name:    "unknown op",
+			local:   `{}`,
+			patch:   `[{"op": "merge", "path": "/a"}]`,
+			wantErr: true,
+		},
+		{
+			name:    "not an array",
+			local:   `{}`,
+			patch:   `{"op": "add", "path": "/a", "value": 1}`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := mergeJSON(t, t.TempDir(), tt.local, tt.patch)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("File() = %s, want error", merged)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("File() failed: %v", err)
+			}
+
+			got, err := decode(merged, FormatJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := decode([]byte(tt.want), FormatJSON)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalValues(got, want) {
+				t.Errorf("File() = %s, want %s", merged, tt.want)
+			}
+		})
+	}
+}
+
+func TestPatchApplied(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "local.json")
+	fragmentPath := filepath.Join(dir, "patch.json")
+	patch := `[{"op": "add", "path": "/list/-", "value": 3}]`
+
+	merged, err := mergeJSON(t, dir, `{"list": [1, 2]}`, patch)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hash, applied := PatchApplied(path, fragmentPath)
+	if applied {
+		t.Fatalf("PatchApplied() before RecordPatch = true")
+	}
+
+	// Install the merged file the way the service does
+	if err := ioutil.WriteFile(path, merged, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := RecordPatch(path, hash); err != nil {
+		t.Fatal(err)
+	}
+
+	again, applied := PatchApplied(path, fragmentPath)
+	if !applied || again != hash {
+		t.Errorf("PatchApplied() after RecordPatch = %q, %v, want %q, true", again, applied, hash)
+	}
+
+	// A new version of the patch is applied once more
+	if err := ioutil.WriteFile(fragmentPath, []byte(`[{"op": "add", "path": "/list/-", "value": 4}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, applied := PatchApplied(path, fragmentPath); applied {
+		t.Errorf("PatchApplied() of a changed patch = true")
+	}
+
+	// So is a patch whose local file went missing
+	if err := ioutil.WriteFile(fragmentPath, []byte(patch), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.json")
+	if err := RecordPatch(missing, hash); err != nil {
+		t.Fatal(err)
+	}
+	if _, applied := PatchApplied(missing, fragmentPath); applied {
+		t.Errorf("PatchApplied() of a missing local file = true")
+	}
+
+	got, err := decode(merged, FormatJSON)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"list": []interface{}{1, 2, 3}}
+	if !equalValues(got, want) {
+		t.Errorf("merged = %s, want list [1, 2, 3]", merged)
+	}
+}
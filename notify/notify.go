@@ -0,0 +1,285 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// EventUpdated - A resource was installed or changed
+	EventUpdated = "updated"
+	// EventFailed - A resource started failing to update
+	EventFailed = "failed"
+	// EventRecovered - A failing resource updated successfully again
+	EventRecovered = "recovered"
+	// EventDrift - An installed file was changed locally
+	EventDrift = "drift"
+	// EventRollback - An update was undone because its health command failed
+	EventRollback = "rollback"
+
+	// DefaultRateInterval - Seconds over which RateLimit is counted
+	DefaultRateInterval = 60
+
+	// queueSize - Events waiting for a sink before new ones are dropped
+	queueSize = 100
+)
+
+// EventTypes - Every event type, in the order listed in the documentation
+var EventTypes = []string{EventUpdated, EventFailed, EventRecovered, EventDrift, EventRollback}
+
+// Event - Outcome of syncing a resource
+type Event struct {
+	Type       string    `json:"type"`
+	Time       time.Time `json:"time"`
+	Host       string    `json:"host"`
+	Connection string    `json:"connection"`
+	Path       string    `json:"path"`
+	Message    string    `json:"message,omitempty"`
+}
+
+// Sender - Delivers events to one destination
+type Sender interface {
+	Send(ctx context.Context, e Event) error
+}
+
+// secretResolver - Sender with secret references to look up again on reload
+type secretResolver interface {
+	ResolveSecrets() error
+}
+
+// Sink - Sender with the events it receives
+type Sink struct {
+	Name         string   // Section name
+	Sender       Sender   // Destination
+	Events       []string // Event types to send (all if empty)
+	Paths        []string // Glob patterns of resource paths to send (all if empty)
+	RateLimit    int      // Events sent per RateInterval, the rest is dropped (0 for no limit)
+	RateInterval int      // Seconds
+	Timeout      int      // Seconds to deliver an event
+
+	queue   chan Event
+	sent    []time.Time // Times of the events sent within RateInterval
+	dropped int         // Events dropped by the rate limit since the last one sent
+}
+
+// CreateSink - Create a sink sending every event to sender
+func CreateSink(name string, sender Sender) Sink {
+	return Sink{
+		Name:         name,
+		Sender:       sender,
+		RateInterval: DefaultRateInterval,
+		Timeout:      10,
+	}
+}
+
+// IsEventType - Whether name is a known event type
+func IsEventType(name string) bool {
+	for _, t := range EventTypes {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// matches - Whether the filters of the sink let e through
+func (s *Sink) matches(e Event) bool {
+	if len(s.Events) > 0 {
+		found := false
+		for _, t := range s.Events {
+			if t == e.Type {
+				found = true
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(s.Paths) > 0 {
+		for _, pattern := range s.Paths {
+			if ok, _ := path.Match(pattern, e.Path); ok || strings.HasPrefix(e.Path, strings.TrimSuffix(pattern, "/")+"/") {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// allow - Whether the rate limit lets an event through at now
+func (s *Sink) allow(now time.Time) bool {
+	if s.RateLimit <= 0 {
+		return true
+	}
+
+	window := now.Add(-time.Duration(s.RateInterval) * time.Second)
+	for len(s.sent) > 0 && s.sent[0].Before(window) {
+		s.sent = s.sent[1:]
+	}
+
+	if len(s.sent) >= s.RateLimit {
+		return false
+	}
+	s.sent = append(s.sent, now)
+	return true
+}
+
+// run - Deliver queued events until ctx is cancelled, then the events
+// still queued until drain expires
+func (s *Sink) run(ctx context.Context, drain time.Duration, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	for {
+		select {
+		case <-ctx.Done():
+			s.drain(time.Now().Add(drain), nil)
+			return
+		case e := <-s.queue:
+			if ctx.Err() != nil {
+				// Stopped meanwhile, e is the first of the queued events
+				s.drain(time.Now().Add(drain), &e)
+				return
+			}
+			s.deliver(context.Background(), e)
+		}
+	}
+}
+
+// drain - Deliver first (if not nil) and the queued events until deadline,
+// dropping the rest
+func (s *Sink) drain(deadline time.Time, first *Event) {
+	ctx, cancel := context.WithDeadline(context.Background(), deadline)
+	defer cancel()
+
+	for {
+		var e Event
+		if first != nil {
+			e, first = *first, nil
+		} else {
+			select {
+			case e = <-s.queue:
+			default:
+				return
+			}
+		}
+
+		if ctx.Err() != nil {
+			log.Printf("[sink %s] Shutting down, dropping %d events", s.Name, len(s.queue)+1)
+			return
+		}
+		s.deliver(ctx, e)
+	}
+}
+
+// deliver - Send e if the rate limit lets it through, within the sink
+// timeout and the deadline of ctx
+func (s *Sink) deliver(ctx context.Context, e Event) {
+	if !s.allow(time.Now()) {
+		if s.dropped == 0 {
+			log.Printf("[sink %s] Rate limit reached, dropping events", s.Name)
+		}
+		s.dropped++
+		return
+	}
+
+	if s.dropped > 0 {
+		log.Printf("[sink %s] Dropped %d events", s.Name, s.dropped)
+		s.dropped = 0
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(s.Timeout)*time.Second)
+	err := s.Sender.Send(ctx, e)
+	cancel()
+	if err != nil {
+		log.Printf("[sink %s] Sending %s event for %s failed: %v", s.Name, e.Type, e.Path, err)
+	}
+}
+
+// Notifier - Sends events to sinks in the background
+type Notifier struct {
+	sinks []*Sink
+	host  string
+	wg    sync.WaitGroup // Running sinks
+}
+
+// CreateNotifier - Create a notifier for sinks
+func CreateNotifier(sinks []*Sink) *Notifier {
+	host, _ := os.Hostname()
+
+	for _, s := range sinks {
+		s.queue = make(chan Event, queueSize)
+	}
+	return &Notifier{sinks: sinks, host: host}
+}
+
+// Run - Deliver events until ctx is cancelled. The events still queued
+// then are delivered for at most drain, see Wait.
+func (n *Notifier) Run(ctx context.Context, drain time.Duration) {
+	for _, s := range n.sinks {
+		n.wg.Add(1)
+		go s.run(ctx, drain, &n.wg)
+	}
+}
+
+// Wait - Wait for the sinks to deliver the queued events after the context
+// of Run is cancelled. Does nothing on a nil notifier.
+func (n *Notifier) Wait() {
+	if n == nil {
+		return
+	}
+	n.wg.Wait()
+}
+
+// ResolveSecrets - Look up the secret references of the senders again,
+// e.g. after they were rotated. Does nothing on a nil notifier.
+func (n *Notifier) ResolveSecrets() error {
+	if n == nil {
+		return nil
+	}
+
+	for _, s := range n.sinks {
+		if resolver, ok := s.Sender.(secretResolver); ok {
+			err := resolver.ResolveSecrets()
+			if err != nil {
+				return fmt.Errorf("sink %s: %v", s.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Emit - Queue an event for every sink whose filters match, without
+// waiting for it to be delivered. Does nothing on a nil notifier.
+func (n *Notifier) Emit(eventType string, connection string, path string, message string) {
+	if n == nil {
+		return
+	}
+
+	e := Event{
+		Type:       eventType,
+		Time:       time.Now().UTC(),
+		Host:       n.host,
+		Connection: connection,
+		Path:       path,
+		Message:    message,
+	}
+
+	for _, s := range n.sinks {
+		if !s.matches(e) {
+			continue
+		}
+
+		select {
+		case s.queue <- e:
+		default:
+			log.Printf("[sink %s] Queue full, dropping %s event for %s", s.Name, e.Type, e.Path)
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package notify
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder - Sender keeping the events it receives
+type recorder struct {
+	lock   sync.Mutex
+	events []Event
+	delay  time.Duration // Time each send takes
+}
+
+func (r *recorder) Send(ctx context.Context, e Event) error {
+	select {
+	case <-time.After(r.delay):
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.events = append(r.events, e)
+	return nil
+}
+
+func (r *recorder) count() int {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return len(r.events)
+}
+
+func TestSinkMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		events []string
+		paths  []string
+		e      Event
+		want   bool
+	}{
+		{"no filters", nil, nil, Event{Type: EventFailed, Path: "/etc/a"}, true},
+		{"event listed", []string{EventFailed, EventRollback}, nil, Event{Type: EventRollback}, true},
+		{"event not listed", []string{EventFailed}, nil, Event{Type: EventUpdated}, false},
+		{"glob", nil, []string{"/etc/*.conf"}, Event{Path: "/etc/app.conf"}, true},
+		{"glob does not match", nil, []string{"/etc/*.conf"}, Event{Path: "/etc/app.ini"}, false},
+		{"directory prefix", nil, []string{"/etc/nginx/"}, Event{Path: "/etc/nginx/sites/default"}, true},
+		{"not a prefix of the name", nil, []string{"/etc/nginx"}, Event{Path: "/etc/nginx2/a"}, false},
+		{"both filters", []string{EventDrift}, []string{"/etc/*"}, Event{Type: EventUpdated, Path: "/etc/a"}, false},
+	}
+
+	for _, tt := range tests {
+		s := Sink{Events: tt.events, Paths: tt.paths}
+		if got := s.matches(tt.e); got != tt.want {
+			t.Errorf("%s: matches() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSinkAllow(t *testing.T) {
+	s := Sink{RateLimit: 2, RateInterval: 60}
+	now := time.Now()
+
+	for i, want := range []bool{true, true, false, false} {
+		if got := s.allow(now.Add(time.Duration(i) * time.Second)); got != want {
+			t.Errorf("event %d: allow() = %v, want %v", i, got, want)
+		}
+	}
+
+	// The first events leave the window
+	if !s.allow(now.Add(61 * time.Second)) {
+		t.Errorf("allow() after the interval = false")
+	}
+
+	unlimited := Sink{}
+	for i := 0; i < 100; i++ {
+		if !unlimited.allow(now) {
+			t.Fatalf("allow() without a limit = false")
+		}
+	}
+}
+
+func TestNotifierDrainsOnShutdown(t *testing.T) {
+	r := &recorder{delay: 10 * time.Millisecond}
+	sink := CreateSink("test", r)
+	n := CreateNotifier([]*Sink{&sink})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	n.Run(ctx, 5*time.Second)
+
+	// Events still queued when the sinks are stopped are sent
+	for i := 0; i < 3; i++ {
+		n.Emit(EventFailed, "web", "/etc/a", "")
+	}
+	cancel()
+	n.Wait()
+
+	if got := r.count(); got != 3 {
+		t.Errorf("events sent = %d, want 3", got)
+	}
+}
+
+func TestNotifierDrainDeadline(t *testing.T) {
+	r := &recorder{delay: time.Second}
+	sink := CreateSink("test", r)
+	n := CreateNotifier([]*Sink{&sink})
+
+	for i := 0; i < 10; i++ {
+		n.Emit(EventUpdated, "web", "/etc/a", "")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	n.Run(ctx, 100*time.Millisecond)
+	n.Wait()
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Wait() took %v, want the drain deadline", elapsed)
+	}
+	if got := r.count(); got == 10 {
+		t.Errorf("all events sent after the drain deadline")
+	}
+}
+
+func TestNotifierResolveSecrets(t *testing.T) {
+	t.Setenv("IRONSYNC_TEST_WEBHOOK_SECRET", "rotated")
+
+	webhook := &Webhook{URL: "http://localhost/", Secret: "old", SecretRef: "env:IRONSYNC_TEST_WEBHOOK_SECRET"}
+	sink := CreateSink("hook", webhook)
+	n := CreateNotifier([]*Sink{&sink})
+
+	if err := n.ResolveSecrets(); err != nil {
+		t.Fatalf("ResolveSecrets() failed: %v", err)
+	}
+	if webhook.Secret != "rotated" {
+		t.Errorf("Secret = %q, want %q", webhook.Secret, "rotated")
+	}
+}
+
+func TestSMTPHungRelay(t *testing.T) {
+	// Accepts connections but never greets
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	mail := &SMTP{Server: l.Addr().String(), From: "ironsync@example.com", To: []string{"ops@example.com"}}
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- mail.Send(ctx, Event{Type: EventFailed, Path: "/etc/a"})
+	}()
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Errorf("Send() to a hung relay succeeded")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Send() did not stop at the deadline")
+	}
+}
@@ -0,0 +1,245 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"ironsync/secret"
+	"ironsync/utils"
+	"log"
+	"net"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+)
+
+const (
+	// SignatureHeader - HTTP header carrying the HMAC-SHA256 of a webhook
+	// body, as sha256=<hex>
+	SignatureHeader = "X-Ironsync-Signature"
+	// EventHeader - HTTP header carrying the event type of a webhook
+	EventHeader = "X-Ironsync-Event"
+
+	// DefaultSMTPServer - Local relay used if none is set
+	DefaultSMTPServer = "localhost:25"
+)
+
+// Webhook - POSTs events as JSON, signed with Secret if it is set
+type Webhook struct {
+	URL       string // Endpoint
+	Secret    string // HMAC-SHA256 key (optional)
+	SecretRef string // Secret reference resolved into Secret (optional)
+
+	lock sync.Mutex // Guards Secret, replaced on reload while sending
+}
+
+// ResolveSecrets - Look up SecretRef again, e.g. after it was rotated.
+// Secret keeps its value if the reference cannot be resolved.
+func (w *Webhook) ResolveSecrets() error {
+	if w.SecretRef == "" {
+		return nil
+	}
+
+	value, err := secret.Resolve(w.SecretRef)
+	if err != nil {
+		return err
+	}
+
+	w.lock.Lock()
+	w.Secret = value
+	w.lock.Unlock()
+	return nil
+}
+
+// Sign - Signature header value of body for secret
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send - POST the event
+func (w *Webhook) Send(ctx context.Context, e Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", w.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, e.Type)
+	w.lock.Lock()
+	key := w.Secret
+	w.lock.Unlock()
+	if key != "" {
+		req.Header.Set(SignatureHeader, Sign(key, body))
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s returned %s", w.URL, resp.Status)
+	}
+	return nil
+}
+
+// SMTP - Mails events through a relay that needs no authentication
+type SMTP struct {
+	Server string   // host:port
+	From   string   // Sender address
+	To     []string // Recipient addresses
+}
+
+// subject - One line summary of an event
+func subject(e Event) string {
+	return fmt.Sprintf("[ironsync] %s %s on %s", e.Type, e.Path, e.Host)
+}
+
+// Send - Mail the event
+func (s *SMTP) Send(ctx context.Context, e Event) error {
+	var msg strings.Builder
+	fmt.Fprintf(&msg, "From: %s\r\n", s.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(s.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject(e))
+	fmt.Fprintf(&msg, "Date: %s\r\n", e.Time.Format("Mon, 02 Jan 2006 15:04:05 -0700"))
+	fmt.Fprintf(&msg, "Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	fmt.Fprintf(&msg, "Event: %s\r\nHost: %s\r\nConnection: %s\r\nPath: %s\r\nTime: %s\r\n",
+		e.Type, e.Host, e.Connection, e.Path, e.Time.Format("2006-01-02 15:04:05 MST"))
+	if e.Message != "" {
+		fmt.Fprintf(&msg, "\r\n%s\r\n", e.Message)
+	}
+
+	err := s.send(ctx, []byte(msg.String()))
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// send - Deliver msg like smtp.SendMail, stopping when ctx is done
+func (s *SMTP) send(ctx context.Context, msg []byte) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", s.Server)
+	if err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Unblock a hung relay if ctx is cancelled before its deadline
+	sent := make(chan struct{})
+	defer close(sent)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-sent:
+		}
+	}()
+
+	host, _, err := net.SplitHostPort(s.Server)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		err = client.StartTLS(&tls.Config{ServerName: host})
+		if err != nil {
+			return err
+		}
+	}
+
+	err = client.Mail(s.From)
+	if err != nil {
+		return err
+	}
+	for _, to := range s.To {
+		err = client.Rcpt(to)
+		if err != nil {
+			return err
+		}
+	}
+
+	w, err := client.Data()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(msg)
+	if err != nil {
+		return err
+	}
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return client.Quit()
+}
+
+// Command - Runs a command per event, with the event fields in
+// IRONSYNC_EVENT_* environment variables
+type Command struct {
+	Command string   // Shell command
+	Argv    []string // Program and arguments to run instead of Command
+	User    string   // Run as user (optional)
+	Group   string   // Run as group (optional)
+	Timeout int      // Seconds
+}
+
+// Send - Run the command for the event
+func (c *Command) Send(ctx context.Context, e Event) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	cmd := utils.Command{
+		Command: c.Command,
+		Argv:    c.Argv,
+		User:    c.User,
+		Group:   c.Group,
+		Timeout: c.Timeout,
+		Env: []string{
+			"IRONSYNC_EVENT=" + e.Type,
+			"IRONSYNC_EVENT_HOST=" + e.Host,
+			"IRONSYNC_EVENT_CONNECTION=" + e.Connection,
+			"IRONSYNC_EVENT_PATH=" + e.Path,
+			"IRONSYNC_EVENT_MESSAGE=" + e.Message,
+			"IRONSYNC_EVENT_JSON=" + string(payload),
+		},
+	}
+
+	// Event fields are only passed in the environment, never pasted into
+	// the command where a message could inject shell syntax
+	output, err := cmd.Run(ctx)
+	if err != nil && len(output) > 0 {
+		log.Printf("Event command output:\n%s", output)
+	}
+	return err
+}
@@ -0,0 +1,40 @@
+//go:build !windows
+// +build !windows
+
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log/syslog"
+)
+
+// Syslog - Writes events to the local syslog daemon
+type Syslog struct {
+	Tag string // Program name of the messages
+
+	writer *syslog.Writer
+}
+
+// Send - Log the event, failures and drift as warnings
+func (s *Syslog) Send(ctx context.Context, e Event) error {
+	if s.writer == nil {
+		w, err := syslog.New(syslog.LOG_DAEMON|syslog.LOG_INFO, s.Tag)
+		if err != nil {
+			return err
+		}
+		s.writer = w
+	}
+
+	msg := fmt.Sprintf("[%s][%s] %s", e.Connection, e.Path, e.Type)
+	if e.Message != "" {
+		msg += ": " + e.Message
+	}
+
+	switch e.Type {
+	case EventFailed, EventDrift, EventRollback:
+		return s.writer.Warning(msg)
+	default:
+		return s.writer.Info(msg)
+	}
+}
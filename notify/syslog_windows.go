@@ -0,0 +1,19 @@
+//go:build windows
+// +build windows
+
+package notify
+
+import (
+	"context"
+	"fmt"
+)
+
+// Syslog - Not available on Windows
+type Syslog struct {
+	Tag string // Program name of the messages
+}
+
+// Send - Always fails, there is no syslog daemon
+func (s *Syslog) Send(ctx context.Context, e Event) error {
+	return fmt.Errorf("Syslog is not supported on Windows")
+}
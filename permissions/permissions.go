@@ -0,0 +1,128 @@
+package permissions
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// SELinuxRestorecon - Label files with the context the SELinux policy
+	// assigns to their path, like restorecon
+	SELinuxRestorecon = "restorecon"
+)
+
+// ErrReconcileUnsupported - Returned by Reconcile where permissions are
+// only set on install. Callers ignore it, it would show up every interval.
+var ErrReconcileUnsupported = errors.New("Reconciling permissions is not supported on this platform")
+
+// Attributes - Desired ownership and permissions of a managed file
+type Attributes struct {
+	User           string            // User name or UID (optional)
+	Group          string            // Group name or GID (optional)
+	Perms          os.FileMode       // Permissions (0 to leave unchanged)
+	ACL            []ACLEntry        // Named POSIX ACL entries (optional, Linux)
+	Xattrs         map[string]string // Extended attributes by name (optional, Linux)
+	SELinuxContext string            // SELinux context or SELinuxRestorecon (optional, Linux)
+	Target         string            // Installed path, whose policy context SELinuxRestorecon uses
+}
+
+// ACLEntry - Named user or group entry of a POSIX ACL
+type ACLEntry struct {
+	Group bool   // Group entry, otherwise user entry
+	Name  string // User or group name or numeric ID
+	Perms uint16 // rwx bits (4, 2, 1)
+}
+
+// ParseACL - Parse comma separated entries as u:name:rwx or g:name:rw
+// ("user" and "group" may be spelled out, "-" stands for a missing bit)
+func ParseACL(value string) ([]ACLEntry, error) {
+	var entries []ACLEntry
+
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) != 3 || parts[1] == "" {
+			return nil, fmt.Errorf("Invalid ACL entry %s (expected u:name:rwx or g:name:rwx)", item)
+		}
+
+		entry := ACLEntry{Name: parts[1]}
+		switch parts[0] {
+		case "u", "user":
+		case "g", "group":
+			entry.Group = true
+		default:
+			return nil, fmt.Errorf("Invalid ACL entry %s (expected u:name:rwx or g:name:rwx)", item)
+		}
+
+		for _, ch := range parts[2] {
+			switch ch {
+			case 'r':
+				entry.Perms |= 4
+			case 'w':
+				entry.Perms |= 2
+			case 'x':
+				entry.Perms |= 1
+			case '-':
+			default:
+				return nil, fmt.Errorf("Invalid ACL permissions %s in %s", parts[2], item)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// hasSecurity - Whether ACLs, extended attributes or an SELinux context are
+// configured
+func (a Attributes) hasSecurity() bool {
+	return len(a.ACL) > 0 || len(a.Xattrs) > 0 || a.SELinuxContext != ""
+}
+
+// expectedMode - Mode of a file with perms after the ACL is applied: the
+// group bits show the ACL mask, which covers the named entries
+func (a Attributes) expectedMode(perms os.FileMode) os.FileMode {
+	if len(a.ACL) == 0 {
+		return perms
+	}
+
+	mask := (perms >> 3) & 7
+	for _, entry := range a.ACL {
+		mask |= os.FileMode(entry.Perms)
+	}
+	return perms&^0070 | mask<<3
+}
+
+// SetTreePermissions will set ownership and permissions on root and
+// everything below it. Directories get perms plus search permission where
+// perms allows reading; with perms 0 the existing modes are kept.
+// Symbolic links are left alone.
+func SetTreePermissions(root string, userString string, groupString string, perms os.FileMode) error {
+	if userString == "" && groupString == "" && perms == 0 {
+		return nil
+	}
+
+	return filepath.Walk(root, func(path string, fileInfo os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if fileInfo.Mode()&os.ModeSymlink != 0 {
+			return nil
+		}
+
+		mode := fileInfo.Mode().Perm()
+		if perms != 0 {
+			mode = perms
+			if fileInfo.IsDir() {
+				mode |= (perms & 0444) >> 2
+			}
+		}
+		return SetFilePermissions(path, Attributes{User: userString, Group: groupString, Perms: mode})
+	})
+}
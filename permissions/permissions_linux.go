@@ -0,0 +1,307 @@
+//go:build linux
+// +build linux
+
+package permissions
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"ironsync/utils"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	aclXattr     = "system.posix_acl_access"
+	selinuxXattr = "security.selinux"
+
+	// POSIX ACL xattr format (linux/posix_acl_xattr.h)
+	aclVersion  = 2
+	aclUserObj  = 0x01
+	aclUser     = 0x02
+	aclGroupObj = 0x04
+	aclGroup    = 0x08
+	aclMask     = 0x10
+	aclOther    = 0x20
+	aclNoID     = 0xffffffff
+)
+
+// setSecurity - Set the ACL, extended attributes and SELinux context of
+// path. With onlyChanged attributes that are already right are not written
+// and the returned list names the ones that were corrected.
+func setSecurity(path string, a Attributes, onlyChanged bool) ([]string, error) {
+	var corrections []string
+
+	set := func(name string, value []byte, what string) error {
+		if onlyChanged {
+			current, err := getxattr(path, name)
+			if err != nil {
+				return err
+			}
+			if bytes.Equal(current, value) {
+				return nil
+			}
+			corrections = append(corrections, what)
+		}
+		return unix.Lsetxattr(path, name, value, 0)
+	}
+
+	if len(a.ACL) > 0 {
+		blob, err := aclBlob(a)
+		if err != nil {
+			return corrections, err
+		}
+		err = set(aclXattr, blob, "acl")
+		if err != nil {
+			return corrections, fmt.Errorf("Setting ACL failed: %v", err)
+		}
+	}
+
+	var names []string
+	for name := range a.Xattrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		err := set(name, []byte(a.Xattrs[name]), "xattr "+name)
+		if err != nil {
+			return corrections, fmt.Errorf("Setting xattr %s failed: %v", name, err)
+		}
+	}
+
+	if a.SELinuxContext != "" {
+		context := a.SELinuxContext
+		if context == SELinuxRestorecon {
+			var err error
+			context, err = policyContext(a.Target)
+			if err != nil {
+				return corrections, err
+			}
+		}
+
+		if context != "" {
+			current, err := getxattr(path, selinuxXattr)
+			if err != nil {
+				return corrections, err
+			}
+			currentContext := string(bytes.TrimRight(current, "\x00"))
+
+			if !onlyChanged || currentContext != context {
+				if onlyChanged {
+					corrections = append(corrections, fmt.Sprintf("selinux context %s -> %s", currentContext, context))
+				}
+				err = unix.Lsetxattr(path, selinuxXattr, append([]byte(context), 0), 0)
+				if err != nil {
+					return corrections, fmt.Errorf("Setting SELinux context %s failed: %v", context, err)
+				}
+			}
+		}
+	}
+	return corrections, nil
+}
+
+// getxattr - Value of an extended attribute, nil if it is not set
+func getxattr(path string, name string) ([]byte, error) {
+	for {
+		size, err := unix.Lgetxattr(path, name, nil)
+		if err == unix.ENODATA {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		value := make([]byte, size)
+		n, err := unix.Lgetxattr(path, name, value)
+		if err == unix.ERANGE {
+			// Grew in between
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		return value[:n], nil
+	}
+}
+
+// aclBlob - Access ACL in the kernel's xattr format: owner, group and other
+// from Perms, the named entries and a mask covering them
+func aclBlob(a Attributes) ([]byte, error) {
+	type aclID struct {
+		id    uint32
+		perms uint16
+	}
+	var users, groups []aclID
+
+	for _, entry := range a.ACL {
+		if entry.Group {
+			_, gid, err := utils.LookupOwner("", entry.Name)
+			if err != nil {
+				return nil, err
+			}
+			groups = append(groups, aclID{uint32(gid), entry.Perms})
+		} else {
+			uid, _, err := utils.LookupOwner(entry.Name, "")
+			if err != nil {
+				return nil, err
+			}
+			users = append(users, aclID{uint32(uid), entry.Perms})
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].id < users[j].id })
+	sort.Slice(groups, func(i, j int) bool { return groups[i].id < groups[j].id })
+
+	var b bytes.Buffer
+	write := func(tag uint16, perms uint16, id uint32) {
+		binary.Write(&b, binary.LittleEndian, tag)
+		binary.Write(&b, binary.LittleEndian, perms)
+		binary.Write(&b, binary.LittleEndian, id)
+	}
+
+	binary.Write(&b, binary.LittleEndian, uint32(aclVersion))
+	write(aclUserObj, uint16(a.Perms>>6)&7, aclNoID)
+	for _, u := range users {
+		write(aclUser, u.perms, u.id)
+	}
+	write(aclGroupObj, uint16(a.Perms>>3)&7, aclNoID)
+	for _, g := range groups {
+		write(aclGroup, g.perms, g.id)
+	}
+	write(aclMask, uint16(a.expectedMode(a.Perms)>>3)&7, aclNoID)
+	write(aclOther, uint16(a.Perms)&7, aclNoID)
+	return b.Bytes(), nil
+}
+
+// contextSpec - Line of the SELinux policy's file_contexts
+type contextSpec struct {
+	pattern string
+	context string
+	regexp  *regexp.Regexp
+	invalid bool // Not expressible in Go's syntax, never matches
+}
+
+// Policy file contexts, loaded once
+var (
+	policySpecs     []*contextSpec
+	policySpecsErr  error
+	policySpecsOnce sync.Once
+	policySpecsLock sync.Mutex
+)
+
+// selinuxEnabled - Whether the kernel enforces or logs SELinux
+func selinuxEnabled() bool {
+	_, err := os.Stat("/sys/fs/selinux/enforce")
+	return err == nil
+}
+
+// policyType - SELINUXTYPE from /etc/selinux/config
+func policyType() string {
+	f, err := os.Open("/etc/selinux/config")
+	if err != nil {
+		return "targeted"
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "SELINUXTYPE=") {
+			return strings.Trim(strings.TrimPrefix(line, "SELINUXTYPE="), "\"'")
+		}
+	}
+	return "targeted"
+}
+
+// loadSpecs - Regular file entries of the policy's file contexts, ordered so
+// that the last matching entry wins: entries with regular expressions first,
+// then literal paths, local customizations last within each group (as
+// libselinux does)
+func loadSpecs() ([]*contextSpec, error) {
+	dir := filepath.Join("/etc/selinux", policyType(), "contexts", "files")
+
+	var meta, literal []*contextSpec
+	for _, name := range []string{"file_contexts", "file_contexts.homedirs", "file_contexts.local"} {
+		f, err := os.Open(filepath.Join(dir, name))
+		if os.IsNotExist(err) && name != "file_contexts" {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+				continue
+			}
+
+			spec := &contextSpec{pattern: fields[0]}
+			switch len(fields) {
+			case 2:
+				spec.context = fields[1]
+			case 3:
+				// Only entries for regular files (or any file type) apply
+				if fields[1] != "--" {
+					continue
+				}
+				spec.context = fields[2]
+			default:
+				continue
+			}
+
+			if strings.ContainsAny(spec.pattern, ".^$?*+|[({") {
+				meta = append(meta, spec)
+			} else {
+				literal = append(literal, spec)
+			}
+		}
+		err = scanner.Err()
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return append(meta, literal...), nil
+}
+
+// policyContext - Context the SELinux policy assigns to the regular file
+// path, "" if SELinux is disabled or the policy does not label it
+func policyContext(path string) (string, error) {
+	if !selinuxEnabled() {
+		return "", nil
+	}
+
+	policySpecsOnce.Do(func() {
+		policySpecs, policySpecsErr = loadSpecs()
+	})
+	if policySpecsErr != nil {
+		return "", fmt.Errorf("Reading SELinux file contexts failed: %v", policySpecsErr)
+	}
+
+	policySpecsLock.Lock()
+	defer policySpecsLock.Unlock()
+
+	for i := len(policySpecs) - 1; i >= 0; i-- {
+		spec := policySpecs[i]
+		if spec.regexp == nil && !spec.invalid {
+			spec.regexp, _ = regexp.Compile("^(?:" + spec.pattern + ")$")
+			spec.invalid = spec.regexp == nil
+		}
+
+		if !spec.invalid && spec.regexp.MatchString(path) {
+			if spec.context == "<<none>>" {
+				return "", nil
+			}
+			return spec.context, nil
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,57 @@
+//go:build linux
+// +build linux
+
+package permissions
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestACLBlob(t *testing.T) {
+	a := Attributes{
+		Perms: 0640,
+		ACL: []ACLEntry{
+			{Group: true, Name: "20", Perms: 4},
+			{Name: "1002", Perms: 6},
+			{Name: "1001", Perms: 4},
+		},
+	}
+
+	blob, err := aclBlob(a)
+	if err != nil {
+		t.Fatalf("aclBlob() failed: %v", err)
+	}
+
+	// Entries in the order the kernel expects, named ones sorted by ID
+	want := []struct {
+		tag   uint16
+		perms uint16
+		id    uint32
+	}{
+		{aclUserObj, 6, aclNoID},
+		{aclUser, 4, 1001},
+		{aclUser, 6, 1002},
+		{aclGroupObj, 4, aclNoID},
+		{aclGroup, 4, 20},
+		{aclMask, 6, aclNoID},
+		{aclOther, 0, aclNoID},
+	}
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint32(aclVersion))
+	for _, e := range want {
+		binary.Write(&b, binary.LittleEndian, e.tag)
+		binary.Write(&b, binary.LittleEndian, e.perms)
+		binary.Write(&b, binary.LittleEndian, e.id)
+	}
+
+	if !bytes.Equal(blob, b.Bytes()) {
+		t.Errorf("aclBlob() = %x, want %x", blob, b.Bytes())
+	}
+
+	if _, err := aclBlob(Attributes{ACL: []ACLEntry{{Name: "ironsync-no-such-user"}}}); err == nil {
+		t.Errorf("aclBlob() with an unknown user succeeded")
+	}
+}
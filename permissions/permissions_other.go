@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package permissions
+
+import (
+	"fmt"
+)
+
+// setSecurity - ACLs, extended attributes and SELinux contexts are only
+// supported on Linux
+func setSecurity(path string, a Attributes, onlyChanged bool) ([]string, error) {
+	return nil, fmt.Errorf("ACLs, extended attributes and SELinux contexts are only supported on Linux")
+}
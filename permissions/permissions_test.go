@@ -0,0 +1,55 @@
+package permissions
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestParseACL(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    []ACLEntry
+		wantErr bool
+	}{
+		{value: "u:alice:rw", want: []ACLEntry{{Name: "alice", Perms: 6}}},
+		{value: "user:1001:r-x, g:admins:rwx,", want: []ACLEntry{{Name: "1001", Perms: 5}, {Group: true, Name: "admins", Perms: 7}}},
+		{value: "group:web:---", want: []ACLEntry{{Group: true, Name: "web"}}},
+		{value: "", want: nil},
+		{value: "o::r", wantErr: true},
+		{value: "u::rw", wantErr: true},
+		{value: "u:alice", wantErr: true},
+		{value: "u:alice:rwz", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := ParseACL(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseACL(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseACL(%q) = %+v, want %+v", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestExpectedMode(t *testing.T) {
+	tests := []struct {
+		acl   []ACLEntry
+		perms os.FileMode
+		want  os.FileMode
+	}{
+		{acl: nil, perms: 0640, want: 0640},
+		{acl: []ACLEntry{{Name: "alice", Perms: 6}}, perms: 0640, want: 0660},
+		{acl: []ACLEntry{{Group: true, Name: "web", Perms: 1}}, perms: 0604, want: 0614},
+		{acl: []ACLEntry{{Name: "alice", Perms: 0}}, perms: 0750, want: 0750},
+	}
+
+	for _, tt := range tests {
+		a := Attributes{Perms: tt.perms, ACL: tt.acl}
+		if got := a.expectedMode(tt.perms); got != tt.want {
+			t.Errorf("expectedMode(%04o) with %+v = %04o, want %04o", tt.perms, tt.acl, got, tt.want)
+		}
+	}
+}
@@ -1,51 +1,101 @@
+//go:build !windows
+// +build !windows
+
 package permissions
 
 import (
+	"fmt"
+	"ironsync/utils"
 	"os"
-	"os/user"
-	"strconv"
 )
 
-// SetFilePermissions will set file permissions on the srcPath
-func SetFilePermissions(srcPath string, userString string, groupString string, perms os.FileMode) (err error) {
-	err = os.Chmod(srcPath, perms)
+// SetFilePermissions will set file permissions on the srcPath. User and
+// group may be names or numeric IDs. The owner is set first, chown clears
+// the setuid and setgid bits. ACLs, extended attributes and the SELinux
+// context are set last.
+func SetFilePermissions(srcPath string, a Attributes) (err error) {
+	uid, gid, err := utils.LookupOwner(a.User, a.Group)
 	if err != nil {
-		return
+		return err
 	}
 
-	// User
-	uid := -1
-	if userString != "" {
-		u, err := user.Lookup(userString)
+	if uid != -1 || gid != -1 {
+		err = os.Chown(srcPath, uid, gid)
 		if err != nil {
 			return err
 		}
+	}
+
+	err = os.Chmod(srcPath, a.Perms)
+	if err != nil {
+		return
+	}
 
-		uid, err = strconv.Atoi(u.Uid)
+	if a.hasSecurity() {
+		_, err = setSecurity(srcPath, a, false)
+	}
+	return
+}
+
+// Reconcile - Correct the ownership and mode, including the setuid, setgid
+// and sticky bits, of path where they differ from a, returning what was
+// corrected. Symbolic links are left alone.
+func Reconcile(path string, a Attributes) (corrections []string, err error) {
+	fileInfo, err := os.Lstat(path)
+	if err != nil {
+		return nil, err
+	}
+	if fileInfo.Mode()&os.ModeSymlink != 0 {
+		return nil, nil
+	}
+
+	uid, gid, err := utils.LookupOwner(a.User, a.Group)
+	if err != nil {
+		return corrections, err
+	}
+
+	fileUID, fileGID := utils.FileOwner(fileInfo)
+	if uid == fileUID {
+		uid = -1
+	}
+	if gid == fileGID {
+		gid = -1
+	}
+
+	if uid != -1 || gid != -1 {
+		err = os.Chown(path, uid, gid)
 		if err != nil {
-			return err
+			return corrections, err
+		}
+		if uid != -1 {
+			corrections = append(corrections, fmt.Sprintf("owner %d -> %d", fileUID, uid))
+		}
+		if gid != -1 {
+			corrections = append(corrections, fmt.Sprintf("group %d -> %d", fileGID, gid))
 		}
-	}
 
-	// Group
-	gid := -1
-	if groupString != "" {
-		g, err := user.LookupGroup(groupString)
+		// The mode is compared after chown cleared the setuid and setgid bits
+		fileInfo, err = os.Lstat(path)
 		if err != nil {
-			return err
+			return corrections, err
 		}
+	}
 
-		gid, err = strconv.Atoi(g.Gid)
+	perms := a.expectedMode(a.Perms)
+	if a.Perms != 0 && fileInfo.Mode()&utils.ModeBits != perms&utils.ModeBits {
+		err = os.Chmod(path, perms)
 		if err != nil {
-			return err
+			return corrections, err
 		}
+		corrections = append(corrections, fmt.Sprintf("mode %s -> %s", utils.FormatMode(fileInfo.Mode()), utils.FormatMode(perms)))
 	}
 
-	if uid != -1 || gid != -1 {
-		err = os.Chown(srcPath, uid, gid)
+	if a.hasSecurity() {
+		securityCorrections, err := setSecurity(path, a, true)
+		corrections = append(corrections, securityCorrections...)
 		if err != nil {
-			return err
+			return corrections, err
 		}
 	}
-	return
+	return corrections, nil
 }
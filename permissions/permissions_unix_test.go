@@ -0,0 +1,121 @@
+//go:build !windows
+// +build !windows
+
+package permissions
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReconcile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	corrections, err := Reconcile(path, Attributes{Perms: 0600})
+	if err != nil {
+		t.Fatalf("Reconcile() failed: %v", err)
+	}
+	if want := []string{"mode 0644 -> 0600"}; !reflect.DeepEqual(corrections, want) {
+		t.Errorf("Reconcile() = %q, want %q", corrections, want)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Mode %v, want 0600", info.Mode())
+	}
+
+	// Nothing to correct, perms 0 leaves the mode alone
+	for _, a := range []Attributes{{Perms: 0600}, {}} {
+		corrections, err = Reconcile(path, a)
+		if err != nil || len(corrections) != 0 {
+			t.Errorf("Reconcile(%+v) = %q, %v, want no corrections", a, corrections, err)
+		}
+	}
+
+	// Links are not followed
+	link := filepath.Join(dir, "link")
+	if err := os.Symlink(path, link); err != nil {
+		t.Fatal(err)
+	}
+	corrections, err = Reconcile(link, Attributes{Perms: 0644})
+	if err != nil || len(corrections) != 0 {
+		t.Errorf("Reconcile() of a link = %q, %v, want no corrections", corrections, err)
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("Reconcile() of a link changed its target to %v", info.Mode())
+	}
+
+	if _, err := Reconcile(filepath.Join(dir, "missing"), Attributes{Perms: 0600}); err == nil {
+		t.Errorf("Reconcile() of a missing file succeeded")
+	}
+}
+
+func TestReconcileOwner(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("Changing owners needs root")
+	}
+
+	path := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(path, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Numeric IDs need no passwd entry
+	corrections, err := Reconcile(path, Attributes{User: "4321", Group: "4322"})
+	if err != nil {
+		t.Fatalf("Reconcile() failed: %v", err)
+	}
+	if want := []string{"owner 0 -> 4321", "group 0 -> 4322"}; !reflect.DeepEqual(corrections, want) {
+		t.Errorf("Reconcile() = %q, want %q", corrections, want)
+	}
+
+	corrections, err = Reconcile(path, Attributes{User: "4321"})
+	if err != nil || len(corrections) != 0 {
+		t.Errorf("Reconcile() again = %q, %v, want no corrections", corrections, err)
+	}
+}
+
+func TestReconcileSpecialBits(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "file")
+	if err := ioutil.WriteFile(path, []byte("x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	a := Attributes{Perms: 0755 | os.ModeSetuid}
+	corrections, err := Reconcile(path, a)
+	if err != nil {
+		t.Fatalf("Reconcile() failed: %v", err)
+	}
+	if want := []string{"mode 0755 -> 4755"}; !reflect.DeepEqual(corrections, want) {
+		t.Errorf("Reconcile() = %q, want %q", corrections, want)
+	}
+	if info, _ := os.Stat(path); info.Mode()&os.ModeSetuid == 0 {
+		t.Errorf("Mode %v, want setuid", info.Mode())
+	}
+
+	if os.Geteuid() != 0 {
+		return
+	}
+
+	// chown clears setuid and setgid, they are set again in the same run
+	a = Attributes{User: "4321", Group: "4322", Perms: 0755 | os.ModeSetuid | os.ModeSetgid}
+	for _, reconcile := range []func() error{
+		func() error { _, err := Reconcile(path, a); return err },
+		func() error { return SetFilePermissions(path, a) },
+	} {
+		if err := os.Chown(path, 0, 0); err != nil {
+			t.Fatal(err)
+		}
+		if err := reconcile(); err != nil {
+			t.Fatalf("Reconcile() failed: %v", err)
+		}
+		if info, _ := os.Stat(path); info.Mode()&(os.ModeSetuid|os.ModeSetgid) != os.ModeSetuid|os.ModeSetgid {
+			t.Errorf("Mode %v after changing the owner, want setuid and setgid", info.Mode())
+		}
+	}
+}
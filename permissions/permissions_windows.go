@@ -1,14 +1,20 @@
 package permissions
 
 import (
-	"os"
+	"fmt"
 
 	"github.com/hectane/go-acl"
 	"github.com/hectane/go-acl/api"
 )
 
 // SetFilePermissions will set file permissions on the srcPath
-func SetFilePermissions(srcPath string, userString string, groupString string, perms os.FileMode) (err error) {
+func SetFilePermissions(srcPath string, a Attributes) (err error) {
+	if a.hasSecurity() {
+		return fmt.Errorf("ACLs, extended attributes and SELinux contexts are not supported on Windows")
+	}
+
+	userString, groupString, perms := a.User, a.Group, a.Perms
+
 	var accessList []api.ExplicitAccess
 
 	if userString == "" {
@@ -32,3 +38,10 @@ func SetFilePermissions(srcPath string, userString string, groupString string, p
 
 	return
 }
+
+// Reconcile - Not supported on Windows, permissions are only set on
+// install. Returns ErrReconcileUnsupported rather than reporting drifted
+// permissions, ACLs and owners as compliant.
+func Reconcile(path string, a Attributes) (corrections []string, err error) {
+	return nil, ErrReconcileUnsupported
+}
@@ -0,0 +1,86 @@
+package render
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"ironsync/facts"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// TemplateGo - Go text/template
+	TemplateGo = "go"
+)
+
+// Data - Values available to a template: host facts as {{.hostname}} etc.,
+// user variables by name and the optional data file as {{.data}}
+func Data(vars facts.Vars, data interface{}) map[string]interface{} {
+	values := map[string]interface{}{}
+
+	for name, value := range facts.Get().Map() {
+		values[name] = value
+	}
+
+	for name := range vars {
+		// Variables may refer to other variables
+		value, err := vars.Expand("${" + name + "}")
+		if err == nil {
+			values[name] = value
+		}
+	}
+
+	if data != nil {
+		values["data"] = data
+	}
+	return values
+}
+
+// LoadData - Decode a JSON or YAML data file. The format is taken from
+// name (the remote path), path holds the content.
+func LoadData(path string, name string) (data interface{}, err error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return
+	}
+
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".json":
+		err = json.Unmarshal(content, &data)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &data)
+	default:
+		return nil, fmt.Errorf("Data file %s must be .json, .yaml or .yml", name)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Data file %s: %v", name, err)
+	}
+	return
+}
+
+// File - Render the template in path in place. Missing keys are errors.
+func File(path string, values map[string]interface{}) error {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	tmpl, err := template.New(filepath.Base(path)).Option("missingkey=error").Parse(string(content))
+	if err != nil {
+		return err
+	}
+
+	var b bytes.Buffer
+	err = tmpl.Execute(&b, values)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, b.Bytes(), 0600)
+}
@@ -0,0 +1,84 @@
+package render
+
+import (
+	"io/ioutil"
+	"ironsync/facts"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestFile(t *testing.T) {
+	dir := t.TempDir()
+
+	data := filepath.Join(dir, "data")
+	if err := ioutil.WriteFile(data, []byte("port: 8080\nhosts: [a, b]\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := LoadData(data, "/remote/data.yaml")
+	if err != nil {
+		t.Fatalf("LoadData() failed: %v", err)
+	}
+
+	values := Data(facts.Vars{"base": "/srv", "app": "${base}/app"}, d)
+
+	tests := []struct {
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{template: "os={{.os}}", want: "os=" + runtime.GOOS},
+		{template: "root={{.app}}", want: "root=/srv/app"},
+		{template: "port={{.data.port}}{{range .data.hosts}} {{.}}{{end}}", want: "port=8080 a b"},
+		{template: "{{.missing}}", wantErr: true},
+		{template: "{{.os", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		path := filepath.Join(dir, "file")
+		if err := ioutil.WriteFile(path, []byte(tt.template), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		err := File(path, values)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("File(%q) error = %v, want error %v", tt.template, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		got, _ := ioutil.ReadFile(path)
+		if string(got) != tt.want {
+			t.Errorf("File(%q) = %q, want %q", tt.template, got, tt.want)
+		}
+	}
+}
+
+func TestLoadData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+
+	if err := ioutil.WriteFile(path, []byte(`{"a": [1, 2]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	d, err := LoadData(path, "data.json")
+	if err != nil {
+		t.Fatalf("LoadData() of JSON failed: %v", err)
+	}
+	if m, ok := d.(map[string]interface{}); !ok || len(m["a"].([]interface{})) != 2 {
+		t.Errorf("LoadData() = %#v", d)
+	}
+
+	if _, err := LoadData(path, "data.yml"); err != nil {
+		t.Errorf("LoadData() of JSON as YAML failed: %v", err)
+	}
+	if _, err := LoadData(path, "data.txt"); err == nil {
+		t.Errorf("LoadData() of .txt succeeded")
+	}
+	if err := ioutil.WriteFile(path, []byte(`{"a": `), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := LoadData(path, "data.json"); err == nil {
+		t.Errorf("LoadData() of invalid JSON succeeded")
+	}
+}
@@ -1,39 +1,160 @@
 package resource
 
 import (
+	"context"
+	"ironsync/facts"
+	"ironsync/permissions"
+	"ironsync/secret"
 	"os"
 	"time"
 )
 
-const ()
+const (
+	// ModeReplace - The remote file replaces the local file
+	ModeReplace = "replace"
+	// ModeMerge - The remote file is merged into the local file
+	ModeMerge = "merge"
+	// ModeBlock - The remote file replaces a marked block in the local file
+	ModeBlock = "block"
+
+	// KindFile - A file downloaded from the connection
+	KindFile = "file"
+	// KindSymlink - A symbolic link to Target
+	KindSymlink = "symlink"
+	// KindDirectory - A directory
+	KindDirectory = "directory"
+	// KindAbsent - Nothing, the path is removed
+	KindAbsent = "absent"
+)
+
+// Downloader - Connection a resource or source is downloaded from
+type Downloader interface {
+	Download(ctx context.Context, r *Resource) (modified bool, path string, err error)
+}
+
+// Source - Remote file appended to the resource's own remote file
+type Source struct {
+	Connection     Downloader // Connection to download from
+	ConnectionName string     // Name of the connection
+	RemotePath     string     // Remote path (Gist file for Gist connections)
+	GistID         string     // Gist connections: GitHub Gist ID
+	GitHubUsername string     // Gist connections: GitHub Username
+}
 
 // Resource - Filesystem resource
 type Resource struct {
 	Path       string // Absolute file path
+	Home       string // Per-user resources: Home directory Path is below, links below it are not followed
+	Kind       string // What Path is (KindFile, KindSymlink, KindDirectory, KindAbsent)
 	RemotePath string // ConnectionTypeHTTP: If set, appended to the URL, ConnectionTypeGist: Gist file (optional) */
 	// Configuration
-	Interval                 int    // Seconds
-	RetryInterval            int    // Seconds
-	PreUpdateCommand         string // Command to run before updating resource
-	PreUpdateCommandTimeout  int    // Seconds
-	PostUpdateCommand        string // Command to run after updating resource
-	PostUpdateCommandTimeout int    // Seconds
-	GistID                   string // GitHub Gist ID (32 character hex string)
-	GitHubUsername           string // GitHub Username
-	GitHubToken              string // GitHub OAuth2 Token
+	Interval                 int        // Seconds
+	RetryInterval            int        // Seconds
+	PreUpdateCommand         string     // Command to run before updating resource
+	PreUpdateArgv            []string   // Program and arguments to run instead of PreUpdateCommand
+	PreUpdateCommandTimeout  int        // Seconds
+	PostUpdateCommand        string     // Command to run after updating resource
+	PostUpdateArgv           []string   // Program and arguments to run instead of PostUpdateCommand
+	PostUpdateCommandTimeout int        // Seconds
+	ValidateCommand          string     // Command to check the staged file before installing it
+	ValidateArgv             []string   // Program and arguments to run instead of ValidateCommand
+	ValidateCommandTimeout   int        // Seconds
+	HealthCommand            string     // Command to run after updating, restores the previous file on failure
+	HealthArgv               []string   // Program and arguments to run instead of HealthCommand
+	HealthCommandTimeout     int        // Seconds
+	HookUser                 string     // User to run commands as (optional)
+	HookGroup                string     // Group to run commands as (optional)
+	HookDir                  string     // Working directory of commands (optional)
+	GistID                   string     // GitHub Gist ID (32 character hex string)
+	GitHubUsername           string     // GitHub Username
+	GitHubToken              string     // GitHub OAuth2 Token
+	GitHubTokenRef           string     // Secret reference resolved into GitHubToken
+	BackupCount              int        // Number of backups to keep (0 for no limit)
+	BackupMaxAge             int        // Seconds to keep backups (0 for no limit)
+	BackupCompress           bool       // Gzip backups
+	Template                 string     // Template language the downloaded file is rendered with ("" for none)
+	TemplateData             string     // Remote path of a JSON or YAML data file for the template (optional)
+	Mode                     string     // How the remote file is installed (ModeReplace, ModeMerge, ModeBlock)
+	MergeFormat              string     // ModeMerge: Format of the local file (json, yaml, ini)
+	MergeStrategy            string     // ModeMerge: How the remote fragment is applied
+	BlockMarker              string     // ModeBlock: Marker line template, {mark} is BEGIN or END
+	Sources                  []Source   // Remote files appended in order (optional)
+	Dedup                    bool       // Drop repeated lines of the assembled file
+	Header                   string     // Line put at the top of the assembled file (optional)
+	Drift                    string     // What to do about local changes ("" to not watch the file)
+	Target                   string     // KindSymlink: Path the link points to
+	Purge                    bool       // KindDirectory: Remove entries that are not resources
+	Managed                  []string   // KindDirectory: Resource paths below Path, kept by Purge
+	Recursive                bool       // KindAbsent: Also remove a directory that is not empty
+	Decompress               string     // Compression format of the remote file ("" for none)
+	Extract                  string     // Archive format unpacked into Path ("" for a plain file)
+	StripComponents          int        // Leading path components dropped from archive entries
+	SyncGroup                string     // Resources installed together, all or nothing ("" for none)
+	Vars                     facts.Vars // User-defined variables
+	// Other paths the download is installed to, with their own settings
+	Copies []*Resource
+	// Resources installed before this one
+	DependsOn []*Resource
 	// File attributes
-	User  string      // User for UID
-	Group string      // Group for GID
-	Perms os.FileMode // File permissions
+	User           string                 // User for UID
+	Group          string                 // Group for GID
+	Perms          os.FileMode            // File permissions
+	DirPerms       os.FileMode            // Permissions of missing parent directories
+	ACL            []permissions.ACLEntry // Named POSIX ACL entries
+	Xattrs         map[string]string      // Extended attributes
+	SELinuxContext string                 // SELinux context or permissions.SELinuxRestorecon
 	// State
 	NextUpdateTime   time.Time // Time of next update
 	LastUpdateTime   time.Time // Time of last successful update (not accurate)
 	LastModifiedTime time.Time // Last modified time on the server (accurate)
+	Failing          bool      // Last update failed
 }
 
 // CreateResource - Create a new resource object
 func CreateResource(path string) Resource {
-	return Resource{path, "", 60, 30, "", 10, "", 10, "", "", "", "", "", 0, time.Time{}, time.Time{}, time.Time{}}
+	return Resource{
+		Path:                     path,
+		Kind:                     KindFile,
+		Interval:                 60,
+		RetryInterval:            30,
+		PreUpdateCommandTimeout:  10,
+		PostUpdateCommandTimeout: 10,
+		ValidateCommandTimeout:   10,
+		HealthCommandTimeout:     10,
+		DirPerms:                 0755,
+		Mode:                     ModeReplace,
+	}
+}
+
+// Attributes - File attributes to install the resource with, using perms
+// as permissions
+func (r *Resource) Attributes(perms os.FileMode) permissions.Attributes {
+	return permissions.Attributes{
+		User:           r.User,
+		Group:          r.Group,
+		Perms:          perms,
+		ACL:            r.ACL,
+		Xattrs:         r.Xattrs,
+		SELinuxContext: r.SELinuxContext,
+		Target:         r.Path,
+	}
+}
+
+// BackupEnabled - Whether replaced files should be backed up
+func (r *Resource) BackupEnabled() bool {
+	return r.BackupCount > 0 || r.BackupMaxAge > 0
+}
+
+// ResolveSecrets - Look up secret references again
+func (r *Resource) ResolveSecrets() error {
+	if r.GitHubTokenRef != "" {
+		gitHubToken, err := secret.Resolve(r.GitHubTokenRef)
+		if err != nil {
+			return err
+		}
+		r.GitHubToken = gitHubToken
+	}
+	return nil
 }
 
 // SetNextUpdateTime - Set next update to given interval
@@ -0,0 +1,108 @@
+package secret
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"ironsync/utils"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// CommandTimeout - Seconds a cmd: reference may run
+	CommandTimeout = 10
+)
+
+// schemes - Reference prefixes and their resolvers
+var schemes = map[string]func(string) (string, error){
+	"env":  resolveEnv,
+	"file": resolveFile,
+	"cmd":  resolveCmd,
+	"cred": resolveCred,
+}
+
+// split - Scheme and argument of a reference, ok is false for plain values
+func split(value string) (scheme string, arg string, ok bool) {
+	i := strings.Index(value, ":")
+	if i < 0 {
+		return "", "", false
+	}
+
+	scheme = value[:i]
+	if _, known := schemes[scheme]; !known {
+		return "", "", false
+	}
+	return scheme, value[i+1:], true
+}
+
+// IsReference - Whether value refers to a secret (env:, file:, cmd: or cred:)
+func IsReference(value string) bool {
+	_, _, ok := split(value)
+	return ok
+}
+
+// Resolve - Look up the secret a reference points to. Plain values are
+// returned unchanged. Errors name the reference but never the secret.
+func Resolve(value string) (string, error) {
+	scheme, arg, ok := split(value)
+	if !ok {
+		return value, nil
+	}
+
+	if arg == "" {
+		return "", fmt.Errorf("Empty secret reference %s", value)
+	}
+
+	secret, err := schemes[scheme](arg)
+	if err != nil {
+		return "", fmt.Errorf("Secret %s: %v", value, err)
+	}
+	return secret, nil
+}
+
+// resolveEnv - env:NAME, an environment variable
+func resolveEnv(name string) (string, error) {
+	secret, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("Not set")
+	}
+	return secret, nil
+}
+
+// resolveFile - file:/path, the content of a file without trailing newlines
+func resolveFile(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// resolveCmd - cmd:command, the first line printed by a shell command
+func resolveCmd(cmd string) (string, error) {
+	// Stderr is not captured, so it cannot end up in errors or logs. The
+	// process group is killed on timeout, children left running in the
+	// background are not waited for.
+	command := utils.Command{Command: cmd, Timeout: CommandTimeout, Stderr: ioutil.Discard}
+	output, err := command.Run(context.Background())
+	if err != nil {
+		return "", err
+	}
+
+	return strings.SplitN(strings.TrimRight(string(output), "\r\n"), "\n", 2)[0], nil
+}
+
+// resolveCred - cred:name, a systemd credential ($CREDENTIALS_DIRECTORY)
+func resolveCred(name string) (string, error) {
+	dir := os.Getenv("CREDENTIALS_DIRECTORY")
+	if dir == "" {
+		return "", fmt.Errorf("CREDENTIALS_DIRECTORY not set")
+	}
+
+	if strings.Contains(name, "/") {
+		return "", fmt.Errorf("Invalid credential name")
+	}
+	return resolveFile(filepath.Join(dir, name))
+}
@@ -0,0 +1,90 @@
+package secret
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "pass"), []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("IRONSYNC_TEST_SECRET", "from env")
+	t.Setenv("CREDENTIALS_DIRECTORY", dir)
+
+	tests := []struct {
+		value   string
+		want    string
+		wantErr bool
+	}{
+		{value: "plain", want: "plain"},
+		{value: "https://example.com", want: "https://example.com"},
+		{value: "env:IRONSYNC_TEST_SECRET", want: "from env"},
+		{value: "env:IRONSYNC_TEST_UNSET", wantErr: true},
+		{value: "env:", wantErr: true},
+		{value: "file:" + filepath.Join(dir, "pass"), want: "s3cret"},
+		{value: "file:" + filepath.Join(dir, "missing"), wantErr: true},
+		{value: "cmd:printf 'first\\nsecond\\n'", want: "first"},
+		{value: "cmd:echo $IRONSYNC_TEST_SECRET; exit 1", wantErr: true},
+		{value: "cmd:echo noise >&2; echo quiet", want: "quiet"},
+		{value: "cred:pass", want: "s3cret"},
+		{value: "cred:../pass", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := Resolve(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Resolve(%q) error = %v, want error %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if err != nil {
+			if !strings.Contains(err.Error(), tt.value) || strings.Contains(err.Error(), "from env") {
+				t.Errorf("Resolve(%q) error %q should name the reference only", tt.value, err)
+			}
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("Resolve(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestResolveCmdBackground(t *testing.T) {
+	// The backgrounded sleep keeps stdout open but is not waited for
+	start := time.Now()
+	got, err := Resolve("cmd:sleep 5 & echo s3cret")
+	if err != nil || got != "s3cret" {
+		t.Errorf("Resolve() = %q, %v, want s3cret", got, err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Resolve() took %v, waited for the background process", elapsed)
+	}
+}
+
+func TestResolveCredWithoutDirectory(t *testing.T) {
+	t.Setenv("CREDENTIALS_DIRECTORY", "")
+	if _, err := Resolve("cred:pass"); err == nil {
+		t.Errorf("Resolve() without CREDENTIALS_DIRECTORY succeeded")
+	}
+}
+
+func TestIsReference(t *testing.T) {
+	for value, want := range map[string]bool{
+		"env:X":     true,
+		"file:/a":   true,
+		"cmd:true":  true,
+		"cred:x":    true,
+		"plain":     false,
+		"http://x":  false,
+		"pass:word": false,
+		"":          false,
+	} {
+		if got := IsReference(value); got != want {
+			t.Errorf("IsReference(%q) = %v, want %v", value, got, want)
+		}
+	}
+}
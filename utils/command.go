@@ -0,0 +1,207 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const (
+	// MaxCommandOutput - Bytes of command output kept for logging
+	MaxCommandOutput = 64 * 1024
+
+	// outputDelay - Time to keep reading output after the command exited.
+	// Processes it started in the background may hold on to the output
+	// forever, e.g. daemons.
+	outputDelay = 100 * time.Millisecond
+)
+
+// Command - External command run as a hook
+type Command struct {
+	Command string    // Shell command, run with sh -c
+	Argv    []string  // Program and arguments, run without a shell (used if Command is empty)
+	User    string    // Run as user, name or UID (optional)
+	Group   string    // Run as group, name or GID (optional)
+	Dir     string    // Working directory (optional)
+	Env     []string  // Environment added to ironsync's own (KEY=VALUE)
+	Timeout int       // Seconds
+	Stderr  io.Writer // Receives stderr instead of the output (optional)
+}
+
+// limitedBuffer - Buffer that keeps only the first MaxCommandOutput bytes.
+// The buffer is not embedded, its ReadFrom would bypass the limit in
+// io.Copy.
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	n := len(p)
+	if room := MaxCommandOutput - b.buf.Len(); len(p) > room {
+		p = p[:room]
+		b.truncated = true
+	}
+	b.buf.Write(p)
+	return n, nil
+}
+
+// Output - Captured output, marked if it was truncated
+func (b *limitedBuffer) Output() []byte {
+	if b.truncated {
+		return append(b.buf.Bytes(), []byte("\n[output truncated]")...)
+	}
+	return b.buf.Bytes()
+}
+
+// ShellQuote - Quote s as a single sh word
+func ShellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// expandTemplate - Expand a template such as "sshd -t -f {{.TmpPath}}"
+func expandTemplate(text string, fields map[string]string) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+
+	tmpl, err := template.New("cmd").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	err = tmpl.Execute(&b, fields)
+	if err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// Expand - Expand templates in the command and its arguments. Values are
+// shell quoted in Command, so a path cannot inject shell syntax, and used as
+// they are in Argv.
+func (c *Command) Expand(fields map[string]string) (err error) {
+	quoted := make(map[string]string, len(fields))
+	for k, v := range fields {
+		quoted[k] = ShellQuote(v)
+	}
+
+	c.Command, err = expandTemplate(c.Command, quoted)
+	if err != nil {
+		return
+	}
+
+	argv := make([]string, len(c.Argv))
+	for i, arg := range c.Argv {
+		argv[i], err = expandTemplate(arg, fields)
+		if err != nil {
+			return
+		}
+	}
+	c.Argv = argv
+	return
+}
+
+// Run - Run the command and return its combined stdout and stderr, only
+// stdout if Stderr is set. The
+// whole process group is killed on timeout or when ctx is cancelled.
+// Processes left running in the background once the command exited are
+// not waited for and keep running.
+func (c *Command) Run(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, time.Duration(c.Timeout)*time.Second)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if c.Command != "" {
+		cmd = exec.Command("sh", "-c", c.Command)
+	} else if len(c.Argv) > 0 {
+		cmd = exec.Command(c.Argv[0], c.Argv[1:]...)
+	} else {
+		return nil, fmt.Errorf("Empty command")
+	}
+
+	var output limitedBuffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	if c.Stderr != nil {
+		cmd.Stderr = c.Stderr
+	}
+	cmd.WaitDelay = outputDelay
+	cmd.Dir = c.Dir
+	cmd.Env = append(os.Environ(), c.Env...)
+
+	err := setProcessAttributes(cmd, c.User, c.Group)
+	if err != nil {
+		return nil, err
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err = <-done:
+		if errors.Is(err, exec.ErrWaitDelay) {
+			// Exited successfully, a background process kept the output open
+			err = nil
+		}
+		return output.Output(), err
+	case <-ctx.Done():
+		killProcessGroup(cmd)
+		<-done
+		return output.Output(), ctx.Err()
+	}
+}
+
+// SplitArgs - Split a command line into arguments. Whitespace separates
+// arguments unless it is inside single or double quotes.
+func SplitArgs(s string) (args []string, err error) {
+	var arg strings.Builder
+	inArg := false
+	quote := rune(0)
+
+	for _, ch := range s {
+		switch {
+		case quote != 0:
+			if ch == quote {
+				quote = 0
+			} else {
+				arg.WriteRune(ch)
+			}
+		case ch == '\'' || ch == '"':
+			quote = ch
+			inArg = true
+		case ch == ' ' || ch == '\t' || ch == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(ch)
+			inArg = true
+		}
+	}
+
+	if quote != 0 {
+		return nil, fmt.Errorf("Unterminated quote in %s", s)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return
+}
@@ -0,0 +1,157 @@
+package utils
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandRun(t *testing.T) {
+	tests := []struct {
+		name    string
+		cmd     Command
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "shell command",
+			cmd:  Command{Command: "echo one; echo two >&2", Timeout: 5},
+			want: "one\ntwo\n",
+		},
+		{
+			name: "argv without a shell",
+			cmd:  Command{Argv: []string{"echo", "$HOME", "a b"}, Timeout: 5},
+			want: "$HOME a b\n",
+		},
+		{
+			name: "environment",
+			cmd:  Command{Command: `echo "$IRONSYNC_PATH"`, Env: []string{"IRONSYNC_PATH=/etc/a b"}, Timeout: 5},
+			want: "/etc/a b\n",
+		},
+		{
+			name: "working directory",
+			cmd:  Command{Argv: []string{"pwd"}, Dir: "/", Timeout: 5},
+			want: "/\n",
+		},
+		{
+			name:    "exit status",
+			cmd:     Command{Command: "echo failed; exit 3", Timeout: 5},
+			want:    "failed\n",
+			wantErr: true,
+		},
+		{
+			name:    "empty",
+			cmd:     Command{Timeout: 5},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			output, err := tt.cmd.Run(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Run() error = %v, want error %v", err, tt.wantErr)
+			}
+			if string(output) != tt.want {
+				t.Errorf("Run() output = %q, want %q", output, tt.want)
+			}
+		})
+	}
+}
+
+func TestCommandRunTimeout(t *testing.T) {
+	// The sleep started by the shell is killed with its process group
+	cmd := Command{Command: "sleep 10 & sleep 10", Timeout: 1}
+
+	start := time.Now()
+	_, err := cmd.Run(context.Background())
+	if err != context.DeadlineExceeded {
+		t.Errorf("Run() error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Run() took %v after the timeout", elapsed)
+	}
+}
+
+func TestCommandRunOutputLimit(t *testing.T) {
+	cmd := Command{Command: "head -c 100000 /dev/zero", Timeout: 5}
+
+	output, err := cmd.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasSuffix(string(output), "[output truncated]") || len(output) > MaxCommandOutput+100 {
+		t.Errorf("Run() kept %d bytes, want %d and a truncation mark", len(output), MaxCommandOutput)
+	}
+}
+
+func TestSplitArgs(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    []string
+		wantErr bool
+	}{
+		{s: "systemctl reload nginx", want: []string{"systemctl", "reload", "nginx"}},
+		{s: "  sh\t-c  'echo a  b' ", want: []string{"sh", "-c", "echo a  b"}},
+		{s: `printf "it's" ''`, want: []string{"printf", "it's", ""}},
+		{s: "", want: nil},
+		{s: "echo 'open", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		got, err := SplitArgs(tt.s)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("SplitArgs(%q) error = %v, want error %v", tt.s, err, tt.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitArgs(%q) = %q, want %q", tt.s, got, tt.want)
+		}
+	}
+}
+
+func TestCommandExpand(t *testing.T) {
+	fields := map[string]string{"TmpPath": "/tmp/a b'; touch x", "Path": "/etc/app.conf"}
+
+	c := Command{
+		Command: "check -f {{.TmpPath}} {{.Path}}",
+		Argv:    []string{"check", "-f", "{{.TmpPath}}", "{{.Path}}"},
+	}
+	if err := c.Expand(fields); err != nil {
+		t.Fatalf("Expand() failed: %v", err)
+	}
+
+	if want := `check -f '/tmp/a b'\''; touch x' '/etc/app.conf'`; c.Command != want {
+		t.Errorf("Command = %q, want %q", c.Command, want)
+	}
+	if want := []string{"check", "-f", "/tmp/a b'; touch x", "/etc/app.conf"}; !reflect.DeepEqual(c.Argv, want) {
+		t.Errorf("Argv = %q, want %q", c.Argv, want)
+	}
+
+	// The quoted value reaches the shell as one word
+	c = Command{Command: "printf '%s|' {{.TmpPath}}", Timeout: 5}
+	if err := c.Expand(fields); err != nil {
+		t.Fatalf("Expand() failed: %v", err)
+	}
+	output, err := c.Run(context.Background())
+	if err != nil || string(output) != "/tmp/a b'; touch x|" {
+		t.Errorf("Run() = %q, %v", output, err)
+	}
+
+	c = Command{Command: "echo {{.Missing}}"}
+	if err := c.Expand(fields); err == nil {
+		t.Errorf("Expand() with an unknown field succeeded")
+	}
+
+	c = Command{Command: "echo {{.Path}} '{{'"}
+	if err := c.Expand(fields); err == nil {
+		t.Errorf("Expand() of an invalid template succeeded")
+	}
+
+	c = Command{Command: "echo $HOME"}
+	if err := c.Expand(fields); err != nil || c.Command != "echo $HOME" {
+		t.Errorf("Expand() without templates = %q, %v", c.Command, err)
+	}
+}
@@ -0,0 +1,18 @@
+//go:build linux
+// +build linux
+
+package utils
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// exchange - Atomically swap the directories src and dst. Returns false if
+// the filesystem cannot exchange them.
+func exchange(src, dst string) (bool, error) {
+	err := unix.Renameat2(unix.AT_FDCWD, src, unix.AT_FDCWD, dst, unix.RENAME_EXCHANGE)
+	if err == unix.EINVAL || err == unix.ENOSYS {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package utils
+
+// exchange - Atomic swaps are only supported on Linux
+func exchange(src, dst string) (bool, error) {
+	return false, nil
+}
@@ -0,0 +1,375 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// HomeDir - Directory below a user's home directory, opened without
+// following symbolic links. Files are created, replaced and corrected
+// relative to it, so the user cannot redirect them elsewhere by swapping in
+// links while ironsync runs as root.
+type HomeDir struct {
+	f   *os.File
+	uid int // Owner of what is installed
+	gid int // Group of what is installed
+}
+
+// OpenHomeDir - Open dir, relative to home, without following links. Each
+// directory on the way must be owned by uid or root. Missing ones are
+// created with perms, owned by uid and gid, if create is set.
+func OpenHomeDir(home string, dir string, uid int, gid int, perms os.FileMode, create bool) (*HomeDir, error) {
+	fd, err := unix.Open(home, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: home, Err: err}
+	}
+	d := &HomeDir{f: os.NewFile(uintptr(fd), home), uid: uid, gid: gid}
+
+	for _, name := range strings.Split(filepath.Clean(dir), "/") {
+		err = d.checkOwner()
+		if err == nil && name == ".." {
+			err = fmt.Errorf("Not below %s: %s", home, dir)
+		}
+		if err != nil {
+			d.Close()
+			return nil, err
+		}
+		if name == "." || name == "" {
+			continue
+		}
+
+		next, err := d.openDir(name, perms, create)
+		d.Close()
+		if err != nil {
+			return nil, err
+		}
+		d = next
+	}
+
+	err = d.checkOwner()
+	if err != nil {
+		d.Close()
+		return nil, err
+	}
+	return d, nil
+}
+
+// openDir - Open the directory name, creating it if create is set
+func (d *HomeDir) openDir(name string, perms os.FileMode, create bool) (*HomeDir, error) {
+	path := filepath.Join(d.Name(), name)
+	flags := unix.O_RDONLY | unix.O_DIRECTORY | unix.O_NOFOLLOW | unix.O_CLOEXEC
+
+	fd, err := unix.Openat(d.fd(), name, flags, 0)
+	if err == unix.ENOENT && create {
+		err = unix.Mkdirat(d.fd(), name, uint32(perms.Perm()))
+		if err != nil && err != unix.EEXIST {
+			return nil, &os.PathError{Op: "mkdir", Path: path, Err: err}
+		}
+		fd, err = unix.Openat(d.fd(), name, flags, 0)
+		if err == nil {
+			err = fixOwner(fd, d.uid, d.gid, perms)
+		}
+	}
+	if err != nil {
+		if fd >= 0 {
+			unix.Close(fd)
+		}
+		return nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+	return &HomeDir{f: os.NewFile(uintptr(fd), path), uid: d.uid, gid: d.gid}, nil
+}
+
+// fixOwner - Give a directory just created by root to uid and gid. One
+// the user put there instead keeps its owner.
+func fixOwner(fd int, uid int, gid int, perms os.FileMode) error {
+	var stat unix.Stat_t
+	err := unix.Fstat(fd, &stat)
+	if err != nil || stat.Uid != 0 {
+		return err
+	}
+
+	err = unix.Fchown(fd, uid, gid)
+	if err != nil {
+		return err
+	}
+	return unix.Fchmod(fd, uint32(perms.Perm()))
+}
+
+// checkOwner - Make sure the directory is owned by the user or root
+func (d *HomeDir) checkOwner() error {
+	fileInfo, err := d.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	uid, _ := FileOwner(fileInfo)
+	if uid != d.uid && uid != 0 {
+		return fmt.Errorf("Not owned by user %d or root: %s", d.uid, d.Name())
+	}
+	return nil
+}
+
+// fd - File descriptor of the directory
+func (d *HomeDir) fd() int {
+	return int(d.f.Fd())
+}
+
+// Name - Path of the directory
+func (d *HomeDir) Name() string {
+	return d.f.Name()
+}
+
+// Close - Close the directory
+func (d *HomeDir) Close() error {
+	return d.f.Close()
+}
+
+// open - Open name read-only without following a link. Returns nil if it
+// is missing or something other than a directory or a regular file without
+// other hard links, which is never read or changed.
+func (d *HomeDir) open(name string) (*os.File, os.FileInfo, error) {
+	path := filepath.Join(d.Name(), name)
+
+	fd, err := unix.Openat(d.fd(), name, unix.O_RDONLY|unix.O_NOFOLLOW|unix.O_NONBLOCK|unix.O_CLOEXEC, 0)
+	if err == unix.ENOENT || err == unix.ELOOP {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, &os.PathError{Op: "open", Path: path, Err: err}
+	}
+
+	f := os.NewFile(uintptr(fd), path)
+	fileInfo, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+
+	stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+	if fileInfo.IsDir() || (fileInfo.Mode().IsRegular() && ok && stat.Nlink == 1) {
+		return f, fileInfo, nil
+	}
+	f.Close()
+	return nil, nil, nil
+}
+
+// Hash - Hex encoded SHA-256 of the regular file name, "" if it is missing
+// or cannot be read
+func (d *HomeDir) Hash(name string) string {
+	f, fileInfo, err := d.open(name)
+	if err != nil || f == nil {
+		return ""
+	}
+	defer f.Close()
+	if !fileInfo.Mode().IsRegular() {
+		return ""
+	}
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Mode - Permissions of the regular file name, 0 if it is missing
+func (d *HomeDir) Mode(name string) os.FileMode {
+	f, fileInfo, err := d.open(name)
+	if err != nil || f == nil {
+		return 0
+	}
+	f.Close()
+	if !fileInfo.Mode().IsRegular() {
+		return 0
+	}
+	return fileInfo.Mode().Perm()
+}
+
+// createTemp - Create a temp file for name, returning its name
+func (d *HomeDir) createTemp(name string) (string, *os.File, error) {
+	for i := 0; i < 10000; i++ {
+		tmpName := "." + name + ".ironsync" + strconv.Itoa(int(rand.Uint32()))
+
+		fd, err := unix.Openat(d.fd(), tmpName, unix.O_WRONLY|unix.O_CREAT|unix.O_EXCL|unix.O_NOFOLLOW|unix.O_CLOEXEC, 0600)
+		if err == unix.EEXIST {
+			continue
+		} else if err != nil {
+			return "", nil, &os.PathError{Op: "open", Path: filepath.Join(d.Name(), tmpName), Err: err}
+		}
+		return tmpName, os.NewFile(uintptr(fd), filepath.Join(d.Name(), tmpName)), nil
+	}
+	return "", nil, fmt.Errorf("No temp file name left for %s", filepath.Join(d.Name(), name))
+}
+
+// replace - Rename tmpName over name and flush the directory, removing
+// tmpName on errors
+func (d *HomeDir) replace(tmpName string, name string) error {
+	err := unix.Renameat(d.fd(), tmpName, d.fd(), name)
+	if err != nil {
+		unix.Unlinkat(d.fd(), tmpName, 0)
+		return &os.PathError{Op: "rename", Path: filepath.Join(d.Name(), name), Err: err}
+	}
+	return d.f.Sync()
+}
+
+// Install - Atomically replace name with a copy of src, owned by the user
+// with perms. Whatever name is, other than a directory, is replaced and
+// never followed.
+func (d *HomeDir) Install(name string, src string, perms os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	tmpName, out, err := d.createTemp(name)
+	if err != nil {
+		return err
+	}
+
+	// chown clears the setuid and setgid bits, the mode is set after it
+	_, err = io.Copy(out, in)
+	if err == nil {
+		err = out.Chown(d.uid, d.gid)
+	}
+	if err == nil {
+		err = out.Chmod(perms)
+	}
+	if err == nil {
+		err = out.Sync()
+	}
+	closeErr := out.Close()
+	if err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		unix.Unlinkat(d.fd(), tmpName, 0)
+		return err
+	}
+
+	return d.replace(tmpName, name)
+}
+
+// Mkdir - Create the directory name with perms, owned by the user. Returns
+// false if it exists.
+func (d *HomeDir) Mkdir(name string, perms os.FileMode) (bool, error) {
+	err := unix.Mkdirat(d.fd(), name, uint32(perms.Perm()))
+	if err == unix.EEXIST {
+		var stat unix.Stat_t
+		err = unix.Fstatat(d.fd(), name, &stat, unix.AT_SYMLINK_NOFOLLOW)
+		if err == nil && stat.Mode&unix.S_IFMT != unix.S_IFDIR {
+			err = fmt.Errorf("Not a directory: %s", filepath.Join(d.Name(), name))
+		}
+		return false, err
+	} else if err != nil {
+		return false, &os.PathError{Op: "mkdir", Path: filepath.Join(d.Name(), name), Err: err}
+	}
+
+	next, err := d.openDir(name, perms, false)
+	if err != nil {
+		return true, err
+	}
+	defer next.Close()
+	return true, fixOwner(next.fd(), d.uid, d.gid, perms)
+}
+
+// Symlink - Point the link name at target, owned by the user, replacing a
+// link or file. Returns false if it already points there.
+func (d *HomeDir) Symlink(name string, target string) (bool, error) {
+	path := filepath.Join(d.Name(), name)
+
+	var stat unix.Stat_t
+	err := unix.Fstatat(d.fd(), name, &stat, unix.AT_SYMLINK_NOFOLLOW)
+	if err == nil {
+		switch stat.Mode & unix.S_IFMT {
+		case unix.S_IFLNK:
+			buf := make([]byte, unix.PathMax)
+			n, err := unix.Readlinkat(d.fd(), name, buf)
+			if err == nil && string(buf[:n]) == target {
+				if int(stat.Uid) == d.uid && int(stat.Gid) == d.gid {
+					return false, nil
+				}
+				return false, unix.Fchownat(d.fd(), name, d.uid, d.gid, unix.AT_SYMLINK_NOFOLLOW)
+			}
+		case unix.S_IFDIR:
+			return false, fmt.Errorf("Not replacing directory %s with a symbolic link", path)
+		}
+	} else if err != unix.ENOENT {
+		return false, &os.PathError{Op: "lstat", Path: path, Err: err}
+	}
+
+	// Reserve a temp name, then put the link there
+	tmpName, tmpFile, err := d.createTemp(name)
+	if err != nil {
+		return false, err
+	}
+	tmpFile.Close()
+	unix.Unlinkat(d.fd(), tmpName, 0)
+
+	err = unix.Symlinkat(target, d.fd(), tmpName)
+	if err != nil {
+		return false, &os.PathError{Op: "symlink", Path: path, Err: err}
+	}
+
+	err = unix.Fchownat(d.fd(), tmpName, d.uid, d.gid, unix.AT_SYMLINK_NOFOLLOW)
+	if err != nil {
+		unix.Unlinkat(d.fd(), tmpName, 0)
+		return false, &os.PathError{Op: "lchown", Path: path, Err: err}
+	}
+	return true, d.replace(tmpName, name)
+}
+
+// Reconcile - Correct the mode (unless perms is 0) and ownership of name,
+// "." for the directory itself, returning what was corrected. Links,
+// missing names and files with other hard links are left alone.
+func (d *HomeDir) Reconcile(name string, perms os.FileMode) ([]string, error) {
+	f, fileInfo, err := d.open(name)
+	if err != nil || f == nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var corrections []string
+
+	fileUID, fileGID := FileOwner(fileInfo)
+	if fileUID != d.uid || fileGID != d.gid {
+		err = f.Chown(d.uid, d.gid)
+		if err != nil {
+			return corrections, err
+		}
+		if fileUID != d.uid {
+			corrections = append(corrections, fmt.Sprintf("owner %d -> %d", fileUID, d.uid))
+		}
+		if fileGID != d.gid {
+			corrections = append(corrections, fmt.Sprintf("group %d -> %d", fileGID, d.gid))
+		}
+
+		// The mode is compared after chown cleared the setuid and setgid bits
+		fileInfo, err = f.Stat()
+		if err != nil {
+			return corrections, err
+		}
+	}
+
+	if perms != 0 && fileInfo.Mode()&ModeBits != perms&ModeBits {
+		err = f.Chmod(perms & ModeBits)
+		if err != nil {
+			return corrections, err
+		}
+		corrections = append(corrections, fmt.Sprintf("mode %s -> %s", FormatMode(fileInfo.Mode()), FormatMode(perms)))
+	}
+	return corrections, nil
+}
@@ -0,0 +1,132 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const (
+	testUID = 4321
+	testGID = 4322
+)
+
+// createHome - Home directory owned by the test user
+func createHome(t *testing.T) string {
+	t.Helper()
+
+	if os.Geteuid() != 0 {
+		t.Skip("Installing into home directories needs root")
+	}
+
+	home := t.TempDir()
+	if err := os.Chown(home, testUID, testGID); err != nil {
+		t.Fatal(err)
+	}
+	return home
+}
+
+func TestHomeDirInstall(t *testing.T) {
+	home := createHome(t)
+	src := filepath.Join(t.TempDir(), "src")
+	if err := ioutil.WriteFile(src, []byte("hello"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := OpenHomeDir(home, ".config/app", testUID, testGID, 0700, true)
+	if err != nil {
+		t.Fatalf("OpenHomeDir() failed: %v", err)
+	}
+	defer d.Close()
+
+	if err := d.Install("conf", src, 0640); err != nil {
+		t.Fatalf("Install() failed: %v", err)
+	}
+	hash, _ := HashFile(src)
+	if d.Hash("conf") != hash || d.Mode("conf") != 0640 {
+		t.Errorf("Installed file has hash %q and mode %v", d.Hash("conf"), d.Mode("conf"))
+	}
+
+	for _, p := range []string{".config", ".config/app", ".config/app/conf"} {
+		info, err := os.Lstat(filepath.Join(home, p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uid, gid := FileOwner(info); uid != testUID || gid != testGID {
+			t.Errorf("%s owned by %d:%d, want %d:%d", p, uid, gid, testUID, testGID)
+		}
+	}
+}
+
+func TestHomeDirLinks(t *testing.T) {
+	home := createHome(t)
+
+	// A link in place of a directory is not followed
+	if err := os.Symlink(t.TempDir(), filepath.Join(home, "dir")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenHomeDir(home, "dir/sub", testUID, testGID, 0700, true); err == nil {
+		t.Errorf("OpenHomeDir() followed a link")
+	}
+
+	// Nor is one in place of a file
+	victim := filepath.Join(t.TempDir(), "victim")
+	if err := ioutil.WriteFile(victim, []byte("root's"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(victim, filepath.Join(home, "file")); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := OpenHomeDir(home, ".", testUID, testGID, 0700, false)
+	if err != nil {
+		t.Fatalf("OpenHomeDir() failed: %v", err)
+	}
+	defer d.Close()
+
+	corrections, err := d.Reconcile("file", 0644)
+	if err != nil || len(corrections) != 0 {
+		t.Errorf("Reconcile() of a link = %q, %v, want no corrections", corrections, err)
+	}
+	if d.Hash("file") != "" {
+		t.Errorf("Hash() followed a link")
+	}
+	if info, _ := os.Stat(victim); info.Mode().Perm() != 0600 {
+		t.Errorf("Link target changed to %v", info.Mode())
+	}
+
+	// Symlink replaces the link itself
+	changed, err := d.Symlink("file", "target")
+	if err != nil || !changed {
+		t.Fatalf("Symlink() = %v, %v", changed, err)
+	}
+	if target, _ := os.Readlink(filepath.Join(home, "file")); target != "target" {
+		t.Errorf("Link points to %q, want target", target)
+	}
+	if changed, err := d.Symlink("file", "target"); err != nil || changed {
+		t.Errorf("Symlink() again = %v, %v, want no change", changed, err)
+	}
+}
+
+func TestHomeDirOwners(t *testing.T) {
+	home := createHome(t)
+
+	other := filepath.Join(home, "other")
+	if err := os.Mkdir(other, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chown(other, testUID+10, testGID+10); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := OpenHomeDir(home, "other/sub", testUID, testGID, 0700, true); err == nil {
+		t.Errorf("OpenHomeDir() below a directory of another user succeeded")
+	}
+
+	if _, err := OpenHomeDir(home, "../x", testUID, testGID, 0700, true); err == nil {
+		t.Errorf("OpenHomeDir() above the home directory succeeded")
+	}
+}
@@ -0,0 +1,25 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+)
+
+// HomeDir - Per-user files are not supported on Windows
+type HomeDir struct{}
+
+// OpenHomeDir - Per-user files are not supported on Windows
+func OpenHomeDir(home string, dir string, uid int, gid int, perms os.FileMode, create bool) (*HomeDir, error) {
+	return nil, fmt.Errorf("Per-user files are not supported on Windows")
+}
+
+// Name, Close, Hash, Mode, Install, Mkdir, Symlink, Reconcile - Never
+// called, OpenHomeDir fails
+func (d *HomeDir) Name() string                                               { return "" }
+func (d *HomeDir) Close() error                                               { return nil }
+func (d *HomeDir) Hash(name string) string                                    { return "" }
+func (d *HomeDir) Mode(name string) os.FileMode                               { return 0 }
+func (d *HomeDir) Install(name string, src string, perms os.FileMode) error   { return nil }
+func (d *HomeDir) Mkdir(name string, perms os.FileMode) (bool, error)         { return false, nil }
+func (d *HomeDir) Symlink(name string, target string) (bool, error)           { return false, nil }
+func (d *HomeDir) Reconcile(name string, perms os.FileMode) ([]string, error) { return nil, nil }
@@ -0,0 +1,91 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import (
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// setProcessAttributes - Start the command in its own process group,
+// optionally as another user and group
+func setProcessAttributes(c *exec.Cmd, userString, groupString string) error {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if userString == "" && groupString == "" {
+		return nil
+	}
+
+	uid, gid, err := LookupIDs(userString, groupString)
+	if err != nil {
+		return err
+	}
+
+	if uid == -1 {
+		uid = os.Getuid()
+	}
+	if gid == -1 {
+		gid = os.Getgid()
+	}
+
+	// With only a group the supplementary groups are kept, Go would drop
+	// them otherwise
+	c.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid), NoSetGroups: userString == ""}
+
+	if userString != "" {
+		u, err := user.LookupId(strconv.Itoa(uid))
+		if err == nil {
+			c.SysProcAttr.Credential.Groups = supplementaryGroups(u)
+			c.Env = append(c.Env, "HOME="+u.HomeDir, "USER="+u.Username, "LOGNAME="+u.Username)
+		}
+	}
+	return nil
+}
+
+// supplementaryGroups - GIDs of the groups u is a member of
+func supplementaryGroups(u *user.User) []uint32 {
+	ids, err := u.GroupIds()
+	if err != nil {
+		return nil
+	}
+
+	var groups []uint32
+	for _, id := range ids {
+		gid, err := strconv.ParseUint(id, 10, 32)
+		if err == nil {
+			groups = append(groups, uint32(gid))
+		}
+	}
+	return groups
+}
+
+// killProcessGroup - Kill the command and everything it spawned
+func killProcessGroup(c *exec.Cmd) {
+	if c.Process == nil {
+		return
+	}
+	syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+}
+
+// syncDir - Flush directory entries (e.g. a rename) to disk
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
+
+// FileOwner - UID and GID of a file
+func FileOwner(fileInfo os.FileInfo) (int, int) {
+	stat, ok := fileInfo.Sys().(*syscall.Stat_t)
+	if !ok {
+		return -1, -1
+	}
+	return int(stat.Uid), int(stat.Gid)
+}
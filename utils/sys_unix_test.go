@@ -0,0 +1,95 @@
+//go:build !windows
+// +build !windows
+
+package utils
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSetProcessAttributes(t *testing.T) {
+	if os.Getuid() != 0 {
+		t.Skip("Switching users requires root")
+	}
+
+	u, err := user.LookupId("0")
+	if err != nil {
+		t.Skip(err)
+	}
+	want := supplementaryGroups(u)
+
+	// A user gets the supplementary groups of that user
+	c := exec.Command("id")
+	err = setProcessAttributes(c, u.Username, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred := c.SysProcAttr.Credential
+	if cred.NoSetGroups || len(cred.Groups) != len(want) {
+		t.Errorf("user: Groups = %v, NoSetGroups = %v, want %v", cred.Groups, cred.NoSetGroups, want)
+	}
+	if !containsEnv(c.Env, "USER="+u.Username) {
+		t.Errorf("user: Env = %v, want USER=%s", c.Env, u.Username)
+	}
+
+	// A group alone keeps the current supplementary groups
+	c = exec.Command("id")
+	err = setProcessAttributes(c, "", strconv.Itoa(os.Getgid()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.SysProcAttr.Credential.NoSetGroups {
+		t.Errorf("group: NoSetGroups = false")
+	}
+
+	// Nothing to switch to starts a process group only
+	c = exec.Command("id")
+	err = setProcessAttributes(c, "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !c.SysProcAttr.Setpgid || c.SysProcAttr.Credential != nil {
+		t.Errorf("none: SysProcAttr = %+v", c.SysProcAttr)
+	}
+}
+
+func containsEnv(env []string, value string) bool {
+	for _, e := range env {
+		if e == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestCommandRunBackground(t *testing.T) {
+	// The backgrounded sleep keeps the output open but is not waited for
+	cmd := Command{Command: "sleep 30 & echo $!", Timeout: 5}
+
+	start := time.Now()
+	output, err := cmd.Run(context.Background())
+	if err != nil {
+		t.Fatalf("Run() failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Run() took %v, waited for the background process", elapsed)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(string(output)))
+	if err != nil {
+		t.Fatalf("Run() output %q is not a PID", output)
+	}
+	defer syscall.Kill(pid, syscall.SIGKILL)
+
+	if err := syscall.Kill(pid, 0); err != nil {
+		t.Errorf("Background process was killed: %v", err)
+	}
+}
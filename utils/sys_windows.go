@@ -0,0 +1,34 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// setProcessAttributes - Process groups and user switching are not
+// supported on Windows
+func setProcessAttributes(c *exec.Cmd, userString, groupString string) error {
+	if userString != "" || groupString != "" {
+		return fmt.Errorf("Running commands as another user is not supported")
+	}
+	return nil
+}
+
+// killProcessGroup - Kill the command
+func killProcessGroup(c *exec.Cmd) {
+	if c.Process == nil {
+		return
+	}
+	c.Process.Kill()
+}
+
+// syncDir - Directories cannot be synced on Windows
+func syncDir(dir string) error {
+	return nil
+}
+
+// FileOwner - Ownership is not tracked on Windows
+func FileOwner(fileInfo os.FileInfo) (int, int) {
+	return -1, -1
+}
@@ -3,10 +3,16 @@ package utils
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
-	"os/exec"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
@@ -17,6 +23,45 @@ var (
 	unixEpochTime = time.Unix(0, 0)
 )
 
+// ModeBits - Permission bits and the setuid, setgid and sticky bits, the
+// part of a file mode that chmod sets
+const ModeBits = os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky
+
+// FormatMode - Octal permissions of mode including the setuid, setgid and
+// sticky bits, e.g. 4755
+func FormatMode(mode os.FileMode) string {
+	bits := uint32(mode.Perm())
+	if mode&os.ModeSetuid != 0 {
+		bits |= 04000
+	}
+	if mode&os.ModeSetgid != 0 {
+		bits |= 02000
+	}
+	if mode&os.ModeSticky != 0 {
+		bits |= 01000
+	}
+	return fmt.Sprintf("%04o", bits)
+}
+
+// Temporary files that have not been removed yet
+var tempFiles = struct {
+	sync.Mutex
+	paths map[string]bool
+}{paths: map[string]bool{}}
+
+// contextReader - Reader that fails once its context is cancelled
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (cr *contextReader) Read(p []byte) (int, error) {
+	if err := cr.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cr.r.Read(p)
+}
+
 // DeepCompare - Compare two files to see if they contain the same
 // content. If an errors occur false is returned.
 // Adapted from:
@@ -62,6 +107,84 @@ func IsZeroTime(t time.Time) bool {
 	return t.IsZero() || t.Equal(unixEpochTime)
 }
 
+// LookupIDs - Resolve a user and group (names or numeric IDs) to a UID and
+// GID. Empty values resolve to -1. The group defaults to the user's primary
+// group when only the user is given.
+func LookupIDs(userString, groupString string) (uid int, gid int, err error) {
+	uid, gid = -1, -1
+
+	if userString != "" {
+		u, err := user.Lookup(userString)
+		if err != nil {
+			u, err = user.LookupId(userString)
+			if err != nil {
+				return uid, gid, fmt.Errorf("Unknown user %s", userString)
+			}
+		}
+
+		uid, err = strconv.Atoi(u.Uid)
+		if err != nil {
+			return uid, gid, err
+		}
+
+		gid, err = strconv.Atoi(u.Gid)
+		if err != nil {
+			return uid, gid, err
+		}
+	}
+
+	if groupString != "" {
+		g, err := user.LookupGroup(groupString)
+		if err != nil {
+			g, err = user.LookupGroupId(groupString)
+			if err != nil {
+				return uid, gid, fmt.Errorf("Unknown group %s", groupString)
+			}
+		}
+
+		gid, err = strconv.Atoi(g.Gid)
+		if err != nil {
+			return uid, gid, err
+		}
+	}
+	return
+}
+
+// LookupOwner - UID and GID for file ownership (-1 to leave unchanged). Numbers
+// are used as they are, so IDs without a user or group entry work.
+func LookupOwner(userString, groupString string) (uid int, gid int, err error) {
+	uid, gid = -1, -1
+
+	if userString != "" {
+		uid, err = strconv.Atoi(userString)
+		if err != nil {
+			u, err := user.Lookup(userString)
+			if err != nil {
+				return -1, -1, fmt.Errorf("Unknown user %s", userString)
+			}
+			uid, err = strconv.Atoi(u.Uid)
+			if err != nil {
+				return -1, -1, err
+			}
+		}
+	}
+
+	if groupString != "" {
+		gid, err = strconv.Atoi(groupString)
+		if err != nil {
+			g, err := user.LookupGroup(groupString)
+			if err != nil {
+				return -1, -1, fmt.Errorf("Unknown group %s", groupString)
+			}
+			gid, err = strconv.Atoi(g.Gid)
+			if err != nil {
+				return -1, -1, err
+			}
+		}
+	}
+	return uid, gid, nil
+}
+
 // PublicKeyFile reads public key's from a private key file into memory
 func PublicKeyFile(file string) ssh.AuthMethod {
 	buffer, err := ioutil.ReadFile(file)
@@ -77,10 +200,219 @@ func PublicKeyFile(file string) ssh.AuthMethod {
 	return ssh.PublicKeys(key)
 }
 
-// RunCmd executes a cmd from a string (todo: better support)
-func RunCmd(cmd string, timeout int) error {
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(timeout)*time.Second)
-	defer cancel()
-	err := exec.CommandContext(ctx, "sh", "-c", cmd).Run()
-	return err
+// MkdirAll - Create a directory and any missing parents with the given
+// permissions (not subject to the umask)
+func MkdirAll(path string, perms os.FileMode) error {
+	fileInfo, err := os.Stat(path)
+	if err == nil {
+		if !fileInfo.IsDir() {
+			return fmt.Errorf("Not a directory: %s", path)
+		}
+		return nil
+	}
+
+	parent := filepath.Dir(path)
+	if parent != path {
+		err = MkdirAll(parent, perms)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.Mkdir(path, perms)
+	if err != nil {
+		if os.IsExist(err) {
+			return nil
+		}
+		return err
+	}
+	return os.Chmod(path, perms)
+}
+
+// ReplaceFile - Atomically replace dst with src. Both must be on the same
+// filesystem. The file and its directory are synced so the new content
+// survives a crash. A symbolic link src is moved as it is, it need not
+// point anywhere.
+func ReplaceFile(src, dst string) error {
+	fileInfo, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	if fileInfo.Mode()&os.ModeSymlink == 0 {
+		f, err := os.Open(src)
+		if err != nil {
+			return err
+		}
+		err = f.Sync()
+		f.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.Rename(src, dst)
+	if err != nil {
+		return err
+	}
+
+	return syncDir(filepath.Dir(dst))
+}
+
+// ReplaceDir - Swap the directory src in for dst. The previous dst is moved
+// aside and returned as a tracked temp path ("" if dst did not exist) so it
+// can be restored or removed. Both must be on the same filesystem. On Linux
+// the two are exchanged atomically, elsewhere dst is missing for a moment.
+func ReplaceDir(src, dst string) (string, error) {
+	fileInfo, err := os.Lstat(dst)
+	if os.IsNotExist(err) {
+		err = os.Rename(src, dst)
+		if err != nil {
+			return "", err
+		}
+		return "", syncDir(filepath.Dir(dst))
+	} else if err != nil {
+		return "", err
+	}
+	if !fileInfo.IsDir() {
+		return "", fmt.Errorf("Not a directory: %s", dst)
+	}
+
+	// src is left holding the previous directory
+	exchanged, err := exchange(src, dst)
+	if err != nil {
+		return "", err
+	}
+	if exchanged {
+		TrackTempFile(src)
+		return src, syncDir(filepath.Dir(dst))
+	}
+
+	prevPath, err := ioutil.TempDir(filepath.Dir(dst), "."+filepath.Base(dst)+".ironsync")
+	if err != nil {
+		return "", err
+	}
+	os.Remove(prevPath)
+
+	err = os.Rename(dst, prevPath)
+	if err != nil {
+		return "", err
+	}
+	TrackTempFile(prevPath)
+
+	err = os.Rename(src, dst)
+	if err != nil {
+		// Never remove the previous directory while it is not back in place
+		tempFiles.Lock()
+		delete(tempFiles.paths, prevPath)
+		tempFiles.Unlock()
+
+		restoreErr := os.Rename(prevPath, dst)
+		if restoreErr != nil {
+			return "", fmt.Errorf("%v, previous directory left at %s", err, prevPath)
+		}
+		return "", err
+	}
+
+	return prevPath, syncDir(filepath.Dir(dst))
+}
+
+// KeepFile - Keep a copy of path next to it (hard linked when possible) and
+// return the tracked temp file path. Returns "" if path does not exist.
+func KeepFile(path string) (string, error) {
+	fileInfo, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+
+	tmpFile, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path)+".ironsync")
+	if err != nil {
+		return "", err
+	}
+	tmpPath := tmpFile.Name()
+	TrackTempFile(tmpPath)
+
+	// A hard link keeps the content, permissions and ownership
+	tmpFile.Close()
+	os.Remove(tmpPath)
+	err = os.Link(path, tmpPath)
+	if err == nil {
+		return tmpPath, nil
+	}
+
+	err = CopyFile(path, tmpPath, fileInfo.Mode())
+	if err != nil {
+		RemoveTempFile(tmpPath)
+		return "", err
+	}
+	return tmpPath, nil
+}
+
+// CopyFile - Copy src to dst, creating dst with the given permissions
+func CopyFile(src, dst string, perms os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perms)
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// HashFile - Hex encoded SHA-256 of a file's content
+func HashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	_, err = io.Copy(h, f)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// CopyContext - io.Copy that stops between reads once ctx is cancelled
+func CopyContext(ctx context.Context, dst io.Writer, src io.Reader) (int64, error) {
+	return io.Copy(dst, &contextReader{ctx, src})
+}
+
+// TrackTempFile - Remember a temporary file so it can be removed on shutdown
+func TrackTempFile(path string) {
+	tempFiles.Lock()
+	defer tempFiles.Unlock()
+	tempFiles.paths[path] = true
+}
+
+// RemoveTempFile - Remove a tracked temporary file or directory
+func RemoveTempFile(path string) error {
+	tempFiles.Lock()
+	delete(tempFiles.paths, path)
+	tempFiles.Unlock()
+	return os.RemoveAll(path)
+}
+
+// RemoveTempFiles - Remove all tracked temporary files
+func RemoveTempFiles() {
+	tempFiles.Lock()
+	defer tempFiles.Unlock()
+	for path := range tempFiles.paths {
+		os.RemoveAll(path)
+		delete(tempFiles.paths, path)
+	}
 }
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestMkdirAll(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Permissions are not set on Windows")
+	}
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a", "b", "c")
+	// Group write is usually masked by the umask, which must not apply
+	if err := MkdirAll(path, 0775); err != nil {
+		t.Fatalf("MkdirAll() failed: %v", err)
+	}
+
+	for _, p := range []string{filepath.Join(dir, "a"), filepath.Join(dir, "a", "b"), path} {
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !info.IsDir() || info.Mode().Perm() != 0775 {
+			t.Errorf("%s has mode %v, want a directory with 0775", p, info.Mode())
+		}
+	}
+
+	if err := MkdirAll(path, 0700); err != nil {
+		t.Errorf("MkdirAll() on an existing directory failed: %v", err)
+	}
+
+	file := filepath.Join(dir, "file")
+	if err := ioutil.WriteFile(file, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := MkdirAll(filepath.Join(file, "sub"), 0755); err == nil {
+		t.Errorf("MkdirAll() below a file succeeded")
+	}
+}
+
+func TestReplaceFile(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, ".dst.ironsync1")
+	dst := filepath.Join(dir, "dst")
+
+	for _, content := range []string{"first", "second"} {
+		if err := ioutil.WriteFile(src, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := ReplaceFile(src, dst); err != nil {
+			t.Fatalf("ReplaceFile() failed: %v", err)
+		}
+
+		got, err := ioutil.ReadFile(dst)
+		if err != nil || string(got) != content {
+			t.Errorf("dst = %q, %v, want %q", got, err, content)
+		}
+		if _, err := os.Stat(src); !os.IsNotExist(err) {
+			t.Errorf("src still exists after ReplaceFile()")
+		}
+	}
+
+	if err := ReplaceFile(filepath.Join(dir, "missing"), dst); err == nil {
+		t.Errorf("ReplaceFile() of a missing file succeeded")
+	}
+}